@@ -0,0 +1,145 @@
+package cf
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const appIndexStoragePrefix = "index/apps/"
+
+func putAppIndexEntry(ctx context.Context, storage logical.Storage, indexEntry *models.AppIndexEntry) error {
+	entry, err := logical.StorageEntryJSON(appIndexStoragePrefix+indexEntry.AppID, indexEntry)
+	if err != nil {
+		return err
+	}
+	return storage.Put(ctx, entry)
+}
+
+func getAppIndexEntry(ctx context.Context, storage logical.Storage, appID string) (*models.AppIndexEntry, error) {
+	entry, err := storage.Get(ctx, appIndexStoragePrefix+appID)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	indexEntry := &models.AppIndexEntry{}
+	if err := entry.DecodeJSON(indexEntry); err != nil {
+		return nil, err
+	}
+	return indexEntry, nil
+}
+
+// indexAppLogin records that an instance of the app logged in at the given time. If the app was
+// already found to have been deleted, it stays marked as deleted, since a login may have been
+// served CF API records cached from before it was.
+func (b *backend) indexAppLogin(ctx context.Context, storage logical.Storage, appID, spaceID, orgID string, now time.Time) error {
+	lock := locksutil.LockForKey(b.appIndexLocks, appID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	indexEntry, err := getAppIndexEntry(ctx, storage, appID)
+	if err != nil {
+		return err
+	}
+	if indexEntry == nil {
+		indexEntry = &models.AppIndexEntry{
+			AppID: appID,
+			OrgID: orgID,
+		}
+	}
+	indexEntry.SpaceID = spaceID
+	indexEntry.LastLogin = now
+	return putAppIndexEntry(ctx, storage, indexEntry)
+}
+
+// reconcileApps marks the indexed apps that have been deleted from CF so that renewals of
+// their tokens are refused. Vault doesn't give plugins a way to revoke the tokens they've
+// issued, so a deleted app's existing tokens remain valid until they're next renewed or expire.
+func (b *backend) reconcileApps(ctx context.Context, storage logical.Storage, client *cfclient.Client, now time.Time) error {
+	appIDs, err := storage.List(ctx, appIndexStoragePrefix)
+	if err != nil {
+		return err
+	}
+	for _, appID := range appIDs {
+		if err := b.reconcileApp(ctx, storage, client, appID, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileApp marks one indexed app as deleted if it's been deleted from CF, as reconcileApps
+// describes.
+func (b *backend) reconcileApp(ctx context.Context, storage logical.Storage, client *cfclient.Client, appID string, now time.Time) error {
+	lock := locksutil.LockForKey(b.appIndexLocks, appID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	indexEntry, err := getAppIndexEntry(ctx, storage, appID)
+	if err != nil {
+		return err
+	}
+	if indexEntry == nil || indexEntry.Deleted() {
+		return nil
+	}
+	deleted, err := appDeleted(client, indexEntry)
+	if err != nil {
+		// Other apps may still be checked, and this one will be checked again next time.
+		b.Logger().Warn(fmt.Sprintf("unable to check whether app %s still exists: %s", appID, err))
+		return nil
+	}
+	if !deleted {
+		return nil
+	}
+	b.Logger().Info(fmt.Sprintf("app %s has been deleted from CF; renewals of its tokens will be refused", appID))
+	indexEntry.DeletedAt = now
+	return putAppIndexEntry(ctx, storage, indexEntry)
+}
+
+// tidyApps removes the indexed apps that haven't logged in since before the cutoff. Apps found to
+// have been deleted are kept until they were found to be deleted before it too, since tokens
+// issued to a deleted app are refused on renewal by its deletion being recorded, even by roles that
+// don't check CF on renewal.
+func (b *backend) tidyApps(ctx context.Context, storage logical.Storage, cutoff time.Time) (int, error) {
+	appIDs, err := storage.List(ctx, appIndexStoragePrefix)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, appID := range appIDs {
+		tidied, err := b.tidyApp(ctx, storage, appID, cutoff)
+		if err != nil {
+			return removed, err
+		}
+		if tidied {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// tidyApp removes one indexed app if tidyApps would, and returns whether it did.
+func (b *backend) tidyApp(ctx context.Context, storage logical.Storage, appID string, cutoff time.Time) (bool, error) {
+	lock := locksutil.LockForKey(b.appIndexLocks, appID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	indexEntry, err := getAppIndexEntry(ctx, storage, appID)
+	if err != nil {
+		return false, err
+	}
+	if indexEntry != nil && (!indexEntry.LastLogin.Before(cutoff) || indexEntry.Deleted() && !indexEntry.DeletedAt.Before(cutoff)) {
+		return false, nil
+	}
+	if err := storage.Delete(ctx, appIndexStoragePrefix+appID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
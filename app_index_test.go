@@ -0,0 +1,73 @@
+package cf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault-plugin-auth-cf/testing/cf"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestReconcileApps(t *testing.T) {
+	b := newTestBackend(t)
+	ctx, storage := b.ctx, b.storage
+
+	cfServer := cf.MockServer(false, nil)
+	defer cfServer.Close()
+
+	entry, err := logical.StorageEntryJSON(configStorageKey, &models.Configuration{
+		CFAPIAddr:                 cfServer.URL,
+		CFUsername:                cf.AuthUsername,
+		CFPassword:                cf.AuthPassword,
+		AppReconciliationInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	indexEntries := []*models.AppIndexEntry{
+		{AppID: cf.FoundAppGUID, SpaceID: cf.FoundSpaceGUID, OrgID: cf.FoundOrgGUID},
+		{AppID: cf.UnfoundAppGUID, SpaceID: cf.FoundSpaceGUID, OrgID: cf.FoundOrgGUID},
+	}
+	for _, indexEntry := range indexEntries {
+		if err := putAppIndexEntry(ctx, storage, indexEntry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.periodicFunc(ctx, &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := getAppIndexEntry(ctx, storage, cf.FoundAppGUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Deleted() {
+		t.Fatal("expected the existing app not to be marked as deleted")
+	}
+	unfound, err := getAppIndexEntry(ctx, storage, cf.UnfoundAppGUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !unfound.Deleted() {
+		t.Fatal("expected the missing app to be marked as deleted")
+	}
+
+	// A login served CF API records cached from before the app was deleted doesn't undo it.
+	loggedIn := time.Now()
+	if err := b.indexAppLogin(ctx, storage, cf.UnfoundAppGUID, "other-space-id", cf.FoundOrgGUID, loggedIn); err != nil {
+		t.Fatal(err)
+	}
+	relogged, err := getAppIndexEntry(ctx, storage, cf.UnfoundAppGUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !relogged.DeletedAt.Equal(unfound.DeletedAt) || !relogged.LastLogin.Equal(loggedIn) || relogged.SpaceID != "other-space-id" {
+		t.Fatalf("expected only the login to be recorded but received %#v", relogged)
+	}
+}
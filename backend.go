@@ -2,8 +2,18 @@ package cf
 
 import (
 	"context"
+	"crypto/x509"
+	"sync"
+	"time"
 
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault-plugin-auth-cf/signatures"
+	"github.com/hashicorp/vault-plugin-auth-cf/util"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/helper/salt"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -11,25 +21,71 @@ const (
 	// These env vars are used frequently to pull the client certificate and private key
 	// from CF containers; thus are placed here for ease of discovery and use from
 	// outside packages.
-	EnvVarInstanceCertificate = "CF_INSTANCE_CERT"
-	EnvVarInstanceKey         = "CF_INSTANCE_KEY"
+	EnvVarInstanceCertificate = signatures.EnvVarInstanceCertificate
+	EnvVarInstanceKey         = signatures.EnvVarInstanceKey
+
+	// EnvVarInstanceKeyPassphrase isn't set by CF, but may be set to the passphrase
+	// of an encrypted private key used in place of the one at CF_INSTANCE_KEY.
+	EnvVarInstanceKeyPassphrase = signatures.EnvVarInstanceKeyPassphrase
 )
 
 func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
-	b := &backend{}
+	return newBackend(ctx, conf, nil)
+}
+
+// FactoryWithTransportWrapper returns a factory like Factory, whose backends pass every request they
+// make to the CF API and UAA through the transport returned by wrap. Programs that embed the plugin can
+// use it to instrument those requests, such as with OpenTelemetry's otelhttp.NewTransport. Requests made
+// while handling a login or renewal carry its Vault request ID in their X-Vcap-Request-Id header.
+func FactoryWithTransportWrapper(wrap util.TransportWrapper) logical.Factory {
+	return func(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
+		return newBackend(ctx, conf, wrap)
+	}
+}
+
+func newBackend(ctx context.Context, conf *logical.BackendConfig, wrap util.TransportWrapper) (logical.Backend, error) {
+	b := &backend{
+		failures:        newFailureLog(maxRecordedFailures),
+		limiter:         newFailureLimiter(),
+		cfAPICache:      newCFAPICache(conf.Logger),
+		roleLocks:       locksutil.CreateLocks(),
+		tokenQuotaLocks: locksutil.CreateLocks(),
+		tokenIndexLocks: locksutil.CreateLocks(),
+		appIndexLocks:   locksutil.CreateLocks(),
+		wrapTransport:   wrap,
+	}
 	b.Backend = &framework.Backend{
-		AuthRenew: b.pathLoginRenew,
-		Help:      backendHelp,
+		AuthRenew:      b.pathLoginRenew,
+		InitializeFunc: b.initialize,
+		Invalidate:     b.invalidate,
+		PeriodicFunc:   b.periodicFunc,
+		Help:           backendHelp,
 		PathsSpecial: &logical.Paths{
+			Root:            []string{"diagnostics/*", "tokens/*", "export", "import"},
 			SealWrapStorage: []string{"config"},
-			Unauthenticated: []string{"login"},
+			Unauthenticated: []string{"login", "audience"},
 		},
-		Paths: []*framework.Path{
+		Paths: append([]*framework.Path{
 			b.pathConfig(),
+			b.pathConfigCA(),
 			b.pathListRoles(),
 			b.pathRoles(),
 			b.pathLogin(),
-		},
+			b.pathDiagnosticsFailures(),
+			b.pathVerify(),
+			b.pathRenewWithCert(),
+			b.pathTidy(),
+			b.pathAudience(),
+			b.pathListTokensByApp(),
+			b.pathTokensByApp(),
+			b.pathTokensByAppRevoke(),
+			b.pathCacheStatus(),
+			b.pathCacheEntries(),
+			b.pathListMaps(),
+			b.pathHealthCFCredential(),
+			b.pathExport(),
+			b.pathImport(),
+		}, b.pathMaps()...),
 		BackendType: logical.TypeCredential,
 	}
 	if err := b.Setup(ctx, conf); err != nil {
@@ -40,6 +96,205 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 
 type backend struct {
 	*framework.Backend
+
+	// failures holds recent login failures for lookup by their failure ID.
+	failures *failureLog
+
+	// limiter locks out sources of repeated login failures.
+	limiter *failureLimiter
+
+	// wrapTransport, if set, wraps the transport of every CF API client the backend builds.
+	wrapTransport util.TransportWrapper
+
+	// cfClient is shared between requests so connections to the CF API are reused.
+	// It's built lazily from the config, and must be reset whenever the config changes.
+	// cfClientRevision is the revision of the config it was built from.
+	cfClientLock     sync.RWMutex
+	cfClient         *cfclient.Client
+	cfClientRevision int
+
+	// identityCAPool holds the configured identity CA certificates, parsed, so that they needn't be
+	// parsed for every login. Like cfClient, it's built lazily and reset whenever the config changes.
+	identityCAPoolLock sync.RWMutex
+	identityCAPool     *identityCAPool
+
+	// salt is used to hash the token metadata that's configured to be hashed. It's created lazily.
+	saltLock sync.RWMutex
+	salt     *salt.Salt
+
+	// cfAPICache holds records fetched from the CF API while checking logins and renewals.
+	cfAPICache *cfAPICache
+
+	// configLock and roleLocks are held while the config or a role is read, changed, and stored,
+	// so that a write checking its revision can't be interleaved with another.
+	configLock sync.Mutex
+	roleLocks  []*locksutil.LockEntry
+
+	// tokenQuotaLocks are held while the tokens counted against a quota are read, changed, and stored,
+	// so that concurrent logins can't exceed it.
+	tokenQuotaLocks []*locksutil.LockEntry
+
+	// tokenIndexLocks are held while an app's indexed tokens are read, changed, and stored, so that
+	// concurrent logins don't lose each other's tokens and none of them can undo a cut-off.
+	tokenIndexLocks []*locksutil.LockEntry
+
+	// appIndexLocks are held while an indexed app is read, changed, and stored, so that a login
+	// can't undo the app being found to have been deleted.
+	appIndexLocks []*locksutil.LockEntry
+
+	// lastReconciliation and lastTidy are when the indexed apps were last reconciled against CF
+	// and when storage was last tidied. They're only used by the periodic func, which Vault never
+	// runs concurrently.
+	lastReconciliation time.Time
+	lastTidy           time.Time
+
+	// lastHealthCheck is when the config was last checked for upcoming problems. Like the above, it's
+	// only used by the periodic func.
+	lastHealthCheck time.Time
+
+	// cfCredential is what the last check of the CF API credentials found.
+	cfCredential cfCredentialHealth
+
+	// tidyRunning is set while a tidy is in progress, so that only one runs at a time.
+	tidyRunning uint32
+}
+
+// periodicFunc is called by Vault about once a minute. It prunes the CF API cache, reconciles
+// the indexed apps against CF and tidies storage whenever their configured intervals have passed,
+// and hourly warns about problems that will cause logins to fail.
+func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	config, err := config(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+
+	now := time.Now()
+	b.cfAPICache.prune(cfAPICacheRetention(config), now)
+	if now.Sub(b.lastHealthCheck) >= healthCheckInterval {
+		b.lastHealthCheck = now
+		b.checkHealth(config, now)
+	}
+	if config.AppReconciliationInterval > 0 && now.Sub(b.lastReconciliation) >= config.AppReconciliationInterval {
+		b.lastReconciliation = now
+		client, err := b.getCFClient(config)
+		if err != nil {
+			return err
+		}
+		if err := b.reconcileApps(ctx, req.Storage, client, now); err != nil {
+			return err
+		}
+	}
+	if config.TidyInterval > 0 && now.Sub(b.lastTidy) >= config.TidyInterval {
+		b.lastTidy = now
+		if _, err := b.tidy(ctx, req.Storage, config, defaultTidySafetyBuffer, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getCFClient returns the shared CF API client, building it from the given config if needed. The
+// client is only reused if it was built from the same revision of the config, so a request that
+// read the config just before it changed can't leave behind a client of the old one.
+func (b *backend) getCFClient(config *models.Configuration) (*cfclient.Client, error) {
+	b.cfClientLock.RLock()
+	client, revision := b.cfClient, b.cfClientRevision
+	b.cfClientLock.RUnlock()
+	if client != nil && revision == config.Revision {
+		recordClientCache(true)
+		return client, nil
+	}
+
+	b.cfClientLock.Lock()
+	defer b.cfClientLock.Unlock()
+	// Another request may have built it while we were waiting for the lock.
+	if b.cfClient != nil && b.cfClientRevision == config.Revision {
+		recordClientCache(true)
+		return b.cfClient, nil
+	}
+	recordClientCache(false)
+	client, err := b.newCFClient(config)
+	if err != nil {
+		return nil, err
+	}
+	if b.cfClient != nil {
+		// The records the old client fetched may be from the CF API another revision pointed at.
+		b.cfAPICache.clear()
+	}
+	b.cfClient, b.cfClientRevision = client, config.Revision
+	return client, nil
+}
+
+// newCFClient builds a CF API client from the given config, without sharing it.
+func (b *backend) newCFClient(config *models.Configuration) (*cfclient.Client, error) {
+	return util.NewCFClientWithTransportWrapper(config, b.wrapTransport)
+}
+
+// resetCFClient discards the shared CF API client so it'll be rebuilt from the current config.
+func (b *backend) resetCFClient() {
+	b.setCFClient(nil, 0)
+}
+
+// setCFClient replaces the shared CF API client, such as with one just built from a new config, so that
+// its connections are reused. The revision is that of the config it was built from. The records the old
+// one fetched are discarded, since the config may now point at a different CF API.
+func (b *backend) setCFClient(client *cfclient.Client, revision int) {
+	b.cfClientLock.Lock()
+	defer b.cfClientLock.Unlock()
+	b.cfClient, b.cfClientRevision = client, revision
+	b.cfAPICache.clear()
+}
+
+// identityCAPool is a pool of identity CA certificates, along with the PEM-format certificates it
+// was built from.
+type identityCAPool struct {
+	caCerts []string
+	roots   *x509.CertPool
+}
+
+// getIdentityCAPool returns the pool of the identity CA certificates in the given config, parsing
+// them if needed. The pool is only reused if it was built from the same certificates, so a login
+// that read the config just before it changed can't leave behind a pool of the old ones.
+func (b *backend) getIdentityCAPool(config *models.Configuration) (*x509.CertPool, error) {
+	b.identityCAPoolLock.RLock()
+	pool := b.identityCAPool
+	b.identityCAPoolLock.RUnlock()
+	if pool != nil && strutil.EquivalentSlices(pool.caCerts, config.IdentityCACertificates) {
+		return pool.roots, nil
+	}
+
+	roots, err := util.NewCertPool(config.IdentityCACertificates)
+	if err != nil {
+		return nil, err
+	}
+	b.identityCAPoolLock.Lock()
+	b.identityCAPool = &identityCAPool{caCerts: config.IdentityCACertificates, roots: roots}
+	b.identityCAPoolLock.Unlock()
+	return roots, nil
+}
+
+// resetIdentityCAPool discards the parsed identity CA certificates so they'll be parsed again from
+// the current config.
+func (b *backend) resetIdentityCAPool() {
+	b.identityCAPoolLock.Lock()
+	defer b.identityCAPoolLock.Unlock()
+	b.identityCAPool = nil
+}
+
+// invalidate is called when storage is changed by another Vault node, such as a performance secondary.
+func (b *backend) invalidate(_ context.Context, key string) {
+	switch key {
+	case configStorageKey:
+		b.resetCFClient()
+		b.resetIdentityCAPool()
+	case salt.DefaultLocation:
+		b.saltLock.Lock()
+		b.salt = nil
+		b.saltLock.Unlock()
+	}
 }
 
 const backendHelp = `
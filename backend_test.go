@@ -2,10 +2,15 @@ package cf
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -15,10 +20,75 @@ import (
 	"github.com/hashicorp/vault-plugin-auth-cf/signatures"
 	"github.com/hashicorp/vault-plugin-auth-cf/testing/certificates"
 	"github.com/hashicorp/vault-plugin-auth-cf/testing/cf"
+	"github.com/hashicorp/vault-plugin-auth-cf/util"
+	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/parseutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
+// testBackend is a backend with in-memory storage, for tests that make requests of it directly.
+type testBackend struct {
+	*backend
+	t       testing.TB
+	ctx     context.Context
+	storage *logical.InmemStorage
+}
+
+// newTestBackend returns a backend that discards its logs and has the default system view.
+func newTestBackend(t testing.TB) *testBackend {
+	return newTestBackendWithConfig(t, hclog.NewNullLogger(), &logical.StaticSystemView{})
+}
+
+// newTestBackendWithConfig returns a backend that logs to the given logger and has the given system view.
+func newTestBackendWithConfig(t testing.TB, logger hclog.Logger, system logical.SystemView) *testBackend {
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	b, err := Factory(ctx, &logical.BackendConfig{
+		StorageView: storage,
+		Logger:      logger,
+		System:      system,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testBackend{
+		backend: b.(*backend),
+		t:       t,
+		ctx:     ctx,
+		storage: storage,
+	}
+}
+
+// handle makes a request of the backend with its storage, failing the test if it returns an error.
+// Error responses are returned for the test to check.
+func (b *testBackend) handle(operation logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	return b.handleRequest(&logical.Request{
+		Operation: operation,
+		Path:      path,
+		Data:      data,
+	})
+}
+
+// handleRequest is like handle, but makes the given request, which is given the backend's storage.
+func (b *testBackend) handleRequest(req *logical.Request) *logical.Response {
+	req.Storage = b.storage
+	resp, err := b.HandleRequest(b.ctx, req)
+	if err != nil {
+		b.t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	return resp
+}
+
+// mustHandle is like handle, but also fails the test if the response is an error.
+func (b *testBackend) mustHandle(operation logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	resp := b.handle(operation, path, data)
+	if resp != nil && resp.IsError() {
+		b.t.Fatalf("bad: resp: %#v\nerr:%v", resp, nil)
+	}
+	return resp
+}
+
 func TestBackend(t *testing.T) {
 	ctx := context.Background()
 	storage := &logical.InmemStorage{}
@@ -42,14 +112,16 @@ func TestBackend(t *testing.T) {
 	defer cfServer.Close()
 
 	testConf := &models.Configuration{
-		IdentityCACertificates: []string{testCerts.CACertificate, string(invalidCaCertBytes)},
-		CFAPIAddr:              cfServer.URL,
-		CFUsername:             cf.AuthUsername,
-		CFPassword:             cf.AuthPassword,
-		CFClientID:             cf.AuthClientID,
-		CFClientSecret:         cf.AuthClientSecret,
-		LoginMaxSecNotBefore:   5,
-		LoginMaxSecNotAfter:    1,
+		IdentityCACertificates:   []string{testCerts.CACertificate, string(invalidCaCertBytes)},
+		CFAPIAddr:                cfServer.URL,
+		CFUsername:               cf.AuthUsername,
+		CFPassword:               cf.AuthPassword,
+		CFClientID:               cf.AuthClientID,
+		CFClientSecret:           cf.AuthClientSecret,
+		LoginMaxSecNotBefore:     5,
+		LoginMaxSecNotAfter:      1,
+		EnableTLSClientCertLogin: true,
+		XFCCTrustedProxyCIDRs:    []string{"10.255.181.105/32"},
 	}
 
 	backend, err := Factory(ctx, &logical.BackendConfig{
@@ -93,6 +165,7 @@ func TestBackend(t *testing.T) {
 	t.Run("read config", env.ReadConfig)
 	t.Run("update config", env.UpdateConfig)
 	t.Run("read updated config", env.ReadUpdatedConfig)
+	t.Run("check and set config", env.CheckAndSetConfig)
 	t.Run("delete config", env.DeleteConfig)
 	t.Run("create role", env.CreateRole)
 	t.Run("update role", env.UpdateRole)
@@ -104,6 +177,209 @@ func TestBackend(t *testing.T) {
 	t.Run("create config", env.CreateConfig)
 	t.Run("create role", env.CreateRole)
 	t.Run("login", env.Login)
+	t.Run("renew", env.Renew)
+	t.Run("renew with cert", env.RenewWithCert)
+	t.Run("cut off app", env.CutOffApp)
+	t.Run("login with signature version", env.LoginWithSignatureVersion)
+	t.Run("login with audience", env.LoginWithAudience)
+	t.Run("login without role", env.LoginWithoutRole)
+	t.Run("login with token delivery settings", env.LoginWithTokenDeliverySettings)
+	t.Run("login with tls client cert", env.LoginWithTLSClientCert)
+	t.Run("login with xfcc", env.LoginWithXFCC)
+	t.Run("verify", env.Verify)
+}
+
+func TestOpenAPI(t *testing.T) {
+	b := newTestBackend(t)
+	resp := b.mustHandle(logical.HelpOperation, "", nil)
+	doc, ok := resp.Data["openapi"].(*framework.OASDocument)
+	if !ok {
+		t.Fatalf("expected an OpenAPI document but received %#v", resp.Data["openapi"])
+	}
+	for path, item := range doc.Paths {
+		for method, op := range map[string]*framework.OASOperation{
+			"get":    item.Get,
+			"post":   item.Post,
+			"delete": item.Delete,
+		} {
+			if op != nil && op.Summary == "" {
+				t.Errorf("expected %s %s to have a summary", method, path)
+			}
+		}
+	}
+
+	// Operations that don't document their responses are given a generic one, so the documented
+	// ones are checked by their status codes and descriptions.
+	for path, methods := range map[string]map[string][]int{
+		"/login":           {"post": {200, 400}},
+		"/verify":          {"post": {200}},
+		"/renew-with-cert": {"post": {200, 400}},
+		"/audience":        {"get": {200}},
+		"/config":          {"get": {200, 204}},
+		"/config/ca":       {"get": {200, 204}},
+		"/roles/{role}":    {"get": {200, 204}},
+	} {
+		item, ok := doc.Paths[path]
+		if !ok {
+			t.Fatalf("expected %s to be documented", path)
+		}
+		for method, statuses := range methods {
+			op := map[string]*framework.OASOperation{"get": item.Get, "post": item.Post}[method]
+			if op == nil {
+				t.Fatalf("expected %s %s to be documented", method, path)
+			}
+			for _, status := range statuses {
+				response, ok := op.Responses[status]
+				if !ok || response == framework.OASStdRespOK || response == framework.OASStdRespNoContent {
+					t.Errorf("expected %s %s to document its %d response but received %#v", method, path, status, response)
+				}
+			}
+		}
+	}
+	if doc.Paths["/login"].Post.Responses[200].Content["application/json"].Schema.Example == nil {
+		t.Fatal("expected an example response for logging in")
+	}
+}
+
+func TestExistenceChecks(t *testing.T) {
+	b := newTestBackend(t)
+	ctx, storage := b.ctx, b.storage
+	for _, path := range []string{"config", "roles/test-role"} {
+		req := &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      path,
+			Storage:   storage,
+		}
+		checkFound, exists, err := b.HandleExistenceCheck(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !checkFound || exists {
+			t.Fatalf("expected %s to be checked and not to exist", path)
+		}
+		if err := storage.Put(ctx, &logical.StorageEntry{Key: path, Value: []byte("{}")}); err != nil {
+			t.Fatal(err)
+		}
+		if _, exists, err = b.HandleExistenceCheck(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatalf("expected %s to exist", path)
+		}
+	}
+}
+
+func TestConfigSecrets(t *testing.T) {
+	b := newTestBackend(t)
+	fields := b.pathConfig().Fields
+	for _, name := range []string{"cf_password", "pcf_password", "cf_client_secret", "cf_api_mutual_tls_key"} {
+		if fields[name].DisplayAttrs == nil || !fields[name].DisplayAttrs.Sensitive {
+			t.Errorf("expected %s to be marked sensitive", name)
+		}
+	}
+	if !strutil.StrListContains(b.PathsSpecial.SealWrapStorage, configStorageKey) {
+		t.Error("expected the config to be seal wrapped")
+	}
+}
+
+func TestIdentityCAPool(t *testing.T) {
+	testCerts, err := certificates.Generate(cf.FoundServiceGUID, cf.FoundOrgGUID, cf.FoundSpaceGUID, cf.FoundAppGUID, "10.255.181.105")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := testCerts.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	otherCerts, err := certificates.Generate(cf.FoundServiceGUID, cf.FoundOrgGUID, cf.FoundSpaceGUID, cf.FoundAppGUID, "10.255.181.105")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := otherCerts.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	b := newTestBackend(t)
+	config := &models.Configuration{IdentityCACertificates: []string{testCerts.CACertificate}}
+
+	roots, err := b.getIdentityCAPool(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := b.getIdentityCAPool(config); again != roots {
+		t.Fatal("expected the parsed pool to be reused")
+	}
+
+	// A config with other certificates mustn't be given the pool of the old ones.
+	other, err := b.getIdentityCAPool(&models.Configuration{IdentityCACertificates: []string{otherCerts.CACertificate}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == roots {
+		t.Fatal("expected a new pool for other certificates")
+	}
+	intermediateCerts, identityCert, err := util.ExtractCertificates(testCerts.InstanceCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.ValidateWithRoots(other, intermediateCerts, identityCert, identityCert); err == nil {
+		t.Fatal("expected the certificate not to chain to the other CA")
+	}
+
+	b.resetIdentityCAPool()
+	if again, _ := b.getIdentityCAPool(config); again == roots {
+		t.Fatal("expected the pool to be parsed again after a reset")
+	}
+	if _, err := b.getIdentityCAPool(&models.Configuration{IdentityCACertificates: []string{"not a certificate"}}); err == nil {
+		t.Fatal("expected an error for a certificate that can't be parsed")
+	}
+}
+
+func TestCFClient(t *testing.T) {
+	cfServer := cf.NewServer(cf.DefaultFoundation())
+	defer cfServer.Close()
+	otherCFServer := cf.NewServer(cf.DefaultFoundation())
+	defer otherCFServer.Close()
+	b := newTestBackend(t)
+	config := &models.Configuration{
+		CFAPIAddr:  cfServer.URL,
+		CFUsername: cf.AuthUsername,
+		CFPassword: cf.AuthPassword,
+		Revision:   1,
+	}
+	staleConfig := &models.Configuration{
+		CFAPIAddr:  otherCFServer.URL,
+		CFUsername: cf.AuthUsername,
+		CFPassword: cf.AuthPassword,
+	}
+
+	client, err := b.getCFClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := b.getCFClient(config); again != client {
+		t.Fatal("expected the client to be reused")
+	}
+
+	// A request that read the config before it was changed mustn't leave its client to the others,
+	// even if it builds one after the client's been reset.
+	b.resetCFClient()
+	stale, err := b.getCFClient(staleConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale.Config.ApiAddress != otherCFServer.URL {
+		t.Fatalf("expected a client of the stale config but received one of %s", stale.Config.ApiAddress)
+	}
+	current, err := b.getCFClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current == stale || current.Config.ApiAddress != cfServer.URL {
+		t.Fatalf("expected a client of the current config but received one of %s", current.Config.ApiAddress)
+	}
 }
 
 func TestBackendMTLS(t *testing.T) {
@@ -198,6 +474,119 @@ func TestBackendMTLS(t *testing.T) {
 	t.Run("login", env.Login)
 }
 
+func TestBackendServiceInstanceLogin(t *testing.T) {
+	// Certificates issued to service instances have no app.
+	testCerts, err := certificates.Generate(cf.FoundServiceGUID, cf.FoundOrgGUID, cf.FoundSpaceGUID, "", "10.255.181.105")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := testCerts.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	cfServer := cf.MockServer(false, nil)
+	defer cfServer.Close()
+
+	b := newTestBackendWithConfig(t, hclog.NewNullLogger(), &logical.StaticSystemView{
+		DefaultLeaseTTLVal: time.Hour,
+		MaxLeaseTTLVal:     time.Hour,
+	})
+
+	env := &Env{
+		Ctx:     b.ctx,
+		Storage: b.storage,
+		Backend: b.backend,
+		TestConf: &models.Configuration{
+			IdentityCACertificates: []string{testCerts.CACertificate},
+			CFAPIAddr:              cfServer.URL,
+			CFUsername:             cf.AuthUsername,
+			CFPassword:             cf.AuthPassword,
+			LoginMaxSecNotBefore:   5,
+			LoginMaxSecNotAfter:    1,
+		},
+		TestCerts: testCerts,
+	}
+	t.Run("create config", env.CreateConfig)
+
+	writeRole := func(data map[string]interface{}) *logical.Response {
+		return b.handle(logical.UpdateOperation, "roles/test-role", data)
+	}
+	if resp := writeRole(map[string]interface{}{
+		"bound_application_ids":        []string{cf.FoundAppGUID},
+		"allow_service_instance_login": true,
+	}); !resp.IsError() {
+		t.Fatal("expected bound app IDs to be refused on a role allowing service instance logins")
+	}
+
+	login := func() (*logical.Response, error) {
+		signingTime := time.Now()
+		signature, err := signatures.Sign(testCerts.PathToInstanceKey, &signatures.SignatureData{
+			SigningTime:            signingTime,
+			Role:                   "test-role",
+			CFInstanceCertContents: testCerts.InstanceCertificate,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.HandleRequest(b.ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login",
+			Storage:   b.storage,
+			Data: map[string]interface{}{
+				"role":             "test-role",
+				"signature":        signature,
+				"signing_time":     signingTime.UTC().Format(signatures.TimeFormat),
+				"cf_instance_cert": testCerts.InstanceCertificate,
+			},
+			Connection: &logical.Connection{
+				RemoteAddr: "10.255.181.105",
+			},
+		})
+	}
+
+	if resp := writeRole(map[string]interface{}{
+		"bound_instance_ids": []string{cf.FoundServiceGUID},
+	}); resp != nil {
+		t.Fatalf("expected nil response to represent a 204 but received %#v", resp)
+	}
+	if resp, err := login(); err == nil && !resp.IsError() {
+		t.Fatal("expected a service instance login to fail on a role not allowing it")
+	}
+
+	if resp := writeRole(map[string]interface{}{
+		"allow_service_instance_login": true,
+	}); resp != nil {
+		t.Fatalf("expected nil response to represent a 204 but received %#v", resp)
+	}
+	resp, err := login()
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if resp.Auth.Alias.Name != cf.FoundServiceGUID {
+		t.Fatalf("expected %s but received %s", cf.FoundServiceGUID, resp.Auth.Alias.Name)
+	}
+	if resp.Auth.Metadata["service_instance_name"] != cf.FoundServiceInstanceName {
+		t.Fatalf("expected %s but received %s", cf.FoundServiceInstanceName, resp.Auth.Metadata["service_instance_name"])
+	}
+	if _, ok := resp.Auth.Metadata["app_id"]; ok {
+		t.Fatalf("expected no app ID but received %s", resp.Auth.Metadata["app_id"])
+	}
+
+	renewResp := b.handleRequest(&logical.Request{
+		Operation: logical.RenewOperation,
+		Path:      "login",
+		Auth:      resp.Auth,
+		Connection: &logical.Connection{
+			RemoteAddr: "10.255.181.105",
+		},
+	})
+	if renewResp != nil && renewResp.IsError() {
+		t.Fatalf("bad: resp: %#v", renewResp)
+	}
+}
+
 type Env struct {
 	Ctx     context.Context
 	Storage logical.Storage
@@ -206,6 +595,9 @@ type Env struct {
 	TestConf  *models.Configuration
 	TestRole  *models.RoleEntry
 	TestCerts *certificates.TestCertificates
+
+	// LoginAuth is the auth returned by the Login step, for steps that renew it.
+	LoginAuth *logical.Auth
 }
 
 func (e *Env) StoreV0Config(t *testing.T) {
@@ -268,6 +660,8 @@ func (e *Env) CreateConfig(t *testing.T) {
 			"cf_client_secret":              e.TestConf.CFClientSecret,
 			"login_max_seconds_not_before":  12,
 			"login_max_seconds_not_after":   13,
+			"enable_tls_client_cert_login":  e.TestConf.EnableTLSClientCertLogin,
+			"xfcc_trusted_proxy_cidrs":      e.TestConf.XFCCTrustedProxyCIDRs,
 		},
 	}
 	resp, err := e.Backend.HandleRequest(e.Ctx, req)
@@ -321,6 +715,46 @@ func (e *Env) ReadConfig(t *testing.T) {
 	if resp.Data["cf_client_secret"] != nil {
 		t.Fatalf("expected %s but received %s", "nil", resp.Data["cf_client_secret"])
 	}
+	if resp.Data["alias_name_source"] != "app_id" {
+		t.Fatalf("expected %s but received %s", "app_id", resp.Data["alias_name_source"])
+	}
+}
+
+func (e *Env) CheckAndSetConfig(t *testing.T) {
+	// The config has been created and updated once.
+	write := func(cas int) *logical.Response {
+		resp, err := e.Backend.HandleRequest(e.Ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   e.Storage,
+			Data: map[string]interface{}{
+				"login_max_seconds_not_after": 14,
+				"cas":                         cas,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	if resp := write(1); resp == nil || !resp.IsError() {
+		t.Fatalf("expected a stale revision to be refused but received %#v", resp)
+	}
+	if resp := write(2); resp != nil {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+
+	resp, err := e.Backend.HandleRequest(e.Ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config",
+		Storage:   e.Storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if resp.Data["revision"] != 3 {
+		t.Fatalf("expected revision 3 but received %v", resp.Data["revision"])
+	}
 }
 
 func (e *Env) UpdateConfig(t *testing.T) {
@@ -397,6 +831,9 @@ func (e *Env) DeleteConfig(t *testing.T) {
 	if val != nil {
 		t.Fatal("config shouldn't still be in storage")
 	}
+	if e.Backend.(*backend).cfClient != nil {
+		t.Fatal("the CF API client shouldn't outlive the config it was built from")
+	}
 }
 
 func (e *Env) CreateRole(t *testing.T) {
@@ -652,8 +1089,12 @@ func (e *Env) Login(t *testing.T) {
 		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
 	}
 
-	if resp.Auth.DisplayName != cf.FoundServiceGUID {
-		t.Fatalf("expected %s but received %s", cf.FoundServiceGUID, resp.Auth.DisplayName)
+	expectedDisplayName := fmt.Sprintf("%s-%d", cf.FoundServiceGUID, cf.FoundInstanceIndex)
+	if resp.Auth.DisplayName != expectedDisplayName {
+		t.Fatalf("expected %s but received %s", expectedDisplayName, resp.Auth.DisplayName)
+	}
+	if resp.Auth.Metadata["instance_index"] != strconv.Itoa(cf.FoundInstanceIndex) {
+		t.Fatalf("expected %d but received %s", cf.FoundInstanceIndex, resp.Auth.Metadata["instance_index"])
 	}
 	if len(resp.Auth.Policies) != 2 {
 		t.Fatalf("expected 2 policies but received %d", len(resp.Auth.Policies))
@@ -664,6 +1105,9 @@ func (e *Env) Login(t *testing.T) {
 	if resp.Auth.InternalData["instance_id"] != cf.FoundServiceGUID {
 		t.Fatalf("expected %s but received %s", cf.FoundServiceGUID, resp.Auth.InternalData["instance_id"])
 	}
+	if resp.Auth.Alias.Metadata["instance_id"] != cf.FoundServiceGUID {
+		t.Fatalf("expected %s but received %s", cf.FoundServiceGUID, resp.Auth.Alias.Metadata["instance_id"])
+	}
 	if resp.Auth.Alias.Metadata["org_id"] != cf.FoundOrgGUID {
 		t.Fatalf("expected %s but received %s", cf.FoundOrgGUID, resp.Auth.Alias.Metadata["org_id"])
 	}
@@ -682,6 +1126,21 @@ func (e *Env) Login(t *testing.T) {
 	if resp.Auth.Alias.Metadata["space_name"] != cf.FoundSpaceName {
 		t.Fatalf("expected %s but received %s", cf.FoundSpaceName, resp.Auth.Alias.Metadata["space_name"])
 	}
+	if resp.Auth.Metadata["cert_not_after"] != resp.Auth.InternalData["cert_not_after"] {
+		t.Fatalf("expected %s but received %s", resp.Auth.InternalData["cert_not_after"], resp.Auth.Metadata["cert_not_after"])
+	}
+	if _, err := time.Parse(time.RFC3339, resp.Auth.Metadata["cert_not_after"]); err != nil {
+		t.Fatal(err)
+	}
+	tokenMetadata := make(map[string]string)
+	for k, v := range resp.Auth.Metadata {
+		if k != "cert_not_after" {
+			tokenMetadata[k] = v
+		}
+	}
+	if !reflect.DeepEqual(tokenMetadata, resp.Auth.Alias.Metadata) {
+		t.Fatalf("expected token metadata %v to match alias metadata %v", resp.Auth.Metadata, resp.Auth.Alias.Metadata)
+	}
 	if resp.Auth.InternalData["ip_addresses"] != nil {
 		t.Fatalf("expected %s but received %s", "", resp.Auth.InternalData["ip_addresses"])
 	}
@@ -697,6 +1156,686 @@ func (e *Env) Login(t *testing.T) {
 	if resp.Auth.LeaseOptions.MaxTTL != time.Minute*2 {
 		t.Fatalf("expected 2 minutes but received %s", resp.Auth.LeaseOptions.MaxTTL)
 	}
+	if e.Backend.(*backend).cfClient == nil {
+		t.Fatal("expected the CF API client to be kept for reuse")
+	}
+	e.LoginAuth = resp.Auth
+}
+
+func (e *Env) Renew(t *testing.T) {
+	renew := func() (*logical.Response, error) {
+		return e.Backend.HandleRequest(e.Ctx, &logical.Request{
+			Operation: logical.RenewOperation,
+			Path:      "login",
+			Storage:   e.Storage,
+			Auth:      e.LoginAuth,
+			Connection: &logical.Connection{
+				RemoteAddr: "10.255.181.105",
+			},
+		})
+	}
+	setRenewalCheck := func(field string, disabled bool) {
+		resp, err := e.Backend.HandleRequest(e.Ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/test-role",
+			Storage:   e.Storage,
+			Data: map[string]interface{}{
+				field: disabled,
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+		}
+	}
+	storeConfig := func(conf *models.Configuration) {
+		entry, err := logical.StorageEntryJSON(configStorageKey, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Storage.Put(e.Ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+		e.Backend.(*backend).resetCFClient()
+	}
+
+	resp, err := renew()
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if resp.Auth.TTL != e.TestRole.TTL*time.Second {
+		t.Fatalf("expected %s but received %s", e.TestRole.TTL*time.Second, resp.Auth.TTL)
+	}
+
+	// With the CF API unreachable, renewals only succeed if the role doesn't require checking it.
+	storedConf, err := config(e.Ctx, e.Storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachableConf := *storedConf
+	unreachableConf.CFAPIAddr = "http://127.0.0.1:1"
+	storeConfig(&unreachableConf)
+	defer storeConfig(storedConf)
+
+	if resp, err := renew(); err == nil && !resp.IsError() {
+		t.Fatal("expected renewal to fail while the CF API is unreachable")
+	}
+	setRenewalCheck("disable_cf_api_renewal_check", true)
+	defer setRenewalCheck("disable_cf_api_renewal_check", false)
+	resp, err = renew()
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+
+	// Renewals are refused once the certificate used to log in has expired, unless the role allows them.
+	certNotAfter := e.LoginAuth.InternalData["cert_not_after"]
+	e.LoginAuth.InternalData["cert_not_after"] = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	defer func() { e.LoginAuth.InternalData["cert_not_after"] = certNotAfter }()
+
+	resp, err = renew()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsError() || !strings.Contains(resp.Error().Error(), "expired") {
+		t.Fatalf("expected renewal to fail with an expired certificate but received %#v", resp)
+	}
+	setRenewalCheck("disable_cert_expiry_renewal_check", true)
+	defer setRenewalCheck("disable_cert_expiry_renewal_check", false)
+	resp, err = renew()
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+}
+
+func (e *Env) RenewWithCert(t *testing.T) {
+	renew := func() (*logical.Response, error) {
+		return e.Backend.HandleRequest(e.Ctx, &logical.Request{
+			Operation: logical.RenewOperation,
+			Path:      "login",
+			Storage:   e.Storage,
+			Auth:      e.LoginAuth,
+			Connection: &logical.Connection{
+				RemoteAddr: "10.255.181.105",
+			},
+		})
+	}
+	presentCert := func(accessor string) (*logical.Response, error) {
+		signingTime := time.Now()
+		signature, err := signatures.Sign(e.TestCerts.PathToInstanceKey, &signatures.SignatureData{
+			SigningTime:            signingTime,
+			Role:                   "test-role",
+			CFInstanceCertContents: e.TestCerts.InstanceCertificate,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e.Backend.HandleRequest(e.Ctx, &logical.Request{
+			Operation:           logical.UpdateOperation,
+			Path:                "renew-with-cert",
+			Storage:             e.Storage,
+			ClientTokenAccessor: accessor,
+			Data: map[string]interface{}{
+				"role":             "test-role",
+				"signature":        signature,
+				"signing_time":     signingTime.UTC().Format(signatures.TimeFormat),
+				"cf_instance_cert": e.TestCerts.InstanceCertificate,
+			},
+			Connection: &logical.Connection{
+				RemoteAddr: "10.255.181.105",
+			},
+		})
+	}
+
+	// The token's certificate is made to have expired, as if it had since been rotated.
+	e.LoginAuth.Accessor = "test-accessor"
+	certNotAfter := e.LoginAuth.InternalData["cert_not_after"]
+	e.LoginAuth.InternalData["cert_not_after"] = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	defer func() {
+		e.LoginAuth.Accessor = ""
+		e.LoginAuth.InternalData["cert_not_after"] = certNotAfter
+	}()
+	if resp, err := renew(); err != nil || !resp.IsError() {
+		t.Fatalf("expected renewal to fail with an expired certificate but received %#v, %v", resp, err)
+	}
+
+	if resp, err := presentCert(""); err != nil || !resp.IsError() {
+		t.Fatalf("expected a certificate presented without a token to be refused but received %#v, %v", resp, err)
+	}
+
+	// A certificate presented for another token doesn't help this one.
+	resp, err := presentCert("other-accessor")
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if resp, err := renew(); err != nil || !resp.IsError() {
+		t.Fatalf("expected renewal to fail with another token's certificate but received %#v, %v", resp, err)
+	}
+
+	resp, err = presentCert("test-accessor")
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if resp.Data["cert_not_after"] != certNotAfter || resp.Data["instance_id"] != cf.FoundServiceGUID {
+		t.Fatalf("expected the presented certificate to be described but received %#v", resp.Data)
+	}
+	resp, err = renew()
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+
+	// The certificate is only used for the instance it was presented for.
+	e.LoginAuth.InternalData["instance_id"] = "another-instance"
+	defer func() { e.LoginAuth.InternalData["instance_id"] = cf.FoundServiceGUID }()
+	if resp, err := renew(); err != nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "expired") {
+		t.Fatalf("expected renewal to fail for another instance but received %#v, %v", resp, err)
+	}
+}
+
+func (e *Env) CutOffApp(t *testing.T) {
+	handle := func(operation logical.Operation, path string) *logical.Response {
+		req := &logical.Request{
+			Operation: operation,
+			Path:      path,
+			Storage:   e.Storage,
+			Connection: &logical.Connection{
+				RemoteAddr: "10.255.181.105",
+			},
+		}
+		if operation == logical.RenewOperation {
+			req.Auth = e.LoginAuth
+		}
+		if path == "login" && operation == logical.UpdateOperation {
+			signingTime := time.Now()
+			signature, err := signatures.Sign(e.TestCerts.PathToInstanceKey, &signatures.SignatureData{
+				SigningTime:            signingTime,
+				Role:                   "test-role",
+				CFInstanceCertContents: e.TestCerts.InstanceCertificate,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Data = map[string]interface{}{
+				"role":             "test-role",
+				"signature":        signature,
+				"signing_time":     signingTime.UTC().Format(signatures.TimeFormat),
+				"cf_instance_cert": e.TestCerts.InstanceCertificate,
+			}
+		}
+		resp, err := e.Backend.HandleRequest(e.Ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	byAppPath := "tokens/by-app/" + cf.FoundAppGUID
+
+	// Tokens are indexed at login, and by their accessor once they're renewed.
+	loginResp := handle(logical.UpdateOperation, "login")
+	if loginResp == nil || loginResp.IsError() {
+		t.Fatalf("expected login to succeed but received %#v", loginResp)
+	}
+	loginID := loginResp.Auth.InternalData["token_index_id"].(string)
+	resp := handle(logical.ReadOperation, byAppPath)
+	if resp == nil || resp.Data["unrenewed_tokens"].(map[string]string)[loginID] == "" {
+		t.Fatalf("expected the issued token to be indexed but received %#v", resp)
+	}
+	loginResp.Auth.Accessor = "cut-off-accessor"
+	renewResp, err := e.Backend.HandleRequest(e.Ctx, &logical.Request{
+		Operation: logical.RenewOperation,
+		Path:      "login",
+		Storage:   e.Storage,
+		Auth:      loginResp.Auth,
+		Connection: &logical.Connection{
+			RemoteAddr: "10.255.181.105",
+		},
+	})
+	if err != nil || renewResp == nil || renewResp.IsError() {
+		t.Fatalf("expected renewal to succeed but received %#v, %v", renewResp, err)
+	}
+	resp = handle(logical.ReadOperation, byAppPath)
+	if resp == nil || !strutil.StrListContains(resp.Data["accessors"].([]string), "cut-off-accessor") || resp.Data["unrenewed_tokens"].(map[string]string)[loginID] != "" || resp.Data["revoked_at"] != "" {
+		t.Fatalf("expected the renewed token to be indexed by its accessor but received %#v", resp)
+	}
+
+	resp = handle(logical.UpdateOperation, byAppPath+"/revoke")
+	if resp == nil || resp.IsError() || resp.Data["revoked_at"] == "" {
+		t.Fatalf("expected the app to be cut off but received %#v", resp)
+	}
+	if resp := handle(logical.RenewOperation, "login"); resp == nil || !resp.IsError() {
+		t.Fatalf("expected renewal to be refused but received %#v", resp)
+	}
+	if resp := handle(logical.UpdateOperation, "login"); resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), failureCategoryRevoked) {
+		t.Fatalf("expected login to be refused but received %#v", resp)
+	}
+
+	handle(logical.DeleteOperation, byAppPath)
+	if resp := handle(logical.UpdateOperation, "login"); resp == nil || resp.IsError() {
+		t.Fatalf("expected login to succeed once the cut-off is lifted but received %#v", resp)
+	}
+}
+
+func (e *Env) LoginWithSignatureVersion(t *testing.T) {
+	login := func(version int) *logical.Response {
+		signingTime := time.Now()
+		signature, err := signatures.SignVersion(e.TestCerts.PathToInstanceKey, nil, version, &signatures.SignatureData{
+			SigningTime:            signingTime,
+			Role:                   "test-role",
+			CFInstanceCertContents: e.TestCerts.InstanceCertificate,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := e.Backend.HandleRequest(e.Ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login",
+			Storage:   e.Storage,
+			Data: map[string]interface{}{
+				"role":             "test-role",
+				"signature":        signature,
+				"signing_time":     signingTime.UTC().Format(signatures.TimeFormat),
+				"cf_instance_cert": e.TestCerts.InstanceCertificate,
+			},
+			Connection: &logical.Connection{
+				RemoteAddr: "10.255.181.105",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	setMinimumVersion := func(version int) {
+		resp, err := e.Backend.HandleRequest(e.Ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   e.Storage,
+			Data: map[string]interface{}{
+				"minimum_signature_version": version,
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+		}
+	}
+
+	if resp := login(signatures.Version2); resp == nil || resp.IsError() {
+		t.Fatalf("expected a v2 signature to be accepted but received %#v", resp)
+	}
+
+	// Once v2 is required, v1 signatures are refused.
+	setMinimumVersion(signatures.Version2)
+	defer setMinimumVersion(signatures.Version1)
+	if resp := login(signatures.Version1); resp == nil || !resp.IsError() {
+		t.Fatalf("expected a v1 signature to be refused but received %#v", resp)
+	}
+	if resp := login(signatures.Version2); resp == nil || resp.IsError() {
+		t.Fatalf("expected a v2 signature to be accepted but received %#v", resp)
+	}
+}
+
+func (e *Env) LoginWithAudience(t *testing.T) {
+	const mountAccessor = "auth_cf_1234"
+	login := func(signedAudience, sentAudience string) *logical.Response {
+		signingTime := time.Now()
+		signature, err := signatures.SignVersion(e.TestCerts.PathToInstanceKey, nil, signatures.Version2, &signatures.SignatureData{
+			SigningTime:            signingTime,
+			Role:                   "test-role",
+			CFInstanceCertContents: e.TestCerts.InstanceCertificate,
+			Audience:               signedAudience,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := e.Backend.HandleRequest(e.Ctx, &logical.Request{
+			Operation:     logical.UpdateOperation,
+			Path:          "login",
+			Storage:       e.Storage,
+			MountAccessor: mountAccessor,
+			Data: map[string]interface{}{
+				"role":             "test-role",
+				"signature":        signature,
+				"signing_time":     signingTime.UTC().Format(signatures.TimeFormat),
+				"cf_instance_cert": e.TestCerts.InstanceCertificate,
+				"audience":         sentAudience,
+			},
+			Connection: &logical.Connection{
+				RemoteAddr: "10.255.181.105",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	setRequireAudience := func(required bool) {
+		resp, err := e.Backend.HandleRequest(e.Ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   e.Storage,
+			Data: map[string]interface{}{
+				"require_audience": required,
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+		}
+	}
+
+	// Without a configured audience, it's the mount's accessor.
+	resp, err := e.Backend.HandleRequest(e.Ctx, &logical.Request{
+		Operation:     logical.ReadOperation,
+		Path:          "audience",
+		Storage:       e.Storage,
+		MountAccessor: mountAccessor,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if resp.Data["audience"] != mountAccessor {
+		t.Fatalf("expected %s but received %s", mountAccessor, resp.Data["audience"])
+	}
+
+	if resp := login(mountAccessor, mountAccessor); resp == nil || resp.IsError() {
+		t.Fatalf("expected a signature bound to this audience to be accepted but received %#v", resp)
+	}
+	if resp := login("auth_cf_5678", "auth_cf_5678"); resp == nil || !resp.IsError() {
+		t.Fatalf("expected a signature bound to another audience to be refused but received %#v", resp)
+	}
+	if resp := login("auth_cf_5678", mountAccessor); resp == nil || !resp.IsError() {
+		t.Fatalf("expected a signature bound to another audience to be refused but received %#v", resp)
+	}
+	if resp := login("", ""); resp == nil || resp.IsError() {
+		t.Fatalf("expected an unbound signature to be accepted but received %#v", resp)
+	}
+
+	setRequireAudience(true)
+	defer setRequireAudience(false)
+	if resp := login("", ""); resp == nil || !resp.IsError() {
+		t.Fatalf("expected an unbound signature to be refused but received %#v", resp)
+	}
+	if resp := login(mountAccessor, mountAccessor); resp == nil || resp.IsError() {
+		t.Fatalf("expected a signature bound to this audience to be accepted but received %#v", resp)
+	}
+}
+
+func (e *Env) LoginWithoutRole(t *testing.T) {
+	login := func() *logical.Response {
+		signingTime := time.Now()
+		signature, err := signatures.Sign(e.TestCerts.PathToInstanceKey, &signatures.SignatureData{
+			SigningTime:            signingTime,
+			CFInstanceCertContents: e.TestCerts.InstanceCertificate,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := e.Backend.HandleRequest(e.Ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login",
+			Storage:   e.Storage,
+			Data: map[string]interface{}{
+				"signature":        signature,
+				"signing_time":     signingTime.UTC().Format(signatures.TimeFormat),
+				"cf_instance_cert": e.TestCerts.InstanceCertificate,
+			},
+			Connection: &logical.Connection{
+				RemoteAddr: "10.255.181.105",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	updateConfig := func(data map[string]interface{}) *logical.Response {
+		resp, err := e.Backend.HandleRequest(e.Ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   e.Storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	defer updateConfig(map[string]interface{}{"enable_role_selection": false, "default_role": ""})
+
+	if resp := login(); resp == nil || !resp.IsError() {
+		t.Fatalf("expected a login without a role to be refused but received %#v", resp)
+	}
+
+	if resp := updateConfig(map[string]interface{}{"enable_role_selection": true}); resp != nil && resp.IsError() {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	resp := login()
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected a login without a role to be accepted but received %#v", resp)
+	}
+	if resp.Auth.InternalData["role"] != "test-role" {
+		t.Fatalf("expected %s to be selected but received %s", "test-role", resp.Auth.InternalData["role"])
+	}
+
+	if resp := updateConfig(map[string]interface{}{"default_role": "test-role"}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected a default role to be refused along with role selection but received %#v", resp)
+	}
+	if resp := updateConfig(map[string]interface{}{"enable_role_selection": false, "default_role": "test-role"}); resp != nil && resp.IsError() {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	resp = login()
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected a login without a role to use the default role but received %#v", resp)
+	}
+	if resp.Auth.InternalData["role"] != "test-role" {
+		t.Fatalf("expected %s to be used but received %s", "test-role", resp.Auth.InternalData["role"])
+	}
+}
+
+func (e *Env) LoginWithTokenDeliverySettings(t *testing.T) {
+	updateRole := func(data map[string]interface{}) {
+		resp, err := e.Backend.HandleRequest(e.Ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/test-role",
+			Storage:   e.Storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+		}
+	}
+	updateRole(map[string]interface{}{"response_wrap_ttl": "5m", "instance_bound_cidr_prefix_length": 32, "token_bound_cidrs": ""})
+	defer updateRole(map[string]interface{}{"response_wrap_ttl": 0, "instance_bound_cidr_prefix_length": 0, "token_bound_cidrs": "10.255.181.105/24"})
+
+	signingTime := time.Now()
+	signature, err := signatures.Sign(e.TestCerts.PathToInstanceKey, &signatures.SignatureData{
+		SigningTime:            signingTime,
+		Role:                   "test-role",
+		CFInstanceCertContents: e.TestCerts.InstanceCertificate,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := e.Backend.HandleRequest(e.Ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Storage:   e.Storage,
+		Data: map[string]interface{}{
+			"role":             "test-role",
+			"signature":        signature,
+			"signing_time":     signingTime.UTC().Format(signatures.TimeFormat),
+			"cf_instance_cert": e.TestCerts.InstanceCertificate,
+		},
+		Connection: &logical.Connection{
+			RemoteAddr: "10.255.181.105",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if resp.WrapInfo == nil || resp.WrapInfo.TTL != 5*time.Minute {
+		t.Fatalf("expected the response to be wrapped for 5 minutes but received %#v", resp.WrapInfo)
+	}
+	if len(resp.Auth.BoundCIDRs) != 1 || resp.Auth.BoundCIDRs[0].String() != "10.255.181.105" {
+		t.Fatalf("expected the token to be bound to the instance's IP address but received %v", resp.Auth.BoundCIDRs)
+	}
+}
+
+func (e *Env) LoginWithTLSClientCert(t *testing.T) {
+	intermediateCerts, identityCert, err := util.ExtractCertificates(e.TestCerts.InstanceCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Storage:   e.Storage,
+		Data: map[string]interface{}{
+			"role": "test-role",
+		},
+		Connection: &logical.Connection{
+			RemoteAddr: "10.255.181.105",
+			ConnState: &tls.ConnectionState{
+				PeerCertificates: append([]*x509.Certificate{identityCert}, intermediateCerts...),
+			},
+		},
+	}
+	resp, err := e.Backend.HandleRequest(e.Ctx, req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if resp.Auth.InternalData["instance_id"] != cf.FoundServiceGUID {
+		t.Fatalf("expected %s but received %s", cf.FoundServiceGUID, resp.Auth.InternalData["instance_id"])
+	}
+	if resp.Auth.Alias.Name != cf.FoundAppGUID {
+		t.Fatalf("expected %s but received %s", cf.FoundAppGUID, resp.Auth.Alias.Name)
+	}
+
+	// Without a client certificate, the request should be rejected.
+	req.Connection.ConnState = nil
+	resp, err = e.Backend.HandleRequest(e.Ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error response but received %#v", resp)
+	}
+
+	// The failure should be available to operators through its failure ID.
+	matches := regexp.MustCompile(`failure ID: ([0-9a-f-]+)`).FindStringSubmatch(resp.Error().Error())
+	if len(matches) != 2 {
+		t.Fatalf("expected a failure ID in %q", resp.Error())
+	}
+	resp, err = e.Backend.HandleRequest(e.Ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "diagnostics/failures/" + matches[1],
+		Storage:   e.Storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if resp == nil || resp.Data["category"] != failureCategoryInvalidRequest {
+		t.Fatalf("expected a failure with category %s but received %#v", failureCategoryInvalidRequest, resp)
+	}
+}
+
+func (e *Env) LoginWithXFCC(t *testing.T) {
+	header := fmt.Sprintf(`Hash=abc;Cert="%s"`, url.PathEscape(e.TestCerts.InstanceCertificate))
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Storage:   e.Storage,
+		Data: map[string]interface{}{
+			"role": "test-role",
+		},
+		Headers: map[string][]string{
+			"x-forwarded-client-cert": {header},
+		},
+		Connection: &logical.Connection{
+			RemoteAddr: "10.255.181.105",
+		},
+	}
+	resp, err := e.Backend.HandleRequest(e.Ctx, req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if resp.Auth.InternalData["instance_id"] != cf.FoundServiceGUID {
+		t.Fatalf("expected %s but received %s", cf.FoundServiceGUID, resp.Auth.InternalData["instance_id"])
+	}
+
+	// The header should be ignored when it doesn't come from a trusted proxy.
+	req.Connection.RemoteAddr = "10.255.181.106"
+	resp, err = e.Backend.HandleRequest(e.Ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error response but received %#v", resp)
+	}
+}
+
+func (e *Env) Verify(t *testing.T) {
+	signingTime := time.Now()
+	signature, err := signatures.Sign(e.TestCerts.PathToInstanceKey, &signatures.SignatureData{
+		SigningTime:            signingTime,
+		Role:                   "test-role",
+		CFInstanceCertContents: e.TestCerts.InstanceCertificate,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "verify",
+		Storage:   e.Storage,
+		Data: map[string]interface{}{
+			"role":             "test-role",
+			"signature":        signature,
+			"signing_time":     signingTime.UTC().Format(signatures.TimeFormat),
+			"cf_instance_cert": e.TestCerts.InstanceCertificate,
+		},
+		Connection: &logical.Connection{
+			RemoteAddr: "10.255.181.105",
+		},
+	}
+	resp, err := e.Backend.HandleRequest(e.Ctx, req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if resp.Auth != nil {
+		t.Fatal("verifying shouldn't issue a token")
+	}
+	if resp.Data["success"] != true {
+		t.Fatalf("expected success but received %#v", resp.Data)
+	}
+
+	// Signing for a different role should fail the signature check and skip those after it.
+	req.Data["role"] = "other-role"
+	if _, err := e.Backend.HandleRequest(e.Ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roles/other-role",
+		Storage:   e.Storage,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = e.Backend.HandleRequest(e.Ctx, req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if resp.Data["success"] != false {
+		t.Fatalf("expected failure but received %#v", resp.Data)
+	}
+	expected := map[string]string{
+		"request":           "passed",
+		"token_bound_cidrs": "skipped",
+		"signing_time":      "passed",
+		"signature":         "failed",
+		"certificate_chain": "skipped",
+		"role_constraints":  "skipped",
+		"cf_api":            "skipped",
+	}
+	for _, check := range resp.Data["checks"].([]map[string]interface{}) {
+		if check["status"] != expected[check["name"].(string)] {
+			t.Fatalf("expected %s to be %s but received %s", check["name"], expected[check["name"].(string)], check["status"])
+		}
+	}
 }
 
 // In testing, we found that some string arrays get their trailing \n stripped when
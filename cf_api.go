@@ -0,0 +1,238 @@
+package cf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	pkgerrors "github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// processStats is the subset of the v3 process stats response we use.
+type processStats struct {
+	Resources []struct {
+		Index              int    `json:"index"`
+		State              string `json:"state"`
+		InstanceGUID       string `json:"instance_guid"`
+		InstanceInternalIP string `json:"instance_internal_ip"`
+	} `json:"resources"`
+}
+
+// getInstanceIndex looks up the index of the app instance the certificate was issued to,
+// using the stats of the app's web process. Newer versions of the CF API report each
+// instance's GUID, which is matched against the certificate's instance ID; otherwise the
+// certificate's IP address is matched against each instance's internal IP. If no instance
+// matches, an error is returned.
+func getInstanceIndex(client *cfclient.Client, cfCert *models.CFCertificate) (int, error) {
+	stats, err := getProcessStats(client, cfCert.AppID)
+	if err != nil {
+		return 0, err
+	}
+	for _, instance := range stats.Resources {
+		if instance.InstanceGUID != "" && instance.InstanceGUID == cfCert.InstanceID {
+			return instance.Index, nil
+		}
+	}
+	for _, instance := range stats.Resources {
+		if instance.InstanceInternalIP != "" && instance.InstanceInternalIP == cfCert.IPAddress {
+			return instance.Index, nil
+		}
+	}
+	return 0, fmt.Errorf("no instance of app %s matches instance ID %s or IP address %s", cfCert.AppID, cfCert.InstanceID, cfCert.IPAddress)
+}
+
+// getStack looks up the stack with the given GUID, which the client can only list.
+func getStack(client *cfclient.Client, stackGUID string) (*cfclient.Stack, error) {
+	if stackGUID == "" {
+		return nil, errors.New("the app has no stack")
+	}
+	resp, err := client.DoRequest(client.NewRequest("GET", "/v2/stacks/"+stackGUID))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	resource := &cfclient.StacksResource{}
+	if err := json.NewDecoder(resp.Body).Decode(resource); err != nil {
+		return nil, err
+	}
+	resource.Entity.Guid = resource.Meta.Guid
+	return &resource.Entity, nil
+}
+
+// sharedIsolationSegment is the name of the isolation segment apps run in when neither their space nor
+// their org has been assigned one.
+const sharedIsolationSegment = "shared"
+
+// orgStatusSuspended is the status of orgs that operators have suspended, whose apps can no longer be
+// pushed, started, or scaled.
+const orgStatusSuspended = "suspended"
+
+// getIsolationSegmentName looks up the name of the isolation segment that apps in the given space run in.
+// That's the space's isolation segment, or the org's default one if the space has none.
+func getIsolationSegmentName(client *cfclient.Client, spaceID, orgID string) (string, error) {
+	segmentGUID, err := getRelationship(client, fmt.Sprintf("/v3/spaces/%s/relationships/isolation_segment", spaceID))
+	if err != nil {
+		return "", err
+	}
+	if segmentGUID == "" {
+		segmentGUID, err = getRelationship(client, fmt.Sprintf("/v3/organizations/%s/relationships/default_isolation_segment", orgID))
+		if err != nil {
+			return "", err
+		}
+	}
+	if segmentGUID == "" {
+		return sharedIsolationSegment, nil
+	}
+	segment, err := client.GetIsolationSegmentByGUID(segmentGUID)
+	if err != nil {
+		return "", err
+	}
+	return segment.Name, nil
+}
+
+// getRelationship looks up the GUID of the resource a v3 to-one relationship refers to, which is empty
+// if the relationship isn't set.
+func getRelationship(client *cfclient.Client, path string) (string, error) {
+	resp, err := client.DoRequest(client.NewRequest("GET", path))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	relationship := &struct {
+		Data *struct {
+			GUID string `json:"guid"`
+		} `json:"data"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(relationship); err != nil {
+		return "", err
+	}
+	if relationship.Data == nil {
+		return "", nil
+	}
+	return relationship.Data.GUID, nil
+}
+
+// cfMetadata is the metadata of a v3 resource, which app teams manage themselves.
+type cfMetadata struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// getCFMetadata looks up the labels and annotations of the app or service instance the certificate was
+// issued to, which are only served by version 3 of the CF API.
+func getCFMetadata(client *cfclient.Client, cfCert *models.CFCertificate) (*cfMetadata, error) {
+	path := "/v3/apps/" + cfCert.AppID
+	if cfCert.IsServiceInstance() {
+		path = "/v3/service_instances/" + cfCert.InstanceID
+	}
+	resp, err := client.DoRequest(client.NewRequest("GET", path))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	resource := &struct {
+		Metadata cfMetadata `json:"metadata"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(resource); err != nil {
+		return nil, err
+	}
+	return &resource.Metadata, nil
+}
+
+// instanceIPMatches reports whether the certificate's IP address is the internal IP of one of the
+// app's running instances, according to the CF API.
+func instanceIPMatches(client *cfclient.Client, cfCert *models.CFCertificate) (bool, error) {
+	stats, err := getProcessStats(client, cfCert.AppID)
+	if err != nil {
+		return false, err
+	}
+	for _, instance := range stats.Resources {
+		if instance.State == "RUNNING" && instance.InstanceInternalIP != "" && instance.InstanceInternalIP == cfCert.IPAddress {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getProcessStats looks up the stats of each instance of the app's web process.
+func getProcessStats(client *cfclient.Client, appID string) (*processStats, error) {
+	resp, err := client.DoRequest(client.NewRequest("GET", fmt.Sprintf("/v3/apps/%s/processes/web/stats", appID)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	stats := &processStats{}
+	if err := json.NewDecoder(resp.Body).Decode(stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// appDeleted reports whether the app, or the space or org it belongs to, no longer exists in CF.
+// Any other error, like the CF API being unavailable, is returned rather than being taken as a deletion.
+func appDeleted(client *cfclient.Client, entry *models.AppIndexEntry) (bool, error) {
+	if _, err := client.AppByGuid(entry.AppID); err != nil {
+		if cfclient.IsAppNotFoundError(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	if _, err := client.GetSpaceByGuid(entry.SpaceID); err != nil {
+		if cfclient.IsSpaceNotFoundError(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	if _, err := client.GetOrgByGuid(entry.OrgID); err != nil {
+		if cfclient.IsOrganizationNotFoundError(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// getAppTask looks up the running task of the certificate's app that the certificate was issued to.
+// Tasks are given instance identity certificates whose instance ID is the task's GUID.
+func getAppTask(client *cfclient.Client, cfCert *models.CFCertificate) (*cfclient.Task, error) {
+	task, err := client.GetTaskByGuid(cfCert.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(task.Links.App.Href, "/v3/apps/"+cfCert.AppID) {
+		return nil, fmt.Errorf("task %s doesn't belong to app %s", task.GUID, cfCert.AppID)
+	}
+	if task.State != "RUNNING" {
+		return nil, fmt.Errorf("task %s isn't running; its state is %s", task.GUID, task.State)
+	}
+	return &task, nil
+}
+
+// cfAPIUnavailable returns whether the given error from the CF API client means the CF API or UAA
+// couldn't be reached or failed, rather than that it answered the request, such as with a 404.
+func cfAPIUnavailable(err error) bool {
+	switch cause := pkgerrors.Cause(err).(type) {
+	case cfclient.CloudFoundryHTTPError:
+		return cause.StatusCode >= http.StatusInternalServerError || cause.StatusCode == http.StatusTooManyRequests
+	case *url.Error:
+		// UAA refusing Vault's credentials isn't an outage.
+		if retrieveErr, ok := cause.Err.(*oauth2.RetrieveError); ok && retrieveErr.Response != nil {
+			return retrieveErr.Response.StatusCode >= http.StatusInternalServerError
+		}
+		return true
+	case net.Error:
+		return true
+	}
+	return false
+}
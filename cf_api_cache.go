@@ -0,0 +1,204 @@
+package cf
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// The kinds of records that are cached. Each is the first part of the keys of its records, and
+// labels the cache's metrics.
+const (
+	cacheKindApp             = "apps"
+	cacheKindServiceInstance = "service_instances"
+	cacheKindOrg             = "orgs"
+	cacheKindSpace           = "spaces"
+)
+
+var cacheKinds = []string{cacheKindApp, cacheKindServiceInstance, cacheKindOrg, cacheKindSpace}
+
+// cfAPICache holds records fetched from the CF API while checking logins and renewals, so that the
+// instances of an app needn't each fetch them again, and so that they can still be checked while the
+// CF API is down. It's only used when cf_api_cache_ttl or cf_api_max_staleness is set, and is kept
+// in memory on each node, so every node fetches records for itself.
+type cfAPICache struct {
+	logger hclog.Logger
+
+	lock    sync.Mutex
+	entries map[string]*cfAPICacheEntry
+
+	// hits and misses count the lookups of each kind of record since the backend started, and
+	// staleServed counts the misses answered with a stale record because the CF API was down.
+	hits        map[string]uint64
+	misses      map[string]uint64
+	staleServed map[string]uint64
+}
+
+type cfAPICacheEntry struct {
+	kind      string
+	value     interface{}
+	fetchedAt time.Time
+}
+
+func newCFAPICache(logger hclog.Logger) *cfAPICache {
+	if logger == nil {
+		logger = hclog.NewNullLogger()
+	}
+	return &cfAPICache{
+		logger:      logger,
+		entries:     make(map[string]*cfAPICacheEntry),
+		hits:        make(map[string]uint64),
+		misses:      make(map[string]uint64),
+		staleServed: make(map[string]uint64),
+	}
+}
+
+// lookup returns the record of the given kind and GUID, calling fetch if it isn't cached or was
+// fetched longer ago than the TTL. If the cache is nil or neither the TTL nor the max staleness is
+// positive, fetch is always called. Errors aren't cached, so records that couldn't be fetched are
+// fetched again next time. If fetch fails because the CF API is unavailable, a record fetched within
+// the max staleness is returned instead.
+func (c *cfAPICache) lookup(kind, guid string, ttl, maxStaleness time.Duration, fetch func() (interface{}, error)) (interface{}, error) {
+	if c == nil || (ttl <= 0 && maxStaleness <= 0) {
+		return fetch()
+	}
+	key := kind + "/" + guid
+	now := time.Now()
+
+	c.lock.Lock()
+	entry, ok := c.entries[key]
+	if ok && now.Sub(entry.fetchedAt) < ttl {
+		c.hits[kind]++
+		c.lock.Unlock()
+		recordCFAPICacheLookup(kind, true)
+		recordCFAPICacheAge(kind, now.Sub(entry.fetchedAt))
+		return entry.value, nil
+	}
+	c.misses[kind]++
+	c.lock.Unlock()
+	recordCFAPICacheLookup(kind, false)
+
+	// The lock isn't held while fetching, so a slow CF API doesn't hold up everything that's cached.
+	value, err := fetch()
+	if err != nil {
+		// Only an outage allows the stale record to be used. If the CF API answered, such as by saying
+		// the app no longer exists, its answer stands, and the record mustn't be used in a later outage.
+		if !cfAPIUnavailable(err) {
+			if ok {
+				c.invalidate(kind, guid)
+			}
+			return nil, err
+		}
+		if !ok || now.Sub(entry.fetchedAt) >= maxStaleness {
+			return nil, err
+		}
+		age := now.Sub(entry.fetchedAt)
+		c.lock.Lock()
+		c.staleServed[kind]++
+		c.lock.Unlock()
+		recordCFAPICacheStaleServed(kind, age)
+		c.logger.Warn(fmt.Sprintf("the CF API is unavailable, so a cached record fetched %s ago is being used", age.Round(time.Second)), "kind", kind, "guid", guid, "error", err)
+		return entry.value, nil
+	}
+	c.lock.Lock()
+	c.entries[key] = &cfAPICacheEntry{kind: kind, value: value, fetchedAt: now}
+	c.lock.Unlock()
+	return value, nil
+}
+
+// invalidate removes the record of the given kind and GUID, returning whether it was cached.
+func (c *cfAPICache) invalidate(kind, guid string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := kind + "/" + guid
+	_, ok := c.entries[key]
+	delete(c.entries, key)
+	return ok
+}
+
+// clear removes every record.
+func (c *cfAPICache) clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = make(map[string]*cfAPICacheEntry)
+}
+
+// prune removes the records fetched longer ago than the retention, the longer of the TTL and the
+// max staleness, which can no longer be used, and returns how many were removed. If the retention
+// isn't positive, every record is removed.
+func (c *cfAPICache) prune(retention time.Duration, now time.Time) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	removed := 0
+	for key, entry := range c.entries {
+		if retention <= 0 || now.Sub(entry.fetchedAt) >= retention {
+			delete(c.entries, key)
+			removed++
+		}
+	}
+	recordCFAPICacheEntries(len(c.entries))
+	return removed
+}
+
+// status describes what's cached and how often it's been used, so that the TTL can be tuned.
+// The ages are percentiles of how long ago each record still cached was fetched.
+func (c *cfAPICache) status(ttl, maxStaleness time.Duration, now time.Time) map[string]interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entries := make(map[string]int, len(cacheKinds))
+	hits := make(map[string]uint64, len(cacheKinds))
+	misses := make(map[string]uint64, len(cacheKinds))
+	staleServed := make(map[string]uint64, len(cacheKinds))
+	var totalHits, totalMisses uint64
+	for _, kind := range cacheKinds {
+		entries[kind] = 0
+		hits[kind] = c.hits[kind]
+		misses[kind] = c.misses[kind]
+		staleServed[kind] = c.staleServed[kind]
+		totalHits += c.hits[kind]
+		totalMisses += c.misses[kind]
+	}
+	ages := make([]time.Duration, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries[entry.kind]++
+		ages = append(ages, now.Sub(entry.fetchedAt))
+	}
+	sort.Slice(ages, func(i, j int) bool { return ages[i] < ages[j] })
+
+	hitRate := 0.0
+	if totalHits+totalMisses > 0 {
+		hitRate = float64(totalHits) / float64(totalHits+totalMisses)
+	}
+	return map[string]interface{}{
+		"ttl":           int64(ttl / time.Second),
+		"enabled":       ttl > 0,
+		"total_entries": len(c.entries),
+		"entries":       entries,
+		"hits":          hits,
+		"misses":        misses,
+		"hit_rate":      hitRate,
+		"max_staleness": int64(maxStaleness / time.Second),
+		"stale_served":  staleServed,
+		"age_seconds": map[string]int64{
+			"p50": int64(percentile(ages, 50) / time.Second),
+			"p90": int64(percentile(ages, 90) / time.Second),
+			"max": int64(percentile(ages, 100) / time.Second),
+		},
+	}
+}
+
+// percentile returns the pth percentile of the sorted durations, or 0 if there are none.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
@@ -0,0 +1,193 @@
+package cf
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault-plugin-auth-cf/testing/cf"
+	"github.com/hashicorp/vault-plugin-auth-cf/util"
+)
+
+func TestCFAPICache(t *testing.T) {
+	cache := newCFAPICache(hclog.NewNullLogger())
+	fetches := 0
+	fetch := func() (interface{}, error) {
+		fetches++
+		return fetches, nil
+	}
+
+	// Without a TTL, nothing is cached.
+	for i := 0; i < 2; i++ {
+		if _, err := cache.lookup(cacheKindApp, "app-id", 0, 0, fetch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fetches != 2 {
+		t.Fatalf("expected 2 fetches but received %d", fetches)
+	}
+
+	// With one, records are reused until it passes.
+	for i := 0; i < 3; i++ {
+		value, err := cache.lookup(cacheKindApp, "app-id", time.Minute, 0, fetch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != 3 {
+			t.Fatalf("expected the third fetch to be reused but received %v", value)
+		}
+	}
+	cache.entries[cacheKindApp+"/app-id"].fetchedAt = time.Now().Add(-2 * time.Minute)
+	if value, _ := cache.lookup(cacheKindApp, "app-id", time.Minute, 0, fetch); value != 4 {
+		t.Fatalf("expected an expired record to be fetched again but received %v", value)
+	}
+
+	// Errors aren't cached.
+	if _, err := cache.lookup(cacheKindOrg, "org-id", time.Minute, 0, func() (interface{}, error) {
+		return nil, errors.New("unavailable")
+	}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, ok := cache.entries[cacheKindOrg+"/org-id"]; ok {
+		t.Fatal("expected the error not to have been cached")
+	}
+
+	status := cache.status(time.Minute, 0, time.Now())
+	if status["total_entries"] != 1 || status["hits"].(map[string]uint64)[cacheKindApp] != 2 || status["misses"].(map[string]uint64)[cacheKindApp] != 2 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	if !cache.invalidate(cacheKindApp, "app-id") || cache.invalidate(cacheKindApp, "app-id") {
+		t.Fatal("expected the record to have been invalidated once")
+	}
+
+	cache.lookup(cacheKindSpace, "old", time.Minute, 0, fetch)
+	cache.lookup(cacheKindSpace, "new", time.Minute, 0, fetch)
+	cache.entries[cacheKindSpace+"/old"].fetchedAt = time.Now().Add(-2 * time.Minute)
+	if removed := cache.prune(time.Minute, time.Now()); removed != 1 {
+		t.Fatalf("expected 1 record to be pruned but %d were", removed)
+	}
+	if removed := cache.prune(0, time.Now()); removed != 1 {
+		t.Fatalf("expected every record to be pruned without a TTL, but %d were", removed)
+	}
+}
+
+func TestCFAPICacheStale(t *testing.T) {
+	cache := newCFAPICache(hclog.NewNullLogger())
+	outage := &url.Error{Op: "Get", URL: "https://api.example.com/v2/apps/app-id", Err: errors.New("connection refused")}
+	lookup := func(err error) (interface{}, error) {
+		return cache.lookup(cacheKindApp, "app-id", 0, time.Hour, func() (interface{}, error) {
+			if err != nil {
+				return nil, err
+			}
+			return "app", nil
+		})
+	}
+
+	// Without a record, an outage fails the lookup.
+	if _, err := lookup(outage); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// Without a TTL, records are always fetched, but kept for an outage.
+	if _, err := lookup(nil); err != nil {
+		t.Fatal(err)
+	}
+	cache.entries[cacheKindApp+"/app-id"].fetchedAt = time.Now().Add(-30 * time.Minute)
+	value, err := lookup(outage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "app" {
+		t.Fatalf("expected the stale record but received %v", value)
+	}
+	if served := cache.status(0, time.Hour, time.Now())["stale_served"].(map[string]uint64)[cacheKindApp]; served != 1 {
+		t.Fatalf("expected 1 stale record to have been served but %d were", served)
+	}
+	if _, err := lookup(cfclient.CloudFoundryHTTPError{StatusCode: 502, Status: "502 Bad Gateway"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Records older than the max staleness aren't used.
+	cache.entries[cacheKindApp+"/app-id"].fetchedAt = time.Now().Add(-2 * time.Hour)
+	if _, err := lookup(outage); err == nil {
+		t.Fatal("expected a record older than the max staleness to be refused")
+	}
+
+	// When the CF API answers, its answer stands, and the record is forgotten.
+	if _, err := lookup(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lookup(cfclient.CloudFoundryError{Code: 100004, ErrorCode: "CF-AppNotFound"}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := lookup(outage); err == nil {
+		t.Fatal("expected a record the CF API said was gone not to be used")
+	}
+}
+
+func TestCheckCFAPICached(t *testing.T) {
+	foundation := cf.Foundation{
+		Orgs:   []cf.Org{{GUID: "org-id", Name: "my-org"}},
+		Spaces: []cf.Space{{GUID: "space-id", Name: "my-space", OrgGUID: "org-id"}},
+		Apps:   []cf.App{{GUID: "app-id", Name: "my-app", SpaceGUID: "space-id", Instances: []cf.Instance{{IP: "10.0.0.1"}}}},
+	}
+	cfServer := cf.NewServer(foundation)
+	defer cfServer.Close()
+
+	client, err := util.NewCFClient(&models.Configuration{
+		CFAPIAddr:  cfServer.URL,
+		CFUsername: cf.AuthUsername,
+		CFPassword: cf.AuthPassword,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfCert, err := models.NewCFCertificate("instance-id", "org-id", "space-id", "app-id", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := newCFAPICache(hclog.NewNullLogger())
+	if _, err := checkCFAPI(client, cache, time.Minute, 0, cfCert); err != nil {
+		t.Fatal(err)
+	}
+
+	// The app's deletion isn't noticed until its record is invalidated.
+	cfServer.Update(func(foundation *cf.Foundation) {
+		foundation.Apps = nil
+	})
+	resources, err := checkCFAPI(client, cache, time.Minute, 0, cfCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resources.App.Name != "my-app" {
+		t.Fatalf("expected the cached app but received %+v", resources.App)
+	}
+	cache.invalidate(cacheKindApp, "app-id")
+	if _, err := checkCFAPI(client, cache, time.Minute, 0, cfCert); err == nil {
+		t.Fatal("expected a deleted app to be refused once its record was invalidated")
+	}
+
+	// With a max staleness, records are still checked against while the CF API is down.
+	cfServer.Update(func(foundation *cf.Foundation) {
+		foundation.Apps = []cf.App{{GUID: "app-id", Name: "my-app", SpaceGUID: "space-id", Instances: []cf.Instance{{IP: "10.0.0.1"}}}}
+	})
+	if _, err := checkCFAPI(client, cache, time.Minute, time.Hour, cfCert); err != nil {
+		t.Fatal(err)
+	}
+	cfServer.Close()
+	for _, entry := range cache.entries {
+		entry.fetchedAt = time.Now().Add(-30 * time.Minute)
+	}
+	if _, err := checkCFAPI(client, cache, time.Minute, time.Hour, cfCert); err != nil {
+		t.Fatalf("expected the stale records to be used during the outage: %s", err)
+	}
+	if _, err := checkCFAPI(client, cache, time.Minute, 0, cfCert); err == nil {
+		t.Fatal("expected an error without a max staleness")
+	}
+}
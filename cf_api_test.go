@@ -0,0 +1,315 @@
+package cf
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault-plugin-auth-cf/testing/cf"
+	"github.com/hashicorp/vault-plugin-auth-cf/util"
+	pkgerrors "github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+func TestCheckCFAPITask(t *testing.T) {
+	cfServer := cf.MockServer(false, nil)
+	defer cfServer.Close()
+
+	client, err := util.NewCFClient(&models.Configuration{
+		CFAPIAddr:  cfServer.URL,
+		CFUsername: cf.AuthUsername,
+		CFPassword: cf.AuthPassword,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The stopped app has no instances, so only its running task may log in.
+	taskCert, err := models.NewCFCertificate(cf.FoundTaskGUID, cf.FoundOrgGUID, cf.FoundSpaceGUID, cf.FoundStoppedAppGUID, cf.FoundInstanceIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources, err := checkCFAPI(client, nil, 0, 0, taskCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resources.Task == nil || resources.Task.Name != cf.FoundTaskName {
+		t.Fatalf("expected task %s but received %+v", cf.FoundTaskName, resources.Task)
+	}
+
+	instanceCert, err := models.NewCFCertificate(cf.FoundServiceGUID, cf.FoundOrgGUID, cf.FoundSpaceGUID, cf.FoundStoppedAppGUID, cf.FoundInstanceIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkCFAPI(client, nil, 0, 0, instanceCert); err == nil {
+		t.Fatal("expected an instance of an app without live instances to be refused")
+	}
+}
+
+func TestCheckCFAPIFoundation(t *testing.T) {
+	foundation := cf.Foundation{
+		Orgs:   []cf.Org{{GUID: "org-id", Name: "my-org"}},
+		Spaces: []cf.Space{{GUID: "space-id", Name: "my-space", OrgGUID: "org-id"}},
+		Apps: []cf.App{{
+			GUID:      "app-id",
+			Name:      "my-app",
+			SpaceGUID: "space-id",
+			Instances: []cf.Instance{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}},
+		}},
+	}
+	cfServer := cf.NewServer(foundation)
+	defer cfServer.Close()
+
+	client, err := util.NewCFClient(&models.Configuration{
+		CFAPIAddr:  cfServer.URL,
+		CFUsername: cf.AuthUsername,
+		CFPassword: cf.AuthPassword,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfCert, err := models.NewCFCertificate("instance-id", "org-id", "space-id", "app-id", "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources, err := checkCFAPI(client, nil, 0, 0, cfCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resources.App.Name != "my-app" || resources.Space.Name != "my-space" || resources.Org.Name != "my-org" {
+		t.Fatalf("unexpected resources %+v", resources)
+	}
+	index, err := getInstanceIndex(client, cfCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 1 {
+		t.Fatalf("expected instance index 1 but received %d", index)
+	}
+
+	// Apps in orgs that have been suspended are refused, unless they're allowed.
+	if err := checkOrgStatus(&models.Configuration{}, resources); err != nil {
+		t.Fatal(err)
+	}
+	cfServer.Update(func(foundation *cf.Foundation) {
+		foundation.Orgs[0].Status = orgStatusSuspended
+	})
+	suspendedResources, err := checkCFAPI(client, nil, 0, 0, cfCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checkOrgStatus(&models.Configuration{}, suspendedResources)
+	if failure, ok := err.(*loginFailure); !ok || failure.category != failureCategoryOrgSuspended {
+		t.Fatalf("expected the suspended org to be refused but received %v", err)
+	}
+	if err := checkOrgStatus(&models.Configuration{AllowSuspendedOrgs: true}, suspendedResources); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the app is deleted, it's noticed.
+	entry := &models.AppIndexEntry{AppID: "app-id", SpaceID: "space-id", OrgID: "org-id"}
+	if deleted, err := appDeleted(client, entry); err != nil || deleted {
+		t.Fatalf("expected the app to exist but received %t, %v", deleted, err)
+	}
+	cfServer.Update(func(foundation *cf.Foundation) {
+		foundation.Apps = nil
+	})
+	if deleted, err := appDeleted(client, entry); err != nil || !deleted {
+		t.Fatalf("expected the app to be deleted but received %t, %v", deleted, err)
+	}
+	if _, err := checkCFAPI(client, nil, 0, 0, cfCert); err == nil {
+		t.Fatal("expected a deleted app to be refused")
+	}
+}
+
+func TestCheckAppConstraints(t *testing.T) {
+	foundation := cf.Foundation{
+		Apps: []cf.App{{
+			GUID:              "app-id",
+			DetectedBuildpack: "java_buildpack_offline",
+			StackGUID:         "stack-id",
+			Instances:         []cf.Instance{{IP: "10.0.0.1"}},
+		}},
+		Stacks:            []cf.Stack{{GUID: "stack-id", Name: "cflinuxfs3"}},
+		Orgs:              []cf.Org{{GUID: "org-id"}},
+		Spaces:            []cf.Space{{GUID: "space-id", OrgGUID: "org-id", IsolationSegmentGUID: "segment-id"}},
+		IsolationSegments: []cf.IsolationSegment{{GUID: "segment-id", Name: "regulated"}},
+	}
+	cfServer := cf.NewServer(foundation)
+	defer cfServer.Close()
+
+	client, err := util.NewCFClient(&models.Configuration{
+		CFAPIAddr:  cfServer.URL,
+		CFUsername: cf.AuthUsername,
+		CFPassword: cf.AuthPassword,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := client.AppByGuid("app-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources := &cfResources{App: app}
+	cfCert, err := models.NewCFCertificate("instance-id", "org-id", "space-id", "app-id", "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, testCase := range []struct {
+		role    *models.RoleEntry
+		allowed bool
+	}{
+		{&models.RoleEntry{}, true},
+		{&models.RoleEntry{BoundBuildpacks: []string{"java_buildpack_offline"}, BoundStacks: []string{"cflinuxfs3"}}, true},
+		{&models.RoleEntry{BoundBuildpacks: []string{"go_buildpack"}}, false},
+		{&models.RoleEntry{BoundStacks: []string{"windows"}}, false},
+		{&models.RoleEntry{BoundIsolationSegments: []string{"regulated"}}, true},
+		{&models.RoleEntry{BoundIsolationSegments: []string{"shared"}}, false},
+		{&models.RoleEntry{RequireInstanceIPMatch: true}, false},
+		{&models.RoleEntry{IPMatchingSource: ipMatchingSourceCFAPI}, false},
+		{&models.RoleEntry{IPMatchingSource: ipMatchingSourceCFAPI, DisableIPMatching: true}, true},
+	} {
+		err := checkAppConstraints(client, testCase.role, cfCert, resources)
+		if testCase.allowed && err != nil {
+			t.Fatalf("expected %+v to be allowed but received %s", testCase.role, err)
+		}
+		if !testCase.allowed && err == nil {
+			t.Fatalf("expected %+v to be refused", testCase.role)
+		}
+	}
+
+	// Once the app is scaled up, the certificate's IP is among its instances'.
+	cfServer.Update(func(foundation *cf.Foundation) {
+		foundation.Apps[0].Instances = append(foundation.Apps[0].Instances, cf.Instance{IP: "10.0.0.2"})
+	})
+	if err := checkAppConstraints(client, &models.RoleEntry{RequireInstanceIPMatch: true}, cfCert, resources); err != nil {
+		t.Fatal(err)
+	}
+	// Matching against the CF API's addresses takes the place of matching the caller's.
+	cfAPIRole := &models.RoleEntry{IPMatchingSource: ipMatchingSourceCFAPI}
+	if err := checkAppConstraints(client, cfAPIRole, cfCert, resources); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkRoleConstraints(cfAPIRole, cfCert, "192.168.0.1"); err != nil {
+		t.Fatalf("expected the caller's address to be ignored but received %s", err)
+	}
+	if err := checkRoleConstraints(&models.RoleEntry{}, cfCert, "192.168.0.1"); err == nil {
+		t.Fatal("expected the caller's address to be matched by default")
+	}
+
+	// Without an isolation segment of its own, the space's apps run in the org's default one.
+	cfServer.Update(func(foundation *cf.Foundation) {
+		foundation.Spaces[0].IsolationSegmentGUID = ""
+	})
+	if err := checkAppConstraints(client, &models.RoleEntry{BoundIsolationSegments: []string{"shared"}}, cfCert, resources); err != nil {
+		t.Fatal(err)
+	}
+	cfServer.Update(func(foundation *cf.Foundation) {
+		foundation.Orgs[0].DefaultIsolationSegmentGUID = "segment-id"
+	})
+	if err := checkAppConstraints(client, &models.RoleEntry{BoundIsolationSegments: []string{"regulated"}}, cfCert, resources); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only service instances have names to be bound to.
+	serviceInstanceRole := &models.RoleEntry{AllowServiceInstanceLogin: true, BoundServiceInstanceNames: []string{"my-service"}}
+	if err := checkAppConstraints(client, serviceInstanceRole, cfCert, resources); err == nil {
+		t.Fatal("expected an app to be refused by a role bound to service instance names")
+	}
+	serviceInstanceCert, err := models.NewServiceInstanceCertificate("service-instance-id", "org-id", "space-id", "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serviceInstanceResources := &cfResources{ServiceInstance: cfclient.ServiceInstance{Name: "my-service"}}
+	if err := checkAppConstraints(client, serviceInstanceRole, serviceInstanceCert, serviceInstanceResources); err != nil {
+		t.Fatal(err)
+	}
+	serviceInstanceResources.ServiceInstance.Name = "other-service"
+	if err := checkAppConstraints(client, serviceInstanceRole, serviceInstanceCert, serviceInstanceResources); err == nil {
+		t.Fatal("expected a service instance with another name to be refused")
+	}
+}
+
+func TestSelectedCFMetadata(t *testing.T) {
+	foundation := cf.Foundation{
+		Apps: []cf.App{{
+			GUID:        "app-id",
+			Labels:      map[string]string{"environment": "production"},
+			Annotations: map[string]string{"example.com/team": "payments", "example.com/owner": "alice"},
+		}},
+		ServiceInstances: []cf.ServiceInstance{{GUID: "service-instance-id", Labels: map[string]string{"environment": "staging"}}},
+	}
+	cfServer := cf.NewServer(foundation)
+	defer cfServer.Close()
+
+	client, err := util.NewCFClient(&models.Configuration{
+		CFAPIAddr:  cfServer.URL,
+		CFUsername: cf.AuthUsername,
+		CFPassword: cf.AuthPassword,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfCert, err := models.NewCFCertificate("instance-id", "org-id", "space-id", "app-id", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the keys the role lists are copied, and missing ones are left out.
+	role := &models.RoleEntry{
+		MetadataAnnotationKeys: []string{"example.com/team", "example.com/cost-center"},
+		MetadataLabelKeys:      []string{"environment"},
+	}
+	metadata, err := selectedCFMetadata(client, role, cfCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"annotation_example.com/team": "payments", "label_environment": "production"}
+	if !reflect.DeepEqual(metadata, expected) {
+		t.Fatalf("expected %v but received %v", expected, metadata)
+	}
+
+	serviceInstanceCert, err := models.NewServiceInstanceCertificate("service-instance-id", "org-id", "space-id", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err = selectedCFMetadata(client, role, serviceInstanceCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(metadata, map[string]string{"label_environment": "staging"}) {
+		t.Fatalf("unexpected service instance metadata %v", metadata)
+	}
+
+	// Roles that don't copy any don't look them up.
+	cfServer.Close()
+	if metadata, err := selectedCFMetadata(client, &models.RoleEntry{}, cfCert); err != nil || metadata != nil {
+		t.Fatalf("expected no metadata but received %v, %v", metadata, err)
+	}
+}
+
+func TestCFAPIUnavailable(t *testing.T) {
+	for name, tc := range map[string]struct {
+		err      error
+		expected bool
+	}{
+		"unreachable":       {pkgerrors.Wrap(&url.Error{Op: "Get", URL: "https://api.example.com", Err: errors.New("connection refused")}, "Error requesting apps"), true},
+		"bad gateway":       {pkgerrors.Wrap(cfclient.CloudFoundryHTTPError{StatusCode: http.StatusBadGateway}, "Error requesting apps"), true},
+		"rate limited":      {cfclient.CloudFoundryHTTPError{StatusCode: http.StatusTooManyRequests}, true},
+		"UAA failing":       {&url.Error{Err: &oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusServiceUnavailable}}}, true},
+		"not found":         {pkgerrors.Wrap(cfclient.CloudFoundryError{Code: 100004, ErrorCode: "CF-AppNotFound"}, "Error requesting apps"), false},
+		"forbidden":         {cfclient.CloudFoundryHTTPError{StatusCode: http.StatusForbidden}, false},
+		"credentials":       {&url.Error{Err: &oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusUnauthorized}}}, false},
+		"unexpected record": {errors.New("cert app ID doesn't match"), false},
+	} {
+		if actual := cfAPIUnavailable(tc.err); actual != tc.expected {
+			t.Errorf("%s: expected %t but received %t", name, tc.expected, actual)
+		}
+	}
+}
@@ -1,13 +1,12 @@
 package cf
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"strconv"
 	"strings"
-	"time"
 
+	"github.com/hashicorp/vault-plugin-auth-cf/client"
 	"github.com/hashicorp/vault-plugin-auth-cf/signatures"
 	"github.com/hashicorp/vault/api"
 )
@@ -20,61 +19,26 @@ func (h *CLIHandler) Auth(c *api.Client, m map[string]string) (*api.Secret, erro
 		mount = "cf"
 	}
 
+	// The role may be left for the server to default or select, if it's configured to.
 	role := m["role"]
-	if role == "" {
-		return nil, errors.New(`"role" is required`)
-	}
-
-	pathToInstanceCert := m["cf_instance_cert"]
-	if pathToInstanceCert == "" {
-		pathToInstanceCert = os.Getenv(EnvVarInstanceCertificate)
-	}
-	if pathToInstanceCert == "" {
-		return nil, errors.New(`"cf_instance_cert" is required`)
-	}
-
-	pathToInstanceKey := m["cf_instance_key"]
-	if pathToInstanceKey == "" {
-		pathToInstanceKey = os.Getenv(EnvVarInstanceKey)
-	}
-	if pathToInstanceKey == "" {
-		return nil, errors.New(`"cf_instance_key" is required`)
-	}
-
-	certBytes, err := ioutil.ReadFile(pathToInstanceCert)
-	if err != nil {
-		return nil, err
-	}
-	cfInstanceCertContents := string(certBytes)
 
-	signingTime := time.Now().UTC()
-	signatureData := &signatures.SignatureData{
-		SigningTime:            signingTime,
-		Role:                   role,
-		CFInstanceCertContents: cfInstanceCertContents,
-	}
-	signature, err := signatures.Sign(pathToInstanceKey, signatureData)
-	if err != nil {
-		return nil, err
-	}
-
-	loginData := map[string]interface{}{
-		"role":             role,
-		"cf_instance_cert": cfInstanceCertContents,
-		"signing_time":     signingTime.Format(signatures.TimeFormat),
-		"signature":        signature,
+	signatureVersion := signatures.Version1
+	if raw := m["signature_version"]; raw != "" {
+		var err error
+		signatureVersion, err = strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf(`"signature_version" must be an integer: %s`, err)
+		}
 	}
 
-	path := fmt.Sprintf("auth/%s/login", mount)
-
-	secret, err := c.Logical().Write(path, loginData)
-	if err != nil {
-		return nil, err
-	}
-	if secret == nil {
-		return nil, errors.New("empty response from credential provider")
-	}
-	return secret, nil
+	// Anything not given explicitly is defaulted from the instance's environment.
+	return client.LoginWithClient(context.Background(), c, mount, role, &signatures.LoginOptions{
+		PathToInstanceCert: m["cf_instance_cert"],
+		PathToInstanceKey:  m["cf_instance_key"],
+		Passphrase:         []byte(m["cf_instance_key_passphrase"]),
+		Version:            signatureVersion,
+		Audience:           m["audience"],
+	})
 }
 
 func (h *CLIHandler) Help() string {
@@ -95,12 +59,21 @@ Usage: vault login -method=cf [CONFIG K=V...]
 
 Configuration:
 
+  audience=<string>
+      Audience to bind the signature to. Only used with version 2 signatures.
+      If not provided, it's read from the server.
+
   cf_instance_cert=<string>
       Explicit value to use for the path to the CF instance certificate.
 
   cf_instance_key=<string>
       Explicit value to use for the path to the CF instance key.
 
+  cf_instance_key_passphrase=<string>
+      Passphrase to decrypt the CF instance key with, if it's encrypted. If not
+      provided, the CF_INSTANCE_KEY_PASSPHRASE value in your local environment
+      is used.
+
   mount=<string>
       Path where the CF credential method is mounted. This is usually provided
       via the -path flag in the "vault login" command, but it can be specified
@@ -108,7 +81,12 @@ Configuration:
       -path. The default value is "cf".
 
   role=<string>
-      Name of the role to request a token against
+      Name of the role to request a token against. May be omitted if the
+      server has a default role or is configured to select a role
+
+  signature_version=<int>
+      Version of the signature format to sign the login request with. Version 2
+      is only accepted by servers that support it. The default value is 1.
 `
 
 	return strings.TrimSpace(help)
@@ -2,6 +2,8 @@ package cf
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -30,9 +32,30 @@ func TestCLIHandler_Auth(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Make a fake Vault server the client can talk to.
-	ts := httptest.NewServer(http.HandlerFunc(handleLogin(t, testCerts)))
+	// Make a fake Vault server the client can talk to. It reports each login it receives, since
+	// the test can't be failed from the server's goroutine.
+	logins := make(chan receivedLogin, 1)
+	ts := httptest.NewServer(http.HandlerFunc(handleLogin(testCerts, logins)))
 	defer ts.Close()
+	// The server reports a login before responding to it, so it's been reported by the time
+	// the CLI handler returns.
+	checkLogin := func(expectedPath string, err error) {
+		t.Helper()
+		select {
+		case login := <-logins:
+			if login.err != nil {
+				t.Fatal(login.err)
+			}
+			if login.path != expectedPath {
+				t.Fatalf("expected a login at %s but received one at %s", expectedPath, login.path)
+			}
+		default:
+			t.Fatalf("expected a login at %s but received none: %v", expectedPath, err)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
 
 	cliHandler := &CLIHandler{}
 	client, err := api.NewClient(&api.Config{
@@ -45,68 +68,41 @@ func TestCLIHandler_Auth(t *testing.T) {
 	os.Setenv(EnvVarInstanceCertificate, testCerts.PathToInstanceCertificate)
 	os.Setenv(EnvVarInstanceKey, testCerts.PathToInstanceKey)
 
-	if _, err := cliHandler.Auth(client, map[string]string{
+	_, err = cliHandler.Auth(client, map[string]string{
 		"role": "test-role",
-	}); err != nil {
-		t.Fatal(err)
-	}
-}
+	})
+	checkLogin("/v1/auth/cf/login", err)
 
-func handleLogin(t *testing.T, testCerts *certificates.TestCertificates) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		body := make(map[string]string)
-		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-			t.Fatal(err)
-		}
-		if body["role"] != "test-role" {
-			t.Fatalf(`expected %q but received %q`, "test-role", body["role"])
-		}
-		if body["cf_instance_cert"] != testCerts.InstanceCertificate {
-			t.Fatalf(`expected %q but received %q`, testCerts.InstanceCertificate, body["cf_instance_cert"])
-		}
-		signingTime, err := time.Parse(signatures.TimeFormat, body["signing_time"])
-		if err != nil {
-			t.Fatal(err)
-		}
-		// Perform a loose check that the signing time is reasonable.
-		now := time.Now().UTC()
-		if now.Sub(signingTime).Minutes() > 2 {
-			t.Fatalf(`it's currently %s but signature is from %s'`, now.String(), signingTime.String())
-		}
+	// Explicitly given paths take precedence over the environment, and the mount may be changed.
+	os.Setenv(EnvVarInstanceCertificate, "/nonexistent/instance.crt")
+	os.Setenv(EnvVarInstanceKey, "/nonexistent/instance.key")
+	defer os.Unsetenv(EnvVarInstanceCertificate)
+	defer os.Unsetenv(EnvVarInstanceKey)
+	_, err = cliHandler.Auth(client, map[string]string{
+		"role":             "test-role",
+		"mount":            "cf-other",
+		"cf_instance_cert": testCerts.PathToInstanceCertificate,
+		"cf_instance_key":  testCerts.PathToInstanceKey,
+	})
+	checkLogin("/v1/auth/cf-other/login", err)
+}
 
-		if body["signature"] == "" {
-			t.Fatal("signature is missing")
-		}
+// receivedLogin is a login request received by the fake Vault server, and what was wrong with
+// it, if anything.
+type receivedLogin struct {
+	path string
+	err  error
+}
 
-		signatureData := &signatures.SignatureData{
-			SigningTime:            signingTime,
-			Role:                   body["role"],
-			CFInstanceCertContents: body["cf_instance_cert"],
-		}
-		// Validate that we can verify the signature that was sent.
-		cert, err := signatures.Verify(body["signature"], signatureData)
-		if err != nil {
-			t.Fatal(err)
-		}
-		// Validate the certificate that matches our CA has the expected identity data.
-		cfCert, err := models.NewCFCertificateFromx509(cert)
+// handleLogin returns a handler that sends each request it receives to logins, refusing the
+// ones that aren't valid logins for the test certificates.
+func handleLogin(testCerts *certificates.TestCertificates, logins chan<- receivedLogin) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := checkLoginRequest(r, testCerts)
+		logins <- receivedLogin{path: r.URL.Path, err: err}
 		if err != nil {
-			t.Fatal(err)
-		}
-		if cfCert.IPAddress != testIPAddress {
-			t.Fatalf(`expected %q but received %q`, testIPAddress, cfCert.IPAddress)
-		}
-		if cfCert.AppID != testAppID {
-			t.Fatalf(`expected %q but received %q`, testAppID, cfCert.AppID)
-		}
-		if cfCert.SpaceID != testSpaceID {
-			t.Fatalf(`expected %q but received %q`, testSpaceID, cfCert.SpaceID)
-		}
-		if cfCert.OrgID != testOrgID {
-			t.Fatalf(`expected %q but received %q`, testOrgID, cfCert.OrgID)
-		}
-		if cfCert.InstanceID != testInstanceID {
-			t.Fatalf(`expected %q but received %q`, testInstanceID, cfCert.InstanceID)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 		// Success.
 		w.WriteHeader(200)
@@ -114,6 +110,67 @@ func handleLogin(t *testing.T, testCerts *certificates.TestCertificates) func(w
 	}
 }
 
+func checkLoginRequest(r *http.Request, testCerts *certificates.TestCertificates) error {
+	if r.Method != http.MethodPut {
+		return fmt.Errorf("unexpected %s request to %s", r.Method, r.URL.Path)
+	}
+	body := make(map[string]string)
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return err
+	}
+	if body["role"] != "test-role" {
+		return fmt.Errorf(`expected %q but received %q`, "test-role", body["role"])
+	}
+	if body["cf_instance_cert"] != testCerts.InstanceCertificate {
+		return fmt.Errorf(`expected %q but received %q`, testCerts.InstanceCertificate, body["cf_instance_cert"])
+	}
+	signingTime, err := time.Parse(signatures.TimeFormat, body["signing_time"])
+	if err != nil {
+		return err
+	}
+	// Perform a loose check that the signing time is reasonable.
+	now := time.Now().UTC()
+	if now.Sub(signingTime).Minutes() > 2 {
+		return fmt.Errorf(`it's currently %s but signature is from %s'`, now.String(), signingTime.String())
+	}
+
+	if body["signature"] == "" {
+		return errors.New("signature is missing")
+	}
+
+	signatureData := &signatures.SignatureData{
+		SigningTime:            signingTime,
+		Role:                   body["role"],
+		CFInstanceCertContents: body["cf_instance_cert"],
+	}
+	// Validate that we can verify the signature that was sent.
+	cert, err := signatures.Verify(body["signature"], signatureData)
+	if err != nil {
+		return err
+	}
+	// Validate the certificate that matches our CA has the expected identity data.
+	cfCert, err := models.NewCFCertificateFromx509(cert)
+	if err != nil {
+		return err
+	}
+	if cfCert.IPAddress != testIPAddress {
+		return fmt.Errorf(`expected %q but received %q`, testIPAddress, cfCert.IPAddress)
+	}
+	if cfCert.AppID != testAppID {
+		return fmt.Errorf(`expected %q but received %q`, testAppID, cfCert.AppID)
+	}
+	if cfCert.SpaceID != testSpaceID {
+		return fmt.Errorf(`expected %q but received %q`, testSpaceID, cfCert.SpaceID)
+	}
+	if cfCert.OrgID != testOrgID {
+		return fmt.Errorf(`expected %q but received %q`, testOrgID, cfCert.OrgID)
+	}
+	if cfCert.InstanceID != testInstanceID {
+		return fmt.Errorf(`expected %q but received %q`, testInstanceID, cfCert.InstanceID)
+	}
+	return nil
+}
+
 const successResponse = `{
 	"auth": {
 		"client_token": "s.JvMmUR9OmjhB7XWtQzSiJBra",
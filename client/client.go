@@ -0,0 +1,162 @@
+// Package client logs Go programs running in CF instances into Vault using their instance
+// identity certificates, so they don't need to sign and send login requests themselves.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/signatures"
+	"github.com/hashicorp/vault/api"
+)
+
+// DefaultMountPath is where the CF auth method is mounted unless another path is chosen.
+const DefaultMountPath = "cf"
+
+// Login logs into the CF auth method mounted at mountPath on the Vault at vaultAddr, using the
+// given role, and returns the resulting secret, whose Auth holds the token. The instance
+// certificate and key default to the paths in CF_INSTANCE_CERT and CF_INSTANCE_KEY if their
+// paths are empty, and the mount path defaults to DefaultMountPath. Other settings, such as
+// the CA to trust for Vault's TLS certificate, are read from the environment like the Vault
+// CLI reads them. The role may be empty if the server has a default role or is configured to select one.
+func Login(ctx context.Context, vaultAddr, mountPath, role, certPath, keyPath string) (*api.Secret, error) {
+	config := api.DefaultConfig()
+	if config.Error != nil {
+		return nil, config.Error
+	}
+	if vaultAddr != "" {
+		config.Address = vaultAddr
+	}
+	c, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return LoginWithClient(ctx, c, mountPath, role, &signatures.LoginOptions{
+		PathToInstanceCert: certPath,
+		PathToInstanceKey:  keyPath,
+	})
+}
+
+// LoginWithClient is like Login, but uses the given client, and the given options for signing
+// the login, which may be nil. On success the client's token is set to the new token, so it's
+// ready for use, unless the response was wrapped, such as by a role's response_wrap_ttl, in which
+// case the secret's WrapInfo holds the wrapping token and the client is left without a token until
+// it's unwrapped. If a version 2 signature is requested without an audience, the audience is
+// read from the server, and an unbound signature is sent if the server doesn't publish one.
+func LoginWithClient(ctx context.Context, c *api.Client, mountPath, role string, opts *signatures.LoginOptions) (*api.Secret, error) {
+	if mountPath == "" {
+		mountPath = DefaultMountPath
+	}
+	// The login and audience endpoints don't require a token, so none is sent.
+	c.ClearToken()
+
+	loginData, err := signedLoginData(ctx, c, mountPath, role, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := send(ctx, c, http.MethodPut, fmt.Sprintf("/v1/auth/%s/login", mountPath), loginData)
+	if err != nil {
+		return nil, err
+	}
+	if secret != nil && secret.WrapInfo != nil {
+		return secret, nil
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, errors.New("empty response from credential provider")
+	}
+	c.SetToken(secret.Auth.ClientToken)
+	return secret, nil
+}
+
+// Renew renews the client's token, requesting the given increment, or the token's default TTL
+// if it's 0. The CF auth method checks that the instance's app, space, and org still exist and
+// still satisfy the role before allowing the renewal.
+func Renew(ctx context.Context, c *api.Client, increment time.Duration) (*api.Secret, error) {
+	data := map[string]interface{}{}
+	if increment > 0 {
+		data["increment"] = int64(increment / time.Second)
+	}
+	secret, err := send(ctx, c, http.MethodPut, "/v1/auth/token/renew-self", data)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, errors.New("empty response from token renewal")
+	}
+	return secret, nil
+}
+
+// RenewWithCert presents the instance's current certificate for the client's token, which must
+// have been issued by the CF auth method mounted at mountPath, and then renews the token like
+// Renew. The role and options are used as by LoginWithClient, and the role must be the token's.
+// This lets the token be renewed past the expiry of the certificate that was used to log in,
+// since instance identity certificates are rotated every day, while keeping its accessor and
+// entity alias. It returns the renewed token's secret.
+func RenewWithCert(ctx context.Context, c *api.Client, mountPath, role string, opts *signatures.LoginOptions, increment time.Duration) (*api.Secret, error) {
+	if mountPath == "" {
+		mountPath = DefaultMountPath
+	}
+	data, err := signedLoginData(ctx, c, mountPath, role, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := send(ctx, c, http.MethodPut, fmt.Sprintf("/v1/auth/%s/renew-with-cert", mountPath), data); err != nil {
+		return nil, err
+	}
+	return Renew(ctx, c, increment)
+}
+
+// signedLoginData signs a login for the given role with the given options, which may be nil, and
+// returns the fields to send. If a version 2 signature is requested without an audience, the
+// audience is read from the auth method mounted at mountPath.
+func signedLoginData(ctx context.Context, c *api.Client, mountPath, role string, opts *signatures.LoginOptions) (map[string]interface{}, error) {
+	signOpts := signatures.LoginOptions{}
+	if opts != nil {
+		signOpts = *opts
+	}
+
+	if signOpts.Audience == "" && signOpts.Version >= signatures.Version2 {
+		if secret, err := send(ctx, c, http.MethodGet, fmt.Sprintf("/v1/auth/%s/audience", mountPath), nil); err == nil && secret != nil {
+			signOpts.Audience, _ = secret.Data["audience"].(string)
+		}
+	}
+
+	signatureData, signature, err := signatures.SignLogin(role, &signOpts)
+	if err != nil {
+		return nil, err
+	}
+	loginData := map[string]interface{}{
+		"cf_instance_cert": signatureData.CFInstanceCertContents,
+		"signing_time":     signatureData.SigningTime.Format(signatures.TimeFormat),
+		"signature":        signature,
+	}
+	if role != "" {
+		loginData["role"] = role
+	}
+	if signOpts.Audience != "" {
+		loginData["audience"] = signOpts.Audience
+	}
+	return loginData, nil
+}
+
+// send sends a request with the given body, which may be nil, and parses the secret it returns.
+func send(ctx context.Context, c *api.Client, method, path string, body map[string]interface{}) (*api.Secret, error) {
+	r := c.NewRequest(method, path)
+	if body != nil {
+		if err := r.SetJSONBody(body); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return api.ParseSecret(resp.Body)
+}
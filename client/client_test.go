@@ -0,0 +1,175 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/signatures"
+	"github.com/hashicorp/vault-plugin-auth-cf/testing/certificates"
+	"github.com/hashicorp/vault/api"
+)
+
+const testToken = "s.JvMmUR9OmjhB7XWtQzSiJBra"
+
+func TestLoginAndRenew(t *testing.T) {
+	testCerts, err := certificates.Generate("instance-id", "org-id", "space-id", "app-id", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := testCerts.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	verifyLogin := func(body map[string]interface{}) {
+		signingTime, err := time.Parse(signatures.TimeFormat, body["signing_time"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		audience, _ := body["audience"].(string)
+		if audience != "auth_cf_1234" {
+			t.Fatalf("expected %q but received %q", "auth_cf_1234", audience)
+		}
+		if _, err := signatures.Verify(body["signature"].(string), &signatures.SignatureData{
+			SigningTime:            signingTime,
+			Role:                   body["role"].(string),
+			CFInstanceCertContents: body["cf_instance_cert"].(string),
+			Audience:               audience,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	presented := false
+
+	// Make a fake Vault server that checks what it's sent.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make(map[string]interface{})
+		if r.Method == http.MethodPut {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		switch r.URL.Path {
+		case "/v1/auth/cf-other/audience":
+			w.Write([]byte(`{"data": {"audience": "auth_cf_1234"}}`))
+		case "/v1/auth/cf-other/login":
+			if r.Header.Get("X-Vault-Token") != "" {
+				t.Fatal("expected no token to be sent when logging in")
+			}
+			verifyLogin(body)
+			fmt.Fprintf(w, `{"auth": {"client_token": %q, "lease_duration": 60, "renewable": true}}`, testToken)
+		case "/v1/auth/cf-other/renew-with-cert":
+			if r.Header.Get("X-Vault-Token") != testToken {
+				t.Fatalf("expected token %q but received %q", testToken, r.Header.Get("X-Vault-Token"))
+			}
+			verifyLogin(body)
+			presented = true
+			w.Write([]byte(`{"data": {"cert_not_after": "2019-04-29T04:30:00Z"}}`))
+		case "/v1/auth/token/renew-self":
+			if r.Header.Get("X-Vault-Token") != testToken {
+				t.Fatalf("expected token %q but received %q", testToken, r.Header.Get("X-Vault-Token"))
+			}
+			if body["increment"] != float64(120) {
+				t.Fatalf("expected an increment of 120 but received %v", body["increment"])
+			}
+			fmt.Fprintf(w, `{"auth": {"client_token": %q, "lease_duration": 120, "renewable": true}}`, testToken)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := api.NewClient(&api.Config{Address: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetToken("stale-token")
+
+	ctx := context.Background()
+	secret, err := LoginWithClient(ctx, c, "cf-other", "test-role", &signatures.LoginOptions{
+		PathToInstanceCert: testCerts.PathToInstanceCertificate,
+		PathToInstanceKey:  testCerts.PathToInstanceKey,
+		Version:            signatures.Version2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Auth.ClientToken != testToken {
+		t.Fatalf("expected %q but received %q", testToken, secret.Auth.ClientToken)
+	}
+	if c.Token() != testToken {
+		t.Fatalf("expected the client's token to be set to %q but it's %q", testToken, c.Token())
+	}
+
+	secret, err = Renew(ctx, c, 2*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Auth.LeaseDuration != 120 {
+		t.Fatalf("expected a lease duration of 120 but received %d", secret.Auth.LeaseDuration)
+	}
+
+	secret, err = RenewWithCert(ctx, c, "cf-other", "test-role", &signatures.LoginOptions{
+		PathToInstanceCert: testCerts.PathToInstanceCertificate,
+		PathToInstanceKey:  testCerts.PathToInstanceKey,
+		Version:            signatures.Version2,
+	}, 2*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !presented || secret.Auth.LeaseDuration != 120 {
+		t.Fatalf("expected the certificate to be presented and the token renewed but received %#v", secret.Auth)
+	}
+
+	// Logging into a mount that doesn't exist fails.
+	if _, err := LoginWithClient(ctx, c, "", "test-role", &signatures.LoginOptions{
+		PathToInstanceCert: testCerts.PathToInstanceCertificate,
+		PathToInstanceKey:  testCerts.PathToInstanceKey,
+	}); err == nil {
+		t.Fatal("expected an error logging into a missing mount")
+	}
+}
+
+func TestLoginWrapped(t *testing.T) {
+	testCerts, err := certificates.Generate("instance-id", "org-id", "space-id", "app-id", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testCerts.Close()
+
+	// Roles with a response_wrap_ttl only return a wrapping token.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/auth/cf/login" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"wrap_info": {"token": "s.wrapping", "accessor": "wrapping-accessor", "ttl": 60, "wrapped_accessor": "token-accessor"}}`))
+	}))
+	defer ts.Close()
+
+	c, err := api.NewClient(&api.Config{Address: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetToken("stale-token")
+
+	secret, err := LoginWithClient(context.Background(), c, "", "wrapped-role", &signatures.LoginOptions{
+		PathToInstanceCert: testCerts.PathToInstanceCertificate,
+		PathToInstanceKey:  testCerts.PathToInstanceKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.WrapInfo == nil || secret.WrapInfo.Token != "s.wrapping" {
+		t.Fatalf("expected the wrapping token to be returned but received %#v", secret)
+	}
+	if c.Token() != "" {
+		t.Fatalf("expected the client to be left without a token but it has %q", c.Token())
+	}
+}
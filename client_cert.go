@@ -0,0 +1,162 @@
+package cf
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault-plugin-auth-cf/util"
+	"github.com/hashicorp/vault/sdk/helper/cidrutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// HeaderXFCC is the header used by the gorouter and Envoy to forward the client certificate
+	// presented to them.
+	HeaderXFCC = "X-Forwarded-Client-Cert"
+
+	// HeaderXForwardedFor is the header used by proxies to forward the address of their caller.
+	HeaderXForwardedFor = "X-Forwarded-For"
+)
+
+// clientCertLoginEnabled returns whether any mode of logging in without a signature is enabled.
+func clientCertLoginEnabled(config *models.Configuration) bool {
+	return config.EnableTLSClientCertLogin || len(config.XFCCTrustedProxyCIDRs) > 0
+}
+
+// verifyClientCert returns the instance identity certificate the caller presented during a TLS
+// handshake, either with Vault or with a trusted proxy in front of Vault, after making sure it was
+// issued by our CA. Because completing the TLS handshake already proves possession of the
+// certificate's private key, no further signature is needed. Errors are returned as a *loginFailure.
+func (b *backend) verifyClientCert(config *models.Configuration, req *logical.Request) (*x509.Certificate, error) {
+	var intermediateCerts []*x509.Certificate
+	var identityCert *x509.Certificate
+	switch {
+	case config.EnableTLSClientCertLogin && req.Connection != nil && req.Connection.ConnState != nil && len(req.Connection.ConnState.PeerCertificates) > 0:
+		peerCerts := req.Connection.ConnState.PeerCertificates
+		identityCert = peerCerts[0]
+		intermediateCerts = peerCerts[1:]
+	case len(config.XFCCTrustedProxyCIDRs) > 0 && headerValue(req, HeaderXFCC) != "":
+		if req.Connection == nil || !remoteAddrIsTrusted(req.Connection.RemoteAddr, config.XFCCTrustedProxyCIDRs) {
+			return nil, newLoginFailure(failureCategoryInvalidRequest, fmt.Errorf("%s header received from a proxy that isn't trusted", HeaderXFCC))
+		}
+		certContents, err := parseXFCC(headerValue(req, HeaderXFCC))
+		if err != nil {
+			return nil, newLoginFailure(failureCategoryInvalidRequest, err)
+		}
+		intermediateCerts, identityCert, err = util.ExtractCertificates(certContents)
+		if err != nil {
+			return nil, newLoginFailure(failureCategoryInvalidRequest, err)
+		}
+	default:
+		return nil, newLoginFailure(failureCategoryInvalidRequest, errors.New("'signature' and 'cf_instance_cert' are required unless a client certificate is presented"))
+	}
+	roots, err := b.getIdentityCAPool(config)
+	if err != nil {
+		return nil, newLoginFailure(failureCategoryUntrustedCertificate, err)
+	}
+	if err := util.ValidateWithRoots(roots, intermediateCerts, identityCert, identityCert); err != nil {
+		return nil, newLoginFailure(failureCategoryUntrustedCertificate, err)
+	}
+	return identityCert, nil
+}
+
+// headerValue returns the first value of the given header, matching its name case-insensitively.
+// Headers are only available to the plugin if they've been added to the mount's
+// "passthrough_request_headers".
+func headerValue(req *logical.Request, name string) string {
+	for k, v := range req.Headers {
+		if strings.EqualFold(k, name) && len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+// remoteAddrIsTrusted returns whether the given remote address falls within any of the given CIDRs.
+func remoteAddrIsTrusted(remoteAddr string, cidrs []string) bool {
+	// Some remote addresses may arrive like "10.255.181.105/32", so strip the mask.
+	remoteIP := strings.Split(remoteAddr, "/")[0]
+	trusted, err := cidrutil.IPBelongsToCIDRBlocksSlice(remoteIP, cidrs)
+	if err != nil {
+		return false
+	}
+	return trusted
+}
+
+// parseXFCC returns the PEM-encoded certificates carried in an X-Forwarded-Client-Cert header.
+// Two forms are understood:
+//   - The gorouter's, which is the base64-encoded DER of the client certificate.
+//   - Envoy's, which is a set of key=value pairs where "Cert" holds the URL-encoded PEM client
+//     certificate and "Chain" holds its URL-encoded PEM chain.
+//
+// Because a header carrying more than one element can't tell us which one belongs to the instance,
+// only one element is accepted.
+func parseXFCC(header string) (string, error) {
+	elements := splitOutsideQuotes(header, ',')
+	if len(elements) != 1 {
+		return "", fmt.Errorf("expected 1 element in %s header but received %d", HeaderXFCC, len(elements))
+	}
+	element := strings.TrimSpace(elements[0])
+
+	if !strings.Contains(element, "=") || isBase64(element) {
+		der, err := base64.StdEncoding.DecodeString(element)
+		if err != nil {
+			return "", fmt.Errorf("unable to decode %s header: %s", HeaderXFCC, err)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), nil
+	}
+
+	var cert, chain string
+	for _, pair := range splitOutsideQuotes(element, ';') {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value, err := url.PathUnescape(strings.Trim(strings.TrimSpace(kv[1]), `"`))
+		if err != nil {
+			return "", fmt.Errorf("unable to decode %s in %s header: %s", kv[0], HeaderXFCC, err)
+		}
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "cert":
+			cert = value
+		case "chain":
+			chain = value
+		}
+	}
+	if cert == "" && chain == "" {
+		return "", fmt.Errorf("no certificate found in %s header", HeaderXFCC)
+	}
+	return cert + "\n" + chain, nil
+}
+
+// isBase64 returns whether the given value is entirely base64, which may include trailing padding.
+func isBase64(value string) bool {
+	_, err := base64.StdEncoding.DecodeString(value)
+	return err == nil
+}
+
+// splitOutsideQuotes splits the given string on sep, ignoring any that appear within double quotes.
+func splitOutsideQuotes(s string, sep rune) []string {
+	var parts []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case r == sep && !quoted:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	return append(parts, current.String())
+}
@@ -0,0 +1,58 @@
+package cf
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/testing/certificates"
+	"github.com/hashicorp/vault-plugin-auth-cf/util"
+)
+
+func TestParseXFCC(t *testing.T) {
+	testCerts, err := certificates.Generate("instance-id", "org-id", "space-id", "app-id", "10.255.181.105")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := testCerts.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	intermediateCerts, identityCert, err := util.ExtractCertificates(testCerts.InstanceCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: identityCert.Raw}))
+	intermediatePEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: intermediateCerts[0].Raw}))
+
+	headers := map[string]string{
+		"gorouter":         base64.StdEncoding.EncodeToString(identityCert.Raw),
+		"envoy cert":       fmt.Sprintf(`Hash=abc;Subject="CN=instance-id,OU=app:app-id";Cert="%s"`, url.PathEscape(identityPEM)),
+		"envoy cert chain": fmt.Sprintf(`By=spiffe://cf;Cert="%s";Chain="%s"`, url.PathEscape(identityPEM), url.PathEscape(intermediatePEM)),
+	}
+	for name, header := range headers {
+		t.Run(name, func(t *testing.T) {
+			certContents, err := parseXFCC(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, leaf, err := util.ExtractCertificates(certContents)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !leaf.Equal(identityCert) {
+				t.Fatalf("expected %s but received %s", identityCert.Subject, leaf.Subject)
+			}
+		})
+	}
+
+	if _, err := parseXFCC(headers["gorouter"] + "," + headers["gorouter"]); err == nil {
+		t.Fatal("expected an error for multiple elements")
+	}
+	if _, err := parseXFCC(`Hash=abc`); err == nil {
+		t.Fatal("expected an error for a missing certificate")
+	}
+}
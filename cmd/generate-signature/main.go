@@ -4,58 +4,179 @@ package main
 
 Usage:
 
-	export CF_INSTANCE_CERT=path/to/instance.crt
-	export CF_INSTANCE_KEY=path/to/instance.key
-	export SIGNING_TIME=$(date -u)
-	export ROLE='test-role'
-	generate-signature
+	generate-signature test-role
 
-To use it for directly logging into Vault:
+Inside a CF instance, nothing else is needed: the certificate and key are read from the paths
+in CF_INSTANCE_CERT and CF_INSTANCE_KEY, and the signature is made with the current time.
+Elsewhere, or to use other paths, such as on Windows cells, they may be given explicitly, and
+the role may be set in ROLE rather than given as an argument:
 
-	export CF_INSTANCE_CERT=path/to/instance.crt
-	export CF_INSTANCE_KEY=path/to/instance.key
 	export SIGNING_TIME=$(date -u)
 	export ROLE='test-role'
-	vault write auth/vault-plugin-auth-cf/login \
-		role=$ROLE \
-		certificate=$CF_INSTANCE_CERT \
-		signing_time=SIGNING_TIME \
-		signature=$(generate-signature)
+	generate-signature -cert=path/to/instance.crt -key=path/to/instance.key
+
+If the key at CF_INSTANCE_KEY is encrypted, also set its passphrase:
+
+	export CF_INSTANCE_KEY_PASSPHRASE='passphrase'
+
+To create a version 2 signature rather than a version 1 signature:
+
+	export SIGNATURE_VERSION=2
+
+Version 2 signatures may also be bound to the audience read from auth/cf/audience:
+
+	export AUDIENCE=$(vault read -field=audience auth/cf/audience)
+
+The signing time may be given in any format the plugin accepts for "signing_time", including
+the output of PowerShell's Get-Date.
+
+By default only the signature is printed. To print the full body of a login request instead,
+or a command that sends it, use -format=json, -format=curl, -format=vault, or -format=powershell:
+
+	generate-signature -format=json test-role > login.json
+	vault write auth/cf/login @login.json
+
+	eval "$(generate-signature -format=vault test-role)"
+
+	eval "$(generate-signature -format=curl test-role)"
+
+The commands write to the login endpoint of the mount given by -mount, "cf" by default, and
+the curl and PowerShell commands send the request to $VAULT_ADDR.
+
+On Windows cells, CF sets the same variables, which PowerShell reads from $env:
+
+	$env:SIGNING_TIME = (Get-Date).ToUniversalTime()
+	$env:ROLE = 'test-role'
+	generate-signature.exe -format=powershell | Invoke-Expression
 */
 
 import (
-	"io/ioutil"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault-plugin-auth-cf/signatures"
-	"github.com/hashicorp/vault-plugin-auth-cf/util"
+)
+
+// These are the values accepted for "-format".
+const (
+	formatSignature  = "signature"
+	formatJSON       = "json"
+	formatCurl       = "curl"
+	formatVault      = "vault"
+	formatPowerShell = "powershell"
+)
+
+var (
+	pathToInstanceCert = flag.String("cert", "", `The path to the instance certificate. Defaults to the value of CF_INSTANCE_CERT`)
+	pathToInstanceKey  = flag.String("key", "", `The path to the instance key. Defaults to the value of CF_INSTANCE_KEY`)
+
+	format = flag.String("format", formatSignature, `What to print: "signature", "json", "curl", "vault", or "powershell"`)
+	mount  = flag.String("mount", "cf", `The path the CF auth method is mounted at, used by the "curl", "vault", and "powershell" formats`)
 )
 
 func main() {
-	signingTimeRaw := os.Getenv("SIGNING_TIME")
-	signingTime, err := time.Parse(util.BashTimeFormat, signingTimeRaw)
-	if err != nil {
-		log.Fatal(err)
+	flag.Parse()
+
+	roleName := flag.Arg(0)
+	if roleName == "" {
+		roleName = os.Getenv("ROLE")
+	}
+	if roleName == "" {
+		log.Fatal("the role must be given as an argument or set in ROLE")
 	}
 
-	pathToInstanceCert := os.Getenv("CF_INSTANCE_CERT")
-	pathToInstanceKey := os.Getenv("CF_INSTANCE_KEY")
-	roleName := os.Getenv("ROLE")
+	// The signing time is optional, and defaults to the current time.
+	var signingTime time.Time
+	if signingTimeRaw := os.Getenv("SIGNING_TIME"); signingTimeRaw != "" {
+		var err error
+		signingTime, err = signatures.ParseSigningTime(signingTimeRaw)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	signatureVersion := signatures.Version1
+	if raw := os.Getenv("SIGNATURE_VERSION"); raw != "" {
+		var err error
+		signatureVersion, err = strconv.Atoi(raw)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	audience := os.Getenv("AUDIENCE")
 
-	instanceCertBytes, err := ioutil.ReadFile(pathToInstanceCert)
+	// The key may be encrypted, in which case its passphrase is read from CF_INSTANCE_KEY_PASSPHRASE.
+	signatureData, signature, err := signatures.SignLogin(roleName, &signatures.LoginOptions{
+		PathToInstanceCert: *pathToInstanceCert,
+		PathToInstanceKey:  *pathToInstanceKey,
+		SigningTime:        signingTime,
+		Version:            signatureVersion,
+		Audience:           audience,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	signature, err := signatures.Sign(pathToInstanceKey, &signatures.SignatureData{
-		SigningTime:            signingTime,
-		CFInstanceCertContents: string(instanceCertBytes),
-		Role:                   roleName,
-	})
+	fields := [][2]string{
+		{"role", roleName},
+		{"cf_instance_cert", signatureData.CFInstanceCertContents},
+		{"signing_time", signatureData.SigningTime.Format(signatures.TimeFormat)},
+		{"signature", signature},
+	}
+	if audience != "" {
+		fields = append(fields, [2]string{"audience", audience})
+	}
+
+	switch *format {
+	case formatSignature:
+		fmt.Println(signature)
+	case formatJSON:
+		fmt.Println(loginJSON(fields))
+	case formatCurl:
+		fmt.Printf("curl --request POST --data %s \"$VAULT_ADDR/v1/auth/%s/login\"\n", shellQuote(loginJSON(fields)), *mount)
+	case formatVault:
+		command := "vault write " + shellQuote(fmt.Sprintf("auth/%s/login", *mount))
+		for _, field := range fields {
+			command += " \\\n    " + shellQuote(field[0]+"="+field[1])
+		}
+		fmt.Println(command)
+	case formatPowerShell:
+		fmt.Println(powerShellCommand(loginJSON(fields), *mount))
+	default:
+		log.Fatalf("%q is not a valid format", *format)
+	}
+}
+
+// loginJSON returns the body of a login request with the given fields.
+func loginJSON(fields [][2]string) string {
+	body := make(map[string]string, len(fields))
+	for _, field := range fields {
+		body[field[0]] = field[1]
+	}
+	bodyBytes, err := json.Marshal(body)
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Print(signature)
+	return string(bodyBytes)
+}
+
+// powerShellCommand returns a PowerShell command that posts the login body to the given mount.
+func powerShellCommand(body, mount string) string {
+	return fmt.Sprintf("Invoke-RestMethod -Method Post -ContentType 'application/json' -Body %s -Uri \"$env:VAULT_ADDR/v1/auth/%s/login\"", powerShellQuote(body), mount)
+}
+
+// shellQuote quotes s so that a POSIX shell reads it as a single word.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// powerShellQuote quotes s so that PowerShell reads it as a single verbatim string.
+func powerShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	for _, s := range []string{"", "role", "it's", "'", `{"role":"it's a role"}`, "$HOME `pwd` \\ \"", "a\nb"} {
+		quoted := shellQuote(s)
+		if quoted[0] != '\'' || quoted[len(quoted)-1] != '\'' {
+			t.Fatalf("expected %q to be quoted but received %s", s, quoted)
+		}
+		if _, err := exec.LookPath("sh"); err != nil {
+			continue
+		}
+		out, err := exec.Command("sh", "-c", "printf %s "+quoted).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != s {
+			t.Fatalf("expected the shell to read %s as %q but received %q", quoted, s, out)
+		}
+	}
+}
+
+func TestPowerShellQuote(t *testing.T) {
+	for _, testCase := range []struct {
+		s        string
+		expected string
+	}{
+		{"", `''`},
+		{"role", `'role'`},
+		{"it's", `'it''s'`},
+		{"'", `''''`},
+		{`{"role":"it's a role"}`, `'{"role":"it''s a role"}'`},
+		{"$env:HOME `n", "'$env:HOME `n'"},
+	} {
+		if quoted := powerShellQuote(testCase.s); quoted != testCase.expected {
+			t.Fatalf("expected %q to be quoted as %s but received %s", testCase.s, testCase.expected, quoted)
+		}
+	}
+}
+
+func TestPowerShellCommand(t *testing.T) {
+	expected := `Invoke-RestMethod -Method Post -ContentType 'application/json' -Body '{"role":"it''s a role"}' -Uri "$env:VAULT_ADDR/v1/auth/cf-other/login"`
+	if command := powerShellCommand(`{"role":"it's a role"}`, "cf-other"); command != expected {
+		t.Fatalf("expected %s but received %s", expected, command)
+	}
+}
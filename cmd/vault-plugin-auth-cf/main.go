@@ -10,6 +10,16 @@ import (
 )
 
 func main() {
+	// Vault runs the plugin without arguments, so a subcommand means it's being run by an operator.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "troubleshoot":
+			os.Exit(troubleshoot(os.Args[2:], os.Stdout))
+		case "verify-signature":
+			os.Exit(verifySignature(os.Args[2:], os.Stdout))
+		}
+	}
+
 	apiClientMeta := &api.PluginAPIClientMeta{}
 	flags := apiClientMeta.FlagSet()
 	flags.Parse(os.Args[1:])
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault-plugin-auth-cf/signatures"
+	"github.com/hashicorp/vault-plugin-auth-cf/util"
+	"github.com/hashicorp/vault/api"
+)
+
+const troubleshootUsage = `Usage: vault-plugin-auth-cf troubleshoot -role=<role> [options]
+
+  Walks through each stage of logging in with a CF instance identity certificate,
+  first locally and then against the verify endpoint of a Vault server, and
+  reports which stage fails. The Vault server's address and a token allowed to
+  write to the verify endpoint are read from VAULT_ADDR and VAULT_TOKEN, like
+  the Vault CLI reads them, unless -vault-addr is given.
+
+Options:
+`
+
+// troubleshooter prints the outcome of each stage of a login as it's checked.
+type troubleshooter struct {
+	out    io.Writer
+	failed bool
+}
+
+func (t *troubleshooter) pass(stage, detail string) {
+	fmt.Fprintf(t.out, "PASS  %s: %s\n", stage, detail)
+}
+
+func (t *troubleshooter) skip(stage, reason string) {
+	fmt.Fprintf(t.out, "SKIP  %s: %s\n", stage, reason)
+}
+
+func (t *troubleshooter) fail(stage string, err error) {
+	fmt.Fprintf(t.out, "FAIL  %s: %s\n", stage, err)
+	t.failed = true
+}
+
+// troubleshoot runs the troubleshoot subcommand with the given arguments, and returns
+// its exit code.
+func troubleshoot(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("troubleshoot", flag.ContinueOnError)
+	flags.SetOutput(out)
+	flags.Usage = func() {
+		fmt.Fprint(out, troubleshootUsage)
+		flags.PrintDefaults()
+	}
+	role := flags.String("role", "", "The name of the role to log in with. Required.")
+	pathToInstanceCert := flags.String("cert", os.Getenv(signatures.EnvVarInstanceCertificate), "The path to the instance certificate. Defaults to the value of CF_INSTANCE_CERT.")
+	pathToInstanceKey := flags.String("key", os.Getenv(signatures.EnvVarInstanceKey), "The path to the instance key. Defaults to the value of CF_INSTANCE_KEY.")
+	pathToCACert := flags.String("ca-cert", "", "The path to the identity CA certificate configured in Vault. If given, the certificate chain is checked locally.")
+	vaultAddr := flags.String("vault-addr", "", "The address of the Vault server. Defaults to the value of VAULT_ADDR.")
+	mount := flags.String("mount", "cf", "The path the CF auth method is mounted at.")
+	signatureVersion := flags.Int("signature-version", signatures.Version1, "The version of the signature format to sign with.")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *role == "" {
+		fmt.Fprintln(out, `"-role" is required`)
+		flags.Usage()
+		return 2
+	}
+
+	t := &troubleshooter{out: out}
+
+	// Local checks: each of these depends on the ones before it.
+	certBytes, err := ioutil.ReadFile(*pathToInstanceCert)
+	if err != nil {
+		t.fail("read certificate", err)
+		return 1
+	}
+	intermediateCerts, identityCert, err := util.ExtractCertificates(string(certBytes))
+	if err != nil {
+		t.fail("read certificate", err)
+		return 1
+	}
+	t.pass("read certificate", fmt.Sprintf("found identity certificate %q and %d intermediate certificates", identityCert.Subject, len(intermediateCerts)))
+
+	if cfCert, err := models.NewCFCertificateFromx509(identityCert); err == nil {
+		t.pass("certificate identity", fmt.Sprintf("instance %s of app %s in space %s and org %s, at %s", cfCert.InstanceID, cfCert.AppID, cfCert.SpaceID, cfCert.OrgID, cfCert.IPAddress))
+	} else if serviceInstanceCert, serviceInstanceErr := models.NewServiceInstanceCertificateFromx509(identityCert); serviceInstanceErr == nil {
+		t.pass("certificate identity", fmt.Sprintf("service instance %s in space %s and org %s; the role must allow service instance login", serviceInstanceCert.InstanceID, serviceInstanceCert.SpaceID, serviceInstanceCert.OrgID))
+	} else {
+		t.fail("certificate identity", err)
+	}
+
+	now := time.Now()
+	switch {
+	case now.Before(identityCert.NotBefore):
+		t.fail("certificate validity", fmt.Errorf("not valid until %s; check this machine's clock", identityCert.NotBefore.UTC().Format(time.RFC3339)))
+	case now.After(identityCert.NotAfter):
+		t.fail("certificate validity", fmt.Errorf("expired at %s; CF should have replaced it", identityCert.NotAfter.UTC().Format(time.RFC3339)))
+	default:
+		t.pass("certificate validity", fmt.Sprintf("valid until %s", identityCert.NotAfter.UTC().Format(time.RFC3339)))
+	}
+
+	keyBytes, err := ioutil.ReadFile(*pathToInstanceKey)
+	if err != nil {
+		t.fail("read private key", err)
+		return 1
+	}
+	if _, err := util.ParsePrivateKey(keyBytes, []byte(os.Getenv(signatures.EnvVarInstanceKeyPassphrase))); err != nil {
+		t.fail("read private key", err)
+		return 1
+	}
+	t.pass("read private key", *pathToInstanceKey)
+
+	config := api.DefaultConfig()
+	if config.Error != nil {
+		t.fail("connect to vault", config.Error)
+		return 1
+	}
+	if *vaultAddr != "" {
+		config.Address = *vaultAddr
+	}
+	c, err := api.NewClient(config)
+	if err != nil {
+		t.fail("connect to vault", err)
+		return 1
+	}
+
+	// Version 2 signatures are bound to the server's audience; see client.LoginWithClient.
+	audience := ""
+	if *signatureVersion >= signatures.Version2 {
+		if secret, err := c.Logical().Read(fmt.Sprintf("auth/%s/audience", *mount)); err == nil && secret != nil {
+			audience, _ = secret.Data["audience"].(string)
+		}
+	}
+
+	signatureData, signature, err := signatures.SignLogin(*role, &signatures.LoginOptions{
+		PathToInstanceCert: *pathToInstanceCert,
+		PathToInstanceKey:  *pathToInstanceKey,
+		Version:            *signatureVersion,
+		Audience:           audience,
+	})
+	if err != nil {
+		t.fail("signature", err)
+		return 1
+	}
+	signingCert, err := signatures.Verify(signature, signatureData)
+	if err != nil {
+		t.fail("signature", fmt.Errorf("the key doesn't match any certificate in the file: %s", err))
+		return 1
+	}
+	if !reflect.DeepEqual(signingCert, identityCert) {
+		t.fail("signature", fmt.Errorf("the key matches %q rather than the identity certificate", signingCert.Subject))
+		return 1
+	}
+	t.pass("signature", "the key matches the identity certificate")
+
+	if *pathToCACert == "" {
+		t.skip("certificate chain", "no -ca-cert given")
+	} else if caCertBytes, err := ioutil.ReadFile(*pathToCACert); err != nil {
+		t.fail("certificate chain", err)
+	} else if err := util.Validate([]string{string(caCertBytes)}, intermediateCerts, identityCert, signingCert); err != nil {
+		t.fail("certificate chain", chainError(err))
+	} else {
+		t.pass("certificate chain", "the identity certificate chains to the CA certificate")
+	}
+
+	// Server checks: the verify endpoint runs every check a login would, without issuing a token.
+	loginData := map[string]interface{}{
+		"role":             *role,
+		"cf_instance_cert": signatureData.CFInstanceCertContents,
+		"signing_time":     signatureData.SigningTime.Format(signatures.TimeFormat),
+		"signature":        signature,
+	}
+	if audience != "" {
+		loginData["audience"] = audience
+	}
+	secret, err := c.Logical().Write(fmt.Sprintf("auth/%s/verify", *mount), loginData)
+	if err != nil {
+		t.fail("vault verify", err)
+		return 1
+	}
+	if secret == nil {
+		t.fail("vault verify", fmt.Errorf("empty response from %s", c.Address()))
+		return 1
+	}
+	checks, _ := secret.Data["checks"].([]interface{})
+	for _, raw := range checks {
+		check, _ := raw.(map[string]interface{})
+		name := fmt.Sprintf("vault %v", check["name"])
+		switch check["status"] {
+		case "passed":
+			t.pass(name, "passed on the server")
+		case "failed":
+			t.fail(name, fmt.Errorf("%v", check["error"]))
+		default:
+			t.skip(name, "not reached or not needed")
+		}
+	}
+	if success, _ := secret.Data["success"].(bool); success {
+		fmt.Fprintf(out, "\nLogin would succeed with policies %v and metadata %v\n", secret.Data["policies"], secret.Data["metadata"])
+	}
+
+	if t.failed {
+		return 1
+	}
+	return 0
+}
+
+// chainError adds a hint to certificate chain errors about their most common cause.
+func chainError(err error) error {
+	if _, ok := err.(x509.UnknownAuthorityError); ok {
+		return fmt.Errorf("%s; the configured CA may not be the instance identity CA that issued this certificate", err)
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/signatures"
+	"github.com/hashicorp/vault-plugin-auth-cf/util"
+)
+
+const verifySignatureUsage = `Usage: vault-plugin-auth-cf verify-signature -signature=<signature> -signing-time=<time> [options]
+
+  Verifies a login signature the way a Vault server would, without sending
+  anything to Vault, so signing code can be checked without writing to its audit
+  log. The role, signing time, and audience must be given exactly as they'd be
+  sent in the login request.
+
+Options:
+`
+
+// verifySignature runs the verify-signature subcommand with the given arguments, and returns
+// its exit code.
+func verifySignature(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("verify-signature", flag.ContinueOnError)
+	flags.SetOutput(out)
+	flags.Usage = func() {
+		fmt.Fprint(out, verifySignatureUsage)
+		flags.PrintDefaults()
+	}
+	pathToInstanceCert := flags.String("cert", os.Getenv(signatures.EnvVarInstanceCertificate), "The path to the instance certificate that was sent as cf_instance_cert. Defaults to the value of CF_INSTANCE_CERT.")
+	signature := flags.String("signature", "", "The signature. Required.")
+	signingTimeRaw := flags.String("signing-time", "", "The signing time, in any format Vault accepts. Required.")
+	role := flags.String("role", "", "The name of the role that was signed. Leave it out if the login leaves the role to be selected.")
+	audience := flags.String("audience", "", "The audience the signature is bound to, if any.")
+	pathToCACert := flags.String("ca-cert", "", "The path to the identity CA certificate configured in Vault. If given, the certificate chain is checked.")
+	maxSecondsNotBefore := flags.Int("max-seconds-not-before", 300, "How old the signing time may be, as set in login_max_seconds_not_before.")
+	maxSecondsNotAfter := flags.Int("max-seconds-not-after", 60, "How far into the future the signing time may be, as set in login_max_seconds_not_after.")
+	minimumVersion := flags.Int("minimum-signature-version", signatures.Version1, "The oldest version of the signature format to accept, as set in minimum_signature_version.")
+	var signingTimeLayouts stringList
+	flags.Var(&signingTimeLayouts, "signing-time-layout", "An additional layout accepted for the signing time, as set in signing_time_layouts. May be repeated.")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *signature == "" || *signingTimeRaw == "" {
+		fmt.Fprintln(out, `"-signature" and "-signing-time" are required`)
+		flags.Usage()
+		return 2
+	}
+
+	t := &troubleshooter{out: out}
+
+	// Like the server's, these checks are made in the order they're made in a login.
+	certBytes, err := ioutil.ReadFile(*pathToInstanceCert)
+	if err != nil {
+		t.fail("request", err)
+		return 1
+	}
+	intermediateCerts, identityCert, err := util.ExtractCertificates(string(certBytes))
+	if err != nil {
+		t.fail("request", err)
+		return 1
+	}
+	signingTime, err := signatures.ParseSigningTime(*signingTimeRaw, signingTimeLayouts...)
+	if err != nil {
+		t.fail("request", err)
+		return 1
+	}
+	t.pass("request", fmt.Sprintf("signed at %s", signingTime.UTC().Format(time.RFC3339)))
+
+	// The signing time is checked against now, so an old signature fails this but may still be valid.
+	now := time.Now()
+	switch {
+	case signingTime.Before(now.Add(-time.Duration(*maxSecondsNotBefore) * time.Second)):
+		t.fail("signing time", fmt.Errorf("signed %s ago, but may be at most %ds old", now.Sub(signingTime).Round(time.Second), *maxSecondsNotBefore))
+	case signingTime.After(now.Add(time.Duration(*maxSecondsNotAfter) * time.Second)):
+		t.fail("signing time", fmt.Errorf("signed %s in the future, but may be at most %ds in the future; check the signer's clock", signingTime.Sub(now).Round(time.Second), *maxSecondsNotAfter))
+	default:
+		t.pass("signing time", "within the allowed window")
+	}
+
+	signingCert, err := signatures.Verify(*signature, &signatures.SignatureData{
+		SigningTime:            signingTime,
+		Role:                   *role,
+		CFInstanceCertContents: string(certBytes),
+		Audience:               *audience,
+	})
+	if err != nil {
+		t.fail("signature", fmt.Errorf("the role, signing time, audience, and certificate must be exactly what was signed: %s", err))
+		return 1
+	}
+	if version, _ := signatures.VersionOf(*signature); version < *minimumVersion {
+		t.fail("signature", fmt.Errorf("signature version %d is not accepted; the minimum version is %d", version, *minimumVersion))
+	} else {
+		t.pass("signature", fmt.Sprintf("version %d signature by %q", version, signingCert.Subject))
+	}
+
+	if *pathToCACert == "" {
+		t.skip("certificate chain", "no -ca-cert given")
+	} else if caCertBytes, err := ioutil.ReadFile(*pathToCACert); err != nil {
+		t.fail("certificate chain", err)
+	} else if err := util.Validate([]string{string(caCertBytes)}, intermediateCerts, identityCert, signingCert); err != nil {
+		t.fail("certificate chain", chainError(err))
+	} else {
+		t.pass("certificate chain", "the identity certificate chains to the CA certificate")
+	}
+
+	if t.failed {
+		return 1
+	}
+	return 0
+}
+
+// stringList is a flag that may be given more than once, collecting each value.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -78,12 +78,12 @@ func main() {
 		log.Fatalf(`couldn't verify signature: %s\n`, err)
 	}
 
-	intermediateCert, identityCert, err := util.ExtractCertificates(string(instanceCertBytes))
+	intermediateCerts, identityCert, err := util.ExtractCertificates(string(instanceCertBytes))
 	if err != nil {
 		log.Fatalf(`couldn't extract certificates from %s: %s'`, instanceCertBytes, err)
 	}
 
-	if err := util.Validate([]string{string(caCertBytes)}, intermediateCert, identityCert, signingCert); err != nil {
+	if err := util.Validate([]string{string(caCertBytes)}, intermediateCerts, identityCert, signingCert); err != nil {
 		log.Fatalf(`couldn't validate cert chain: %s'`, err)
 	}
 
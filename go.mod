@@ -1,8 +1,9 @@
 module github.com/hashicorp/vault-plugin-auth-cf
 
-go 1.12
+go 1.13
 
 require (
+	github.com/armon/go-metrics v0.3.0
 	github.com/cloudfoundry-community/go-cfclient v0.0.0-20190201205600-f136f9222381
 	github.com/hashicorp/go-cleanhttp v0.5.1
 	github.com/hashicorp/go-hclog v0.12.0
@@ -13,6 +14,8 @@ require (
 	github.com/hashicorp/vault/sdk v0.1.14-0.20200215224050-f6547fa8e820
 	github.com/hashicorp/yamux v0.0.0-20181012175058-2f1d1f20f75d // indirect
 	github.com/pkg/errors v0.8.1
+	golang.org/x/crypto v0.0.0-20190418165655-df01cb2cc480
+	golang.org/x/oauth2 v0.0.0-20190130055435-99b60b757ec1
 	golang.org/x/text v0.3.1-0.20181227161524-e6919f6577db // indirect
 	google.golang.org/genproto v0.0.0-20190404172233-64821d5d2107 // indirect
 )
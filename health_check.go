@@ -0,0 +1,192 @@
+package cf
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault-plugin-auth-cf/util"
+	"golang.org/x/oauth2"
+)
+
+// healthCheckInterval is how often the periodic func checks for problems that will soon cause
+// logins to fail, so that warnings are noticed without flooding the logs.
+const healthCheckInterval = time.Hour
+
+// defaultIdentityCAExpiryWarning is how long before the identity CA expires that warnings begin.
+const defaultIdentityCAExpiryWarning = 30 * 24 * time.Hour
+
+// checkHealth warns about problems with the config that will soon cause logins to fail, or
+// that already are.
+func (b *backend) checkHealth(config *models.Configuration, now time.Time) {
+	b.checkIdentityCAExpiry(config, now)
+	b.checkCFCredential(config, now)
+}
+
+// checkIdentityCAExpiry warns when every configured identity CA certificate is about to expire.
+// While the CA is being rotated, the old certificate may be near its expiry, but the new one
+// isn't, so only the last to expire is considered.
+func (b *backend) checkIdentityCAExpiry(config *models.Configuration, now time.Time) {
+	var lastNotAfter time.Time
+	for _, caCert := range config.IdentityCACertificates {
+		certs, err := util.ParseCertificates(caCert)
+		if err != nil {
+			b.Logger().Warn("unable to parse a configured identity CA certificate", "error", err)
+			continue
+		}
+		for _, cert := range certs {
+			if cert.NotAfter.After(lastNotAfter) {
+				lastNotAfter = cert.NotAfter
+			}
+		}
+	}
+	if lastNotAfter.IsZero() {
+		return
+	}
+
+	remaining := lastNotAfter.Sub(now)
+	metrics.SetGauge([]string{metricPrefix, "identity_ca", "seconds_until_expiry"}, float32(remaining/time.Second))
+	switch {
+	case remaining <= 0:
+		b.Logger().Error("every identity CA certificate has expired; logins will fail until a current one is configured", "expired_at", lastNotAfter.Format(time.RFC3339))
+	case remaining <= identityCAExpiryWarning(config):
+		b.Logger().Warn(fmt.Sprintf("the identity CA certificates expire in %s; configure the next CA certificate before then, or logins will fail", remaining.Round(time.Hour)), "expires_at", lastNotAfter.Format(time.RFC3339))
+	}
+}
+
+// These are the states the configured CF API credentials may be found in.
+const (
+	cfCredentialStateUnknown   = "unknown"
+	cfCredentialStateOK        = "ok"
+	cfCredentialStateFailing   = "failing"
+	cfCredentialStateExpired   = "expired"
+	cfCredentialStateLockedOut = "locked_out"
+)
+
+// cfCredentialHealth is what the last check of the configured CF API credentials found. It's kept in
+// memory on each node, and is empty until the first check.
+type cfCredentialHealth struct {
+	lock sync.RWMutex
+
+	state               string
+	checkedAt           time.Time
+	lastSuccessAt       time.Time
+	consecutiveFailures int
+	lastError           string
+
+	// accessTokenExpiresAt and refreshTokenExpiresAt are when the tokens UAA issued at the last
+	// successful check expire, if they could be read.
+	accessTokenExpiresAt  time.Time
+	refreshTokenExpiresAt time.Time
+}
+
+// status describes the last check for the credential status endpoint.
+func (h *cfCredentialHealth) status() map[string]interface{} {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	state := h.state
+	if state == "" {
+		state = cfCredentialStateUnknown
+	}
+	return map[string]interface{}{
+		"state":                    state,
+		"checked_at":               formatTime(h.checkedAt),
+		"last_success_at":          formatTime(h.lastSuccessAt),
+		"consecutive_failures":     h.consecutiveFailures,
+		"error":                    h.lastError,
+		"access_token_expires_at":  formatTime(h.accessTokenExpiresAt),
+		"refresh_token_expires_at": formatTime(h.refreshTokenExpiresAt),
+	}
+}
+
+// formatTime formats the time as RFC 3339, or as an empty string if it's zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// checkCFCredential obtains a token from UAA with the configured credentials, and warns if they're
+// refused. A new client is used, since the shared one only authenticates again once its refresh token
+// expires, by which time logins would already be failing. If the shared client's refresh token will
+// expire before the next check, or no longer works, the shared client is rebuilt so logins don't fail
+// when it can't be refreshed.
+func (b *backend) checkCFCredential(config *models.Configuration, now time.Time) {
+	var token *oauth2.Token
+	client, err := b.newCFClient(config)
+	if err == nil {
+		token, err = client.Config.TokenSource.Token()
+	}
+
+	b.cfCredential.lock.Lock()
+	b.cfCredential.checkedAt = now
+	if err != nil {
+		b.cfCredential.state = cfCredentialErrorState(err)
+		b.cfCredential.consecutiveFailures++
+		b.cfCredential.lastError = err.Error()
+		state, failures := b.cfCredential.state, b.cfCredential.consecutiveFailures
+		b.cfCredential.lock.Unlock()
+
+		metrics.IncrCounter([]string{metricPrefix, "api", "credential_failure"}, 1)
+		metrics.SetGauge([]string{metricPrefix, "api", "credential", "healthy"}, 0)
+		switch state {
+		case cfCredentialStateLockedOut:
+			b.Logger().Error("UAA has locked out the configured CF API user after too many failed logins; logins will fail until it's unlocked", "error", err)
+		case cfCredentialStateExpired:
+			b.Logger().Error("the password of the configured CF API user has expired; logins will fail until it's changed and the config is updated", "error", err)
+		default:
+			// Repeated failures may themselves get the user locked out, so they're called out.
+			b.Logger().Warn("unable to obtain a token from the CF API with the configured credentials; logins will fail until they're fixed", "consecutive_failures", failures, "error", err)
+		}
+		return
+	}
+	wasFailing := b.cfCredential.consecutiveFailures > 0
+	b.cfCredential.state = cfCredentialStateOK
+	b.cfCredential.lastSuccessAt = now
+	b.cfCredential.consecutiveFailures = 0
+	b.cfCredential.lastError = ""
+	b.cfCredential.accessTokenExpiresAt = token.Expiry
+	b.cfCredential.refreshTokenExpiresAt, _ = util.TokenExpiry(token.RefreshToken)
+	b.cfCredential.lock.Unlock()
+
+	metrics.SetGauge([]string{metricPrefix, "api", "credential", "healthy"}, 1)
+	if wasFailing {
+		b.Logger().Info("obtained a token from the CF API with the configured credentials again")
+	}
+
+	shared, err := b.getCFClient(config)
+	if err != nil {
+		return
+	}
+	sharedToken, err := shared.Config.TokenSource.Token()
+	if err != nil {
+		b.Logger().Info("the shared CF API client can no longer obtain tokens, so it's being rebuilt", "error", err)
+		b.resetCFClient()
+		return
+	}
+	if refreshExpiry, ok := util.TokenExpiry(sharedToken.RefreshToken); ok {
+		metrics.SetGauge([]string{metricPrefix, "api", "credential", "refresh_token_seconds_until_expiry"}, float32(refreshExpiry.Sub(now)/time.Second))
+		if refreshExpiry.Before(now.Add(healthCheckInterval)) {
+			b.Logger().Info("the shared CF API client's refresh token expires before the next check, so the client is being rebuilt", "expires_at", refreshExpiry.Format(time.RFC3339))
+			b.resetCFClient()
+		}
+	}
+}
+
+// cfCredentialErrorState returns the state of credentials that UAA refused with the given error.
+// UAA explains in the error's description when a user's locked out or their password has expired.
+func cfCredentialErrorState(err error) string {
+	message := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(message, "locked"):
+		return cfCredentialStateLockedOut
+	case strings.Contains(message, "password has expired"):
+		return cfCredentialStateExpired
+	default:
+		return cfCredentialStateFailing
+	}
+}
@@ -0,0 +1,112 @@
+package cf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault-plugin-auth-cf/testing/cf"
+	"github.com/hashicorp/vault-plugin-auth-cf/util"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestCheckIdentityCAExpiry(t *testing.T) {
+	buf := &bytes.Buffer{}
+	b := newTestBackendWithConfig(t, hclog.New(&hclog.LoggerOptions{Output: buf, Level: hclog.Info}), &logical.StaticSystemView{})
+	realCA, err := ioutil.ReadFile("testdata/real-certificates/ca.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeCA, err := ioutil.ReadFile("testdata/fake-certificates/ca.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := util.ParseCertificates(string(realCA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiry := certs[0].NotAfter
+
+	for _, testCase := range []struct {
+		name     string
+		caCerts  []string
+		now      time.Time
+		expected string
+	}{
+		{"far from expiry", []string{string(realCA)}, expiry.Add(-365 * 24 * time.Hour), ""},
+		{"near expiry", []string{string(realCA)}, expiry.Add(-10 * 24 * time.Hour), "[WARN]"},
+		{"expired", []string{string(realCA)}, expiry.Add(time.Hour), "[ERROR]"},
+		{"rotated", []string{string(realCA), string(fakeCA)}, expiry.Add(-10 * 24 * time.Hour), ""},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			buf.Reset()
+			b.checkIdentityCAExpiry(&models.Configuration{IdentityCACertificates: testCase.caCerts}, testCase.now)
+			if testCase.expected == "" && buf.Len() > 0 {
+				t.Fatalf("expected nothing to be logged but received %q", buf.String())
+			}
+			if !strings.Contains(buf.String(), testCase.expected) {
+				t.Fatalf("expected %s to be logged but received %q", testCase.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestCheckCFCredential(t *testing.T) {
+	b := newTestBackend(t)
+	ctx, storage := b.ctx, b.storage
+	cfServer := cf.NewServer(cf.DefaultFoundation())
+	defer cfServer.Close()
+	config := &models.Configuration{
+		CFAPIAddr:  cfServer.URL,
+		CFUsername: cf.AuthUsername,
+		CFPassword: cf.AuthPassword,
+	}
+	entry, err := logical.StorageEntryJSON(configStorageKey, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+	check := func(operation logical.Operation) map[string]interface{} {
+		return b.mustHandle(operation, "health/cf_credential", nil).Data
+	}
+
+	if status := check(logical.ReadOperation); status["state"] != cfCredentialStateUnknown {
+		t.Fatalf("expected the state to be unknown before the first check but received %v", status["state"])
+	}
+	if status := check(logical.UpdateOperation); status["state"] != cfCredentialStateOK || status["refresh_token_expires_at"] != "2019-06-19T17:58:09Z" {
+		t.Fatalf("expected the credentials to work but received %v", status)
+	}
+
+	for i, testCase := range []struct {
+		credentialError string
+		expectedState   string
+	}{
+		{"Bad credentials", cfCredentialStateFailing},
+		{"Your current password has expired. Please reset your password.", cfCredentialStateExpired},
+		{"Your account has been locked because of too many failed attempts to login.", cfCredentialStateLockedOut},
+	} {
+		cfServer.Update(func(foundation *cf.Foundation) {
+			foundation.CredentialError = testCase.credentialError
+		})
+		status := check(logical.UpdateOperation)
+		if status["state"] != testCase.expectedState || status["consecutive_failures"] != i+1 {
+			t.Fatalf("expected %s after %d failures but received %v", testCase.expectedState, i+1, status)
+		}
+		if !strings.Contains(status["error"].(string), testCase.credentialError) {
+			t.Fatalf("expected UAA's error but received %q", status["error"])
+		}
+	}
+
+	cfServer.Update(func(foundation *cf.Foundation) {
+		foundation.CredentialError = ""
+	})
+	if status := check(logical.UpdateOperation); status["state"] != cfCredentialStateOK || status["consecutive_failures"] != 0 || status["error"] != "" {
+		t.Fatalf("expected the credentials to work again but received %v", status)
+	}
+}
@@ -0,0 +1,194 @@
+package cf
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// These are the categories a failed login may fall into. They're returned to callers
+// so they can tell why their login failed without needing access to Vault's logs.
+const (
+	failureCategoryInvalidRequest       = "invalid_request"
+	failureCategoryExpiredSigningTime   = "expired_signing_time"
+	failureCategoryBadSignature         = "bad_signature"
+	failureCategoryUntrustedCertificate = "untrusted_certificate"
+	failureCategoryRoleConstraint       = "role_constraint"
+	failureCategoryRevoked              = "revoked"
+	failureCategoryCFAPIError           = "cf_api_error"
+	failureCategoryRateLimited          = "rate_limited"
+	failureCategoryQuotaExceeded        = "quota_exceeded"
+	failureCategoryOrgSuspended         = "org_suspended"
+)
+
+// These are the values accepted for "login_error_detail".
+const (
+	loginErrorDetailNone     = "none"
+	loginErrorDetailCategory = "category"
+	loginErrorDetailFull     = "full"
+)
+
+// loginFailure is an error that's the caller's fault, or the fault of the instance they're
+// logging in as, rather than Vault's.
+type loginFailure struct {
+	category string
+	err      error
+
+	// stage is the name of the login check that failed, if it's known.
+	stage string
+
+	// appID is the app the failure is attributed to, which is only set once the
+	// presented certificate has been verified.
+	appID string
+}
+
+func (f *loginFailure) Error() string {
+	return f.err.Error()
+}
+
+func newLoginFailure(category string, err error) *loginFailure {
+	return &loginFailure{
+		category: category,
+		err:      err,
+	}
+}
+
+// attributeToApp attributes the given error to the given app if it's a *loginFailure.
+func attributeToApp(err error, appID string) error {
+	if failure, ok := err.(*loginFailure); ok {
+		failure.appID = appID
+	}
+	return err
+}
+
+// loginFailureResponse logs and records the given failure under a new failure ID, and returns an error
+// response including as much detail as the config allows. The failure ID is always returned so operators
+// can find the full error in the logs or through the diagnostics endpoint.
+func (b *backend) loginFailureResponse(req *logical.Request, config *models.Configuration, failure *loginFailure) (*logical.Response, error) {
+	failureID, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	record := &failureRecord{
+		ID:        failureID,
+		Time:      time.Now().UTC(),
+		Category:  failure.category,
+		Stage:     failure.stage,
+		Error:     failure.err.Error(),
+		AppID:     failure.appID,
+		RequestID: req.ID,
+	}
+	if roleName, ok := req.Data["role"].(string); ok {
+		record.Role = roleName
+	}
+	if req.Connection != nil {
+		record.RemoteAddr = req.Connection.RemoteAddr
+	}
+	b.Logger().Info("login failed", record.logFields()...)
+	b.failures.add(record)
+
+	// Vault only treats responses as errors when "error" is their only data, so the category
+	// and failure ID are carried in the message in a consistent format callers can parse.
+	switch loginErrorDetail(config) {
+	case loginErrorDetailNone:
+		return logical.ErrorResponse(fmt.Sprintf("login failed (failure ID: %s)", failureID)), nil
+	case loginErrorDetailCategory:
+		return logical.ErrorResponse(fmt.Sprintf("login failed: %s (failure ID: %s)", failure.category, failureID)), nil
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("login failed: %s: %s (failure ID: %s)", failure.category, failure.err, failureID)), nil
+	}
+}
+
+// loginErrorDetail returns the configured detail for login errors, accounting for configs
+// stored before it was configurable.
+func loginErrorDetail(config *models.Configuration) string {
+	if config == nil || config.LoginErrorDetail == "" {
+		return loginErrorDetailFull
+	}
+	return config.LoginErrorDetail
+}
+
+// maxRecordedFailures is how many of the most recent login failures are kept in memory.
+const maxRecordedFailures = 1000
+
+// failureRecord is what's kept in memory about each failed login.
+type failureRecord struct {
+	ID         string
+	Time       time.Time
+	Category   string
+	Stage      string
+	Error      string
+	Role       string
+	AppID      string
+	RemoteAddr string
+	RequestID  string
+}
+
+// logFields returns the record as key-value pairs for a structured logger, so failures can be
+// searched by their fields. Fields that are unknown are left out.
+func (r *failureRecord) logFields() []interface{} {
+	fields := []interface{}{"failure_id", r.ID, "category", r.Category}
+	for _, field := range [][2]string{
+		{"stage", r.Stage},
+		{"role", r.Role},
+		{"app_id", r.AppID},
+		{"remote_addr", r.RemoteAddr},
+		{"request_id", r.RequestID},
+	} {
+		if field[1] != "" {
+			fields = append(fields, field[0], field[1])
+		}
+	}
+	return append(fields, "error", r.Error)
+}
+
+// failureLog is a fixed-size ring buffer of the most recent login failures.
+type failureLog struct {
+	mu      sync.RWMutex
+	records []*failureRecord
+	next    int
+}
+
+func newFailureLog(size int) *failureLog {
+	return &failureLog{
+		records: make([]*failureRecord, size),
+	}
+}
+
+// add records the given failure, overwriting the oldest one if the log is full.
+func (l *failureLog) add(record *failureRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records[l.next] = record
+	l.next = (l.next + 1) % len(l.records)
+}
+
+// get returns the failure with the given ID, or nil if it's not in the log.
+func (l *failureLog) get(id string) *failureRecord {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, record := range l.records {
+		if record != nil && record.ID == id {
+			return record
+		}
+	}
+	return nil
+}
+
+// prune removes the failures that happened before the cutoff, and returns how many were removed.
+func (l *failureLog) prune(cutoff time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	removed := 0
+	for i, record := range l.records {
+		if record != nil && record.Time.Before(cutoff) {
+			l.records[i] = nil
+			removed++
+		}
+	}
+	return removed
+}
@@ -0,0 +1,92 @@
+package cf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLoginFailureResponse(t *testing.T) {
+	b := newTestBackend(t)
+	failure := newLoginFailure(failureCategoryBadSignature, errors.New("crypto/rsa: verification error"))
+
+	expected := map[string]*regexp.Regexp{
+		"":                       regexp.MustCompile(`^login failed: bad_signature: crypto/rsa: verification error \(failure ID: [0-9a-f-]{36}\)$`),
+		loginErrorDetailFull:     regexp.MustCompile(`^login failed: bad_signature: crypto/rsa: verification error \(failure ID: [0-9a-f-]{36}\)$`),
+		loginErrorDetailCategory: regexp.MustCompile(`^login failed: bad_signature \(failure ID: [0-9a-f-]{36}\)$`),
+		loginErrorDetailNone:     regexp.MustCompile(`^login failed \(failure ID: [0-9a-f-]{36}\)$`),
+	}
+	for detail, pattern := range expected {
+		resp, err := b.loginFailureResponse(&logical.Request{}, &models.Configuration{LoginErrorDetail: detail}, failure)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.IsError() {
+			t.Fatalf("expected an error response for %q but received %#v", detail, resp)
+		}
+		if msg := resp.Error().Error(); !pattern.MatchString(msg) {
+			t.Fatalf("expected %q to match %s for %q", msg, pattern, detail)
+		}
+	}
+}
+
+func TestFailureLog(t *testing.T) {
+	log := newFailureLog(2)
+	log.add(&failureRecord{ID: "first"})
+	log.add(&failureRecord{ID: "second"})
+	if log.get("first") == nil || log.get("second") == nil {
+		t.Fatal("expected both failures to be recorded")
+	}
+	log.add(&failureRecord{ID: "third"})
+	if log.get("first") != nil {
+		t.Fatal("expected the oldest failure to be overwritten")
+	}
+	if log.get("third") == nil {
+		t.Fatal("expected the newest failure to be recorded")
+	}
+}
+
+func TestLoginFailureLogFields(t *testing.T) {
+	buf := &bytes.Buffer{}
+	b := newTestBackendWithConfig(t, hclog.New(&hclog.LoggerOptions{Output: buf, JSONFormat: true}), &logical.StaticSystemView{})
+	failure := attributeToApp(newLoginFailure(failureCategoryRoleConstraint, errors.New("app ID doesn't match")), "2d3e834a-3a25-4591-974c-fa5626d5d0a1").(*loginFailure)
+	(*loginChecks)(nil).fail(checkNameRoleConstraints, failure)
+
+	req := &logical.Request{
+		Data:       map[string]interface{}{"role": "test-role"},
+		Connection: &logical.Connection{RemoteAddr: "10.0.0.1"},
+		ID:         "0f6ab6f8-5f9b-4c1b-9b3a-3c2d8a3c9e71",
+	}
+	if _, err := b.loginFailureResponse(req, &models.Configuration{}, failure); err != nil {
+		t.Fatal(err)
+	}
+
+	var logged map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &logged); err != nil {
+		t.Fatalf("expected one JSON log line but received %q: %s", buf.String(), err)
+	}
+	expected := map[string]string{
+		"@message":    "login failed",
+		"category":    failureCategoryRoleConstraint,
+		"stage":       checkNameRoleConstraints,
+		"role":        "test-role",
+		"app_id":      "2d3e834a-3a25-4591-974c-fa5626d5d0a1",
+		"remote_addr": "10.0.0.1",
+		"request_id":  "0f6ab6f8-5f9b-4c1b-9b3a-3c2d8a3c9e71",
+		"error":       "app ID doesn't match",
+	}
+	for key, value := range expected {
+		if logged[key] != value {
+			t.Errorf("expected %s to be %q but received %v", key, value, logged[key])
+		}
+	}
+	if _, ok := logged["failure_id"].(string); !ok {
+		t.Errorf("expected a failure ID but received %v", logged["failure_id"])
+	}
+}
@@ -0,0 +1,186 @@
+package cf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault-plugin-auth-cf/signatures"
+	"github.com/hashicorp/vault-plugin-auth-cf/testing/certificates"
+	"github.com/hashicorp/vault-plugin-auth-cf/testing/cf"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const loadTestInstanceIP = "10.255.181.105"
+
+// loadTestEnv is a backend configured to log in the default foundation's app, for driving many
+// logins at once.
+type loadTestEnv struct {
+	*testBackend
+	cfServer  *cf.Server
+	testCerts *certificates.TestCertificates
+}
+
+func newLoadTestEnv(tb testing.TB) *loadTestEnv {
+	b := newTestBackendWithConfig(tb, hclog.NewNullLogger(), &logical.StaticSystemView{
+		DefaultLeaseTTLVal: time.Hour,
+		MaxLeaseTTLVal:     time.Hour,
+	})
+	testCerts, err := certificates.Generate(cf.FoundServiceGUID, cf.FoundOrgGUID, cf.FoundSpaceGUID, cf.FoundAppGUID, loadTestInstanceIP)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	env := &loadTestEnv{
+		testBackend: b,
+		cfServer:    cf.NewServer(cf.DefaultFoundation()),
+		testCerts:   testCerts,
+	}
+
+	for path, data := range map[string]map[string]interface{}{
+		"config": {
+			"identity_ca_certificates":     []string{testCerts.CACertificate},
+			"cf_api_addr":                  env.cfServer.URL,
+			"cf_username":                  cf.AuthUsername,
+			"cf_password":                  cf.AuthPassword,
+			"login_max_seconds_not_before": 300,
+		},
+		"roles/test-role": {
+			"bound_application_ids": []string{cf.FoundAppGUID},
+			"policies":              []string{"default"},
+		},
+	} {
+		b.mustHandle(logical.CreateOperation, path, data)
+	}
+	return env
+}
+
+func (e *loadTestEnv) close() {
+	e.cfServer.Close()
+	e.testCerts.Close()
+}
+
+// loginRequest returns a login request signed now, which stays valid for the configured 5 minutes.
+func (e *loadTestEnv) loginRequest(tb testing.TB) *logical.Request {
+	signingTime := time.Now()
+	signature, err := signatures.Sign(e.testCerts.PathToInstanceKey, &signatures.SignatureData{
+		SigningTime:            signingTime,
+		Role:                   "test-role",
+		CFInstanceCertContents: e.testCerts.InstanceCertificate,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login",
+		Storage:   e.storage,
+		Data: map[string]interface{}{
+			"role":             "test-role",
+			"signature":        signature,
+			"signing_time":     signingTime.UTC().Format(signatures.TimeFormat),
+			"cf_instance_cert": e.testCerts.InstanceCertificate,
+		},
+		Connection: &logical.Connection{
+			RemoteAddr: loadTestInstanceIP,
+		},
+	}
+}
+
+// login logs in with a copy of the given request, so that concurrent logins don't share one.
+func (e *loadTestEnv) login(req *logical.Request) error {
+	copied := *req
+	resp, err := e.backend.HandleRequest(e.ctx, &copied)
+	if err != nil {
+		return err
+	}
+	if resp != nil && resp.IsError() {
+		return resp.Error()
+	}
+	return nil
+}
+
+func TestLoginUnderLoad(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping load test in short mode")
+	}
+	env := newLoadTestEnv(t)
+	defer env.close()
+	req := env.loginRequest(t)
+
+	// Every instance of a large app logging in at once through a slow CF API should still succeed.
+	env.cfServer.Update(func(foundation *cf.Foundation) {
+		foundation.Latency = 20 * time.Millisecond
+	})
+	result := cf.Load(100, 20, func(int) error { return env.login(req) })
+	t.Log(result)
+	if result.Failures != 0 {
+		t.Fatalf("expected every login to succeed but received %v", result.Errors)
+	}
+
+	// And when the CF API is failing, every login should fail rather than hang or succeed.
+	env.cfServer.Update(func(foundation *cf.Foundation) {
+		foundation.Latency = 0
+		foundation.ErrorRate = 1
+	})
+	result = cf.Load(20, 20, func(int) error { return env.login(req) })
+	t.Log(result)
+	if result.Failures != result.Logins {
+		t.Fatalf("expected every login to fail but %d succeeded", result.Logins-result.Failures)
+	}
+}
+
+func BenchmarkAttemptLogin(b *testing.B) {
+	env := newLoadTestEnv(b)
+	defer env.close()
+	req := env.loginRequest(b)
+	config, err := config(env.ctx, env.storage)
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := &framework.FieldData{Raw: req.Data, Schema: env.backend.pathLogin().Fields}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := env.backend.attemptLogin(env.ctx, req, data, config, time.Now().UTC(), nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAttemptLoginParallel(b *testing.B) {
+	env := newLoadTestEnv(b)
+	defer env.close()
+	req := env.loginRequest(b)
+	config, err := config(env.ctx, env.storage)
+	if err != nil {
+		b.Fatal(err)
+	}
+	schema := env.backend.pathLogin().Fields
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		data := &framework.FieldData{Raw: req.Data, Schema: schema}
+		for pb.Next() {
+			copied := *req
+			if _, err := env.backend.attemptLogin(env.ctx, &copied, data, config, time.Now().UTC(), nil); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkLogin includes what's done around the checks, such as claiming token quotas.
+func BenchmarkLogin(b *testing.B) {
+	env := newLoadTestEnv(b)
+	defer env.close()
+	req := env.loginRequest(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := env.login(req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
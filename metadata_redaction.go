@@ -0,0 +1,60 @@
+package cf
+
+import (
+	"context"
+	"crypto/sha256"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/helper/salt"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// getSalt returns the salt used to hash token metadata, creating and storing it the first time
+// it's needed. Every node shares the stored salt, so a value is hashed the same way by each of them.
+func (b *backend) getSalt(ctx context.Context, s logical.Storage) (*salt.Salt, error) {
+	b.saltLock.RLock()
+	if b.salt != nil {
+		defer b.saltLock.RUnlock()
+		return b.salt, nil
+	}
+	b.saltLock.RUnlock()
+
+	b.saltLock.Lock()
+	defer b.saltLock.Unlock()
+	if b.salt != nil {
+		return b.salt, nil
+	}
+	created, err := salt.NewSalt(ctx, s, &salt.Config{
+		HashFunc: salt.SHA256Hash,
+		HMAC:     sha256.New,
+		HMACType: "hmac-sha256",
+		Location: salt.DefaultLocation,
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.salt = created
+	return created, nil
+}
+
+// redactMetadata removes the configured keys from a token's metadata, and replaces the values of
+// others with their HMACs, so that audit logs needn't contain them. A hashed value is the same in
+// every token, so tokens from the same instance can still be correlated.
+func (b *backend) redactMetadata(ctx context.Context, s logical.Storage, config *models.Configuration, metadata map[string]string) error {
+	for _, key := range config.RedactedMetadataKeys {
+		delete(metadata, key)
+	}
+	if len(config.HashedMetadataKeys) == 0 {
+		return nil
+	}
+	salt, err := b.getSalt(ctx, s)
+	if err != nil {
+		return err
+	}
+	for _, key := range config.HashedMetadataKeys {
+		if value, ok := metadata[key]; ok {
+			metadata[key] = salt.GetIdentifiedHMAC(value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package cf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/helper/salt"
+)
+
+func TestRedactMetadata(t *testing.T) {
+	b := newTestBackend(t)
+	ctx, storage := b.ctx, b.storage
+	config := &models.Configuration{
+		RedactedMetadataKeys: []string{"instance_index", "ip_address"},
+		HashedMetadataKeys:   []string{"instance_id"},
+	}
+	redact := func() map[string]string {
+		metadata := map[string]string{
+			"app_id":         "app-id",
+			"instance_id":    "instance-id",
+			"instance_index": "0",
+		}
+		if err := b.redactMetadata(ctx, storage, config, metadata); err != nil {
+			t.Fatal(err)
+		}
+		return metadata
+	}
+
+	metadata := redact()
+	if _, ok := metadata["instance_index"]; ok {
+		t.Fatal("expected instance_index to be redacted")
+	}
+	if metadata["app_id"] != "app-id" {
+		t.Fatalf("expected app_id to be left alone but received %q", metadata["app_id"])
+	}
+	if !strings.HasPrefix(metadata["instance_id"], "hmac-sha256:") || strings.Contains(metadata["instance_id"], "instance-id") {
+		t.Fatalf("expected instance_id to be hashed but received %q", metadata["instance_id"])
+	}
+
+	// The salt is stored, so the same value is hashed the same way after it's reloaded.
+	hashed := metadata["instance_id"]
+	b.invalidate(ctx, salt.DefaultLocation)
+	if again := redact()["instance_id"]; again != hashed {
+		t.Fatalf("expected %q to be hashed consistently but received %q", hashed, again)
+	}
+}
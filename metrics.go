@@ -0,0 +1,79 @@
+package cf
+
+import (
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// metricPrefix begins the name of every metric the backend emits. When the plugin runs
+// as part of Vault, the metrics are sent to Vault's configured telemetry sinks.
+const metricPrefix = "cf"
+
+// failureCategoryInternalError labels failed logins that weren't the caller's fault.
+const failureCategoryInternalError = "internal_error"
+
+func recordLoginSuccess(role string) {
+	metrics.IncrCounterWithLabels([]string{metricPrefix, "login", "success"}, 1, []metrics.Label{
+		{Name: "role", Value: role},
+	})
+}
+
+// recordLoginFailure counts a failed login. The role is only used as a label if it's known
+// to exist, so callers can't create a label for every name they try.
+func recordLoginFailure(role, category string) {
+	labels := []metrics.Label{{Name: "category", Value: category}}
+	if role != "" {
+		labels = append(labels, metrics.Label{Name: "role", Value: role})
+	}
+	metrics.IncrCounterWithLabels([]string{metricPrefix, "login", "failure"}, 1, labels)
+}
+
+func recordRenewal(role string, succeeded bool) {
+	outcome := "success"
+	if !succeeded {
+		outcome = "failure"
+	}
+	metrics.IncrCounterWithLabels([]string{metricPrefix, "renew", outcome}, 1, []metrics.Label{
+		{Name: "role", Value: role},
+	})
+}
+
+// recordClientCache counts whether a request reused the shared CF API client or built one.
+func recordClientCache(hit bool) {
+	outcome := "hit"
+	if !hit {
+		outcome = "miss"
+	}
+	metrics.IncrCounter([]string{metricPrefix, "api", "client_cache", outcome}, 1)
+}
+
+// recordCFAPICacheLookup counts whether a record of the given kind was found in the CF API cache.
+func recordCFAPICacheLookup(kind string, hit bool) {
+	outcome := "hit"
+	if !hit {
+		outcome = "miss"
+	}
+	metrics.IncrCounterWithLabels([]string{metricPrefix, "api", "cache", outcome}, 1, []metrics.Label{
+		{Name: "kind", Value: kind},
+	})
+}
+
+// recordCFAPICacheAge samples how long ago a record used from the CF API cache was fetched.
+func recordCFAPICacheAge(kind string, age time.Duration) {
+	metrics.AddSampleWithLabels([]string{metricPrefix, "api", "cache", "age_seconds"}, float32(age.Seconds()), []metrics.Label{
+		{Name: "kind", Value: kind},
+	})
+}
+
+// recordCFAPICacheStaleServed counts a record that was used from the CF API cache after its TTL because
+// the CF API was unavailable, and samples how long ago it was fetched.
+func recordCFAPICacheStaleServed(kind string, age time.Duration) {
+	labels := []metrics.Label{{Name: "kind", Value: kind}}
+	metrics.IncrCounterWithLabels([]string{metricPrefix, "api", "cache", "stale_served"}, 1, labels)
+	metrics.AddSampleWithLabels([]string{metricPrefix, "api", "cache", "stale_age_seconds"}, float32(age.Seconds()), labels)
+}
+
+func recordCFAPICacheEntries(entries int) {
+	metrics.SetGauge([]string{metricPrefix, "api", "cache", "entries"}, float32(entries))
+}
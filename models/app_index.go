@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// AppIndexEntry records an app that has logged in, so that it can later be checked
+// for whether it still exists in CF.
+type AppIndexEntry struct {
+	AppID   string `json:"app_id"`
+	SpaceID string `json:"space_id"`
+	OrgID   string `json:"org_id"`
+
+	// LastLogin is when an instance of the app last logged in.
+	LastLogin time.Time `json:"last_login"`
+
+	// DeletedAt is when the app, or its space or org, was found to have been deleted from CF.
+	// If zero, it hasn't been.
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// Deleted is whether the app has been found to have been deleted from CF.
+func (e *AppIndexEntry) Deleted() bool {
+	return !e.DeletedAt.IsZero()
+}
@@ -5,19 +5,59 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"regexp"
 	"strings"
 )
 
+var (
+	// guidRegex matches the GUIDs CF identifies orgs, spaces, and apps by.
+	guidRegex = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+	// instanceGUIDRegex matches the GUIDs Diego identifies app instances by, which are cut short to
+	// 28 characters, as well as whole GUIDs.
+	instanceGUIDRegex = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-([0-9a-f]{4}|[0-9a-f]{12})$`)
+)
+
 // NewCFCertificateFromx509 converts a x509 certificate to a valid, well-formed CF certificate,
 // erroring if this isn't possible.
 func NewCFCertificateFromx509(certificate *x509.Certificate) (*CFCertificate, error) {
 	if len(certificate.IPAddresses) != 1 {
 		return nil, fmt.Errorf("valid CF certs have one IP address, but this has %s", certificate.IPAddresses)
 	}
+	cfCert, err := parseCFCertificate(certificate)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfCert.validate(); err != nil {
+		return nil, err
+	}
+	return cfCert, nil
+}
+
+// NewServiceInstanceCertificateFromx509 converts a x509 certificate issued to a service instance,
+// rather than to an app instance, to a valid, well-formed CF certificate, erroring if this isn't
+// possible. Such certificates have no app ID, their instance ID is the service instance's GUID,
+// and they may have no IP address.
+func NewServiceInstanceCertificateFromx509(certificate *x509.Certificate) (*CFCertificate, error) {
+	if len(certificate.IPAddresses) > 1 {
+		return nil, fmt.Errorf("valid service instance certs have at most one IP address, but this has %s", certificate.IPAddresses)
+	}
+	cfCert, err := parseCFCertificate(certificate)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfCert.validateServiceInstance(); err != nil {
+		return nil, err
+	}
+	return cfCert, nil
+}
 
+func parseCFCertificate(certificate *x509.Certificate) (*CFCertificate, error) {
 	cfCert := &CFCertificate{
 		InstanceID: certificate.Subject.CommonName,
-		IPAddress:  certificate.IPAddresses[0].String(),
+	}
+	if len(certificate.IPAddresses) > 0 {
+		cfCert.IPAddress = certificate.IPAddresses[0].String()
 	}
 
 	spaces := 0
@@ -49,12 +89,58 @@ func NewCFCertificateFromx509(certificate *x509.Certificate) (*CFCertificate, er
 	if apps > 1 {
 		return nil, fmt.Errorf("expected 1 app but received %d", apps)
 	}
-	if err := cfCert.validate(); err != nil {
-		return nil, err
-	}
 	return cfCert, nil
 }
 
+// CheckStrictIdentityFormat returns an error unless the certificate is structured exactly as the
+// instance identity certificates Diego issues to app instances are, so that other certificates issued
+// by a CA shared with Diego can't be mistaken for them. Their subject's common name is the instance
+// GUID, their subject has one organization, space, and app organizational unit and no others, each
+// holding a GUID, and their only alternative names are one IP address and, optionally, the instance GUID.
+func CheckStrictIdentityFormat(certificate *x509.Certificate) error {
+	if certificate.IsCA {
+		return errors.New("the certificate is a CA certificate, not an instance identity certificate")
+	}
+	if !instanceGUIDRegex.MatchString(certificate.Subject.CommonName) {
+		return fmt.Errorf("the certificate's common name %q isn't an instance GUID", certificate.Subject.CommonName)
+	}
+
+	// Each prefix must be found exactly once.
+	found := map[string]int{"organization:": 0, "space:": 0, "app:": 0}
+	for _, ou := range certificate.Subject.OrganizationalUnit {
+		i := strings.Index(ou, ":")
+		if i < 0 {
+			return fmt.Errorf("the certificate has an unexpected organizational unit %q", ou)
+		}
+		prefix := ou[:i+1]
+		if _, ok := found[prefix]; !ok {
+			return fmt.Errorf("the certificate has an unexpected organizational unit %q", ou)
+		}
+		if !guidRegex.MatchString(ou[i+1:]) {
+			return fmt.Errorf("the certificate's organizational unit %q doesn't hold a GUID", ou)
+		}
+		found[prefix]++
+	}
+	for _, prefix := range []string{"organization:", "space:", "app:"} {
+		if found[prefix] != 1 {
+			return fmt.Errorf("expected the certificate to have 1 %q organizational unit but it has %d", prefix, found[prefix])
+		}
+	}
+
+	if len(certificate.IPAddresses) != 1 {
+		return fmt.Errorf("expected the certificate to have 1 IP address but it has %d", len(certificate.IPAddresses))
+	}
+	for _, dnsName := range certificate.DNSNames {
+		if dnsName != certificate.Subject.CommonName {
+			return fmt.Errorf("the certificate has an unexpected DNS name %q", dnsName)
+		}
+	}
+	if len(certificate.EmailAddresses) > 0 || len(certificate.URIs) > 0 {
+		return errors.New("the certificate has unexpected email or URI alternative names")
+	}
+	return nil
+}
+
 // NewCFCertificateFromx509 converts the given fields to a valid, well-formed CF certificate,
 // erroring if this isn't possible.
 func NewCFCertificate(instanceID, orgID, spaceID, appID, ipAddress string) (*CFCertificate, error) {
@@ -71,6 +157,21 @@ func NewCFCertificate(instanceID, orgID, spaceID, appID, ipAddress string) (*CFC
 	return cfCert, nil
 }
 
+// NewServiceInstanceCertificate converts the given fields to a valid, well-formed certificate issued
+// to a service instance, erroring if this isn't possible. The IP address may be empty.
+func NewServiceInstanceCertificate(instanceID, orgID, spaceID, ipAddress string) (*CFCertificate, error) {
+	cfCert := &CFCertificate{
+		InstanceID: instanceID,
+		OrgID:      orgID,
+		SpaceID:    spaceID,
+		IPAddress:  ipAddress,
+	}
+	if err := cfCert.validateServiceInstance(); err != nil {
+		return nil, err
+	}
+	return cfCert, nil
+}
+
 // CFCertificate isn't intended to be instantiated directly; but rather through one of the New
 // methods, which contain logic validating that the expected fields exist.
 type CFCertificate struct {
@@ -98,3 +199,27 @@ func (c *CFCertificate) validate() error {
 	}
 	return nil
 }
+
+func (c *CFCertificate) validateServiceInstance() error {
+	if c.InstanceID == "" {
+		return errors.New("no instance ID on given certificate")
+	}
+	if c.AppID != "" {
+		return fmt.Errorf("certificate was issued to an instance of app %s, not to a service instance", c.AppID)
+	}
+	if c.OrgID == "" {
+		return errors.New("no org ID on given certificate")
+	}
+	if c.SpaceID == "" {
+		return errors.New("no space ID on given certificate")
+	}
+	if c.IPAddress != "" && net.ParseIP(c.IPAddress) == nil {
+		return fmt.Errorf("%q could not be parsed as a valid IP address", c.IPAddress)
+	}
+	return nil
+}
+
+// IsServiceInstance is whether the certificate was issued to a service instance rather than to an app instance.
+func (c *CFCertificate) IsServiceInstance() bool {
+	return c.AppID == ""
+}
@@ -151,3 +151,44 @@ func TestNewCFCertificate(t *testing.T) {
 		t.Fatalf("expected %s but received %s", "10.255.181.105", cfCert.IPAddress)
 	}
 }
+
+func TestCheckStrictIdentityFormat(t *testing.T) {
+	certBytes, err := ioutil.ReadFile("../testdata/real-certificates/instance.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certBytes)
+	if block == nil {
+		t.Fatal("expected a certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckStrictIdentityFormat(cert); err != nil {
+		t.Fatalf("expected a certificate issued by Diego to be accepted but received %s", err)
+	}
+
+	for name, change := range map[string]func(cert *x509.Certificate){
+		"ca":                       func(cert *x509.Certificate) { cert.IsCA = true },
+		"common name isn't a guid": func(cert *x509.Certificate) { cert.Subject.CommonName = "vault.example.com" },
+		"missing app":              func(cert *x509.Certificate) { cert.Subject.OrganizationalUnit = cert.Subject.OrganizationalUnit[:2] },
+		"org isn't a guid":         func(cert *x509.Certificate) { cert.Subject.OrganizationalUnit[0] = "organization:my-org" },
+		"unexpected unit": func(cert *x509.Certificate) {
+			cert.Subject.OrganizationalUnit = append(cert.Subject.OrganizationalUnit, "platform")
+		},
+		"duplicate space":          func(cert *x509.Certificate) { cert.Subject.OrganizationalUnit[2] = cert.Subject.OrganizationalUnit[1] },
+		"missing ip address":       func(cert *x509.Certificate) { cert.IPAddresses = nil },
+		"dns name isn't the guid":  func(cert *x509.Certificate) { cert.DNSNames = []string{"vault.example.com"} },
+		"unexpected email address": func(cert *x509.Certificate) { cert.EmailAddresses = []string{"ops@example.com"} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			changed := *cert
+			changed.Subject.OrganizationalUnit = append([]string(nil), cert.Subject.OrganizationalUnit...)
+			change(&changed)
+			if err := CheckStrictIdentityFormat(&changed); err == nil {
+				t.Fatal("expected the certificate to be refused")
+			}
+		})
+	}
+}
@@ -54,6 +54,126 @@ type Configuration struct {
 	// This is configurable because in some test environments we found as much as 2 hours of clock drift.
 	LoginMaxSecNotAfter time.Duration `json:"login_max_seconds_not_after"`
 
+	// SigningTimeLayouts are time.Parse layouts accepted for a login's signing time, in addition to
+	// the built-in ones.
+	SigningTimeLayouts []string `json:"signing_time_layouts"`
+
+	// EnableTLSClientCertLogin allows instances to log in by presenting their identity certificate
+	// as a TLS client certificate while connecting to Vault, rather than by signing the login request.
+	EnableTLSClientCertLogin bool `json:"enable_tls_client_cert_login"`
+
+	// XFCCTrustedProxyCIDRs are the addresses of proxies, like the gorouter, that are trusted to
+	// forward the instance identity certificate they receive in the X-Forwarded-Client-Cert header.
+	XFCCTrustedProxyCIDRs []string `json:"xfcc_trusted_proxy_cidrs"`
+
+	// ForwardedForTrustedProxyCIDRs are the addresses of load balancers and proxies that are trusted
+	// to report the caller's address in the X-Forwarded-For header.
+	ForwardedForTrustedProxyCIDRs []string `json:"forwarded_for_trusted_proxy_cidrs"`
+
+	// AliasNameSource is which of the instance's attributes is used as the name of its entity alias.
+	// If empty, the app ID is used.
+	AliasNameSource string `json:"alias_name_source"`
+
+	// GroupAliasSource is whether group aliases for the instance's org and space are returned, and
+	// whether they're named by GUID or by name. If empty, none are returned.
+	GroupAliasSource string `json:"group_alias_source"`
+
+	// RedactedMetadataKeys are left out of the metadata of the tokens issued at login, and the values of
+	// HashedMetadataKeys are replaced with their HMACs, so that audit logs needn't contain them.
+	RedactedMetadataKeys []string `json:"redacted_metadata_keys"`
+	HashedMetadataKeys   []string `json:"hashed_metadata_keys"`
+
+	// LoginErrorDetail is how much detail is returned to callers whose login fails.
+	// If empty, the full error is returned.
+	LoginErrorDetail string `json:"login_error_detail"`
+
+	// LoginFailureLimit is how many failed logins a source may have within the LoginFailureWindow
+	// before it's locked out. If zero, failed logins aren't limited.
+	LoginFailureLimit int `json:"login_failure_limit"`
+
+	// LoginFailureWindow is the period over which failed logins are counted.
+	LoginFailureWindow time.Duration `json:"login_failure_window"`
+
+	// LoginLockoutDuration is how long a source is locked out after reaching the LoginFailureLimit.
+	LoginLockoutDuration time.Duration `json:"login_lockout_duration"`
+
+	// AppReconciliationInterval is how often the apps that have logged in are checked for whether they
+	// still exist in CF. If zero, they aren't checked.
+	AppReconciliationInterval time.Duration `json:"app_reconciliation_interval"`
+
+	// TidyInterval is how often the tidy operation is run automatically. If zero, it isn't.
+	TidyInterval time.Duration `json:"tidy_interval"`
+
+	// MinimumSignatureVersion is the oldest version of the login signature format that's accepted.
+	// If zero, version 1 signatures are accepted.
+	MinimumSignatureVersion int `json:"minimum_signature_version"`
+
+	// MinimumRSAKeyBits is the smallest RSA key, in bits, that instance certificates may have.
+	// If zero, RSA keys of any size are accepted.
+	MinimumRSAKeyBits int `json:"minimum_rsa_key_bits"`
+
+	// MinimumECDSAKeyBits is the size, in bits, of the smallest elliptic curve that instance
+	// certificates' ECDSA keys may be on. If zero, keys on any curve are accepted.
+	MinimumECDSAKeyBits int `json:"minimum_ecdsa_key_bits"`
+
+	// MaxCertValidityPeriod is the longest that instance certificates may be valid for, from their
+	// NotBefore to their NotAfter. If zero, certificates valid for any period are accepted.
+	MaxCertValidityPeriod time.Duration `json:"max_cert_validity_period"`
+
+	// Audience is what login signatures are bound to. If empty, the mount's accessor is used.
+	Audience string `json:"audience"`
+
+	// RequireAudience refuses login signatures that aren't bound to the Audience.
+	RequireAudience bool `json:"require_audience"`
+
+	// CFAPIMaxConcurrentRequests is how many requests may be made to the CF API at once. If zero,
+	// requests aren't limited.
+	CFAPIMaxConcurrentRequests int `json:"cf_api_max_concurrent_requests"`
+
+	// CFAPIQueueTimeout is how long requests beyond CFAPIMaxConcurrentRequests wait to be made
+	// before failing. If zero, they wait 10 seconds.
+	CFAPIQueueTimeout time.Duration `json:"cf_api_queue_timeout"`
+
+	// CFAPICacheTTL is how long the apps, service instances, orgs, and spaces fetched from the CF API
+	// while checking logins and renewals are used before they're fetched again. If zero, they aren't cached.
+	CFAPICacheTTL time.Duration `json:"cf_api_cache_ttl"`
+
+	// CFAPIMaxStaleness is how long the records fetched from the CF API may still be used while the CF API
+	// is unavailable. If zero, they aren't.
+	CFAPIMaxStaleness time.Duration `json:"cf_api_max_staleness"`
+
+	// CFAPIMaxIdleConnections, CFAPIIdleConnectionTimeout, and CFAPITLSHandshakeTimeout tune the
+	// transport to the CF API. If zero, the defaults in the util package are used.
+	CFAPIMaxIdleConnections    int           `json:"cf_api_max_idle_connections"`
+	CFAPIIdleConnectionTimeout time.Duration `json:"cf_api_idle_connection_timeout"`
+	CFAPITLSHandshakeTimeout   time.Duration `json:"cf_api_tls_handshake_timeout"`
+
+	// Revision is incremented by every write of the config, so that writers can check it hasn't
+	// been changed since they read it. Unlike Version, it has nothing to do with the storage layout.
+	Revision int `json:"revision"`
+
+	// IdentityCAExpiryWarning is how long before the identity CA certificates expire that warnings
+	// about it begin. If zero, warnings begin 30 days beforehand.
+	IdentityCAExpiryWarning time.Duration `json:"identity_ca_expiry_warning"`
+
+	// EnableRoleSelection lets logins omit the role, in which case the most specific role
+	// whose constraints the certificate meets is used.
+	EnableRoleSelection bool `json:"enable_role_selection"`
+
+	// DefaultRole is the role used by logins that omit the role.
+	DefaultRole string `json:"default_role"`
+
+	// AllowSuspendedOrgs lets apps and service instances in suspended orgs log in and renew their tokens.
+	AllowSuspendedOrgs bool `json:"allow_suspended_orgs"`
+
+	// StrictIdentityFormat refuses logins with certificates that aren't structured exactly as Diego's
+	// instance identity certificates are.
+	StrictIdentityFormat bool `json:"strict_identity_format"`
+
+	// RequireBoundConstraints refuses to write or use roles that aren't bound to any app, space, org,
+	// or instance ID.
+	RequireBoundConstraints bool `json:"require_bound_constraints"`
+
 	// Deprecated: use CFAPICertificates instead.
 	PCFAPICertificates []string `json:"pcf_api_trusted_certificates"`
 
@@ -0,0 +1,7 @@
+package models
+
+// PolicyMapping holds the policies added to the tokens of every instance in an org or space,
+// on top of those of the role it logs in with.
+type PolicyMapping struct {
+	Policies []string `json:"policies"`
+}
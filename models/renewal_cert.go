@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// RenewalCertEntry records a current instance identity certificate presented with an existing
+// token, so that the token can be renewed past the expiry of the certificate used to log in.
+type RenewalCertEntry struct {
+	// Role, InstanceID, IPAddress, and AppID describe the login the certificate passed. They must
+	// match the token's for the certificate to be used when renewing it. AppID is empty for service
+	// instances.
+	Role       string `json:"role"`
+	InstanceID string `json:"instance_id"`
+	IPAddress  string `json:"ip_address"`
+	AppID      string `json:"app_id"`
+
+	// CertNotAfter is when the presented certificate expires.
+	CertNotAfter time.Time `json:"cert_not_after"`
+}
@@ -11,11 +11,49 @@ import (
 type RoleEntry struct {
 	tokenutil.TokenParams
 
-	BoundAppIDs       []string `json:"bound_application_ids"`
-	BoundSpaceIDs     []string `json:"bound_space_ids"`
-	BoundOrgIDs       []string `json:"bound_organization_ids"`
-	BoundInstanceIDs  []string `json:"bound_instance_ids"`
-	DisableIPMatching bool     `json:"disable_ip_matching"`
+	BoundAppIDs                   []string `json:"bound_application_ids"`
+	BoundSpaceIDs                 []string `json:"bound_space_ids"`
+	BoundOrgIDs                   []string `json:"bound_organization_ids"`
+	BoundInstanceIDs              []string `json:"bound_instance_ids"`
+	DisableIPMatching             bool     `json:"disable_ip_matching"`
+	DisableCFAPIRenewalCheck      bool     `json:"disable_cf_api_renewal_check"`
+	DisableCertExpiryRenewalCheck bool     `json:"disable_cert_expiry_renewal_check"`
+	AllowServiceInstanceLogin     bool     `json:"allow_service_instance_login"`
+	BoundServiceInstanceNames     []string `json:"bound_service_instance_names"`
+	BoundBuildpacks               []string `json:"bound_buildpacks"`
+	BoundStacks                   []string `json:"bound_stacks"`
+	BoundIsolationSegments        []string `json:"bound_isolation_segments"`
+	RequireInstanceIPMatch        bool     `json:"require_instance_ip_match"`
+
+	// IPMatchingSource is what the certificate's IP address is matched against: the caller's address,
+	// or the addresses of the app's running instances according to the CF API. If empty, it's the
+	// caller's address.
+	IPMatchingSource string `json:"ip_matching_source"`
+
+	// ResponseWrapTTL is the TTL of the wrapping token that login responses are wrapped in.
+	// If zero, responses are only wrapped if the caller asks for it.
+	ResponseWrapTTL time.Duration `json:"response_wrap_ttl"`
+
+	// InstanceBoundCIDRPrefixLength is the length of the network prefix of the instance's IP
+	// address that tokens are bound to when the role has no token_bound_cidrs. If zero, they
+	// aren't bound to it.
+	InstanceBoundCIDRPrefixLength int `json:"instance_bound_cidr_prefix_length"`
+
+	// MetadataAnnotationKeys and MetadataLabelKeys are the keys of the app or service instance's CF
+	// annotations and labels that are copied into the token's metadata at login.
+	MetadataAnnotationKeys []string `json:"metadata_annotation_keys"`
+	MetadataLabelKeys      []string `json:"metadata_label_keys"`
+
+	// TokenQuota is the most tokens that may be live at once for each app or instance, as the
+	// TokenQuotaScope says, and TokenQuotaAction is what's done with logins beyond it. If the quota
+	// is zero, tokens aren't limited.
+	TokenQuota       int    `json:"token_quota"`
+	TokenQuotaScope  string `json:"token_quota_scope"`
+	TokenQuotaAction string `json:"token_quota_action"`
+
+	// Revision is incremented by every write of the role, so that writers can check it hasn't
+	// been changed since they read it.
+	Revision int `json:"revision"`
 
 	// Deprecated by TokenParams
 	TTL        time.Duration                 `json:"ttl"`
@@ -24,3 +62,9 @@ type RoleEntry struct {
 	Policies   []string                      `json:"policies"`
 	BoundCIDRs []*sockaddr.SockAddrMarshaler `json:"bound_cidrs"`
 }
+
+// HasBoundConstraints is whether the role is bound to any app, space, org, or instance ID, rather
+// than allowing any certificate the CA signed.
+func (r *RoleEntry) HasBoundConstraints() bool {
+	return len(r.BoundAppIDs) > 0 || len(r.BoundSpaceIDs) > 0 || len(r.BoundOrgIDs) > 0 || len(r.BoundInstanceIDs) > 0
+}
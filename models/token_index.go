@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// TokenIndexEntry records the tokens issued to an app that have been seen by the backend, so
+// that operators can find them and cut the app off.
+type TokenIndexEntry struct {
+	AppID   string `json:"app_id"`
+	SpaceID string `json:"space_id"`
+
+	// Accessors maps the accessor of each of the app's renewed tokens to when it was last
+	// renewed, to within the interval at which renewals are recorded.
+	Accessors map[string]time.Time `json:"accessors"`
+
+	// RevokedAt is when an operator cut the app off, after which its logins and renewals are
+	// refused. If zero, it hasn't been.
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// Revoked is whether an operator has cut the app off.
+func (e *TokenIndexEntry) Revoked() bool {
+	return !e.RevokedAt.IsZero()
+}
+
+// TokenLoginEntry records a token issued to an app that hasn't yet been renewed. Vault only
+// reveals a token's accessor to the backend when it's renewed, so until then it's only known by
+// the ID given to it at login. Each is stored separately, so that logins needn't rewrite the
+// records of every other token.
+type TokenLoginEntry struct {
+	IssuedAt time.Time `json:"issued_at"`
+}
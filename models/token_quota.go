@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// TokenQuotaEntry records the live tokens issued through a role to an app or instance, so that
+// their number can be limited.
+type TokenQuotaEntry struct {
+	// Tokens maps the ID given to each token at login to when it expires unless it's renewed.
+	Tokens map[string]time.Time `json:"tokens"`
+}
+
+// Oldest returns the ID of the token that expires first, or an empty string if there are none.
+func (e *TokenQuotaEntry) Oldest() string {
+	oldest := ""
+	for id, expiresAt := range e.Tokens {
+		if oldest == "" || expiresAt.Before(e.Tokens[oldest]) {
+			oldest = id
+		}
+	}
+	return oldest
+}
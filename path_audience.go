@@ -0,0 +1,65 @@
+package cf
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathAudience() *framework.Path {
+	return &framework.Path{
+		Pattern: "audience",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.operationAudienceRead,
+				Summary:  "Read the audience to bind version 2 login signatures to.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "The audience.",
+						Example: &logical.Response{
+							Data: map[string]interface{}{"audience": "auth_cf_1a2b3c4d"},
+						},
+					}},
+				},
+			},
+		},
+		HelpSynopsis:    pathAudienceSyn,
+		HelpDescription: pathAudienceDesc,
+	}
+}
+
+func (b *backend) operationAudienceRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"audience": loginAudience(config, req),
+		},
+	}, nil
+}
+
+// loginAudience is the audience that login signatures are bound to. Unless one is configured,
+// it's the accessor of the mount, which is unique to each mount on each Vault cluster.
+func loginAudience(config *models.Configuration, req *logical.Request) string {
+	if config != nil && config.Audience != "" {
+		return config.Audience
+	}
+	return req.MountAccessor
+}
+
+const pathAudienceSyn = `
+Read the audience that login signatures may be bound to.
+`
+
+const pathAudienceDesc = `
+Returns the audience to include in version 2 login signatures, and to send as
+the "audience" field when logging in, so that the signature can't be replayed
+against another Vault cluster that trusts the same CA. It's the configured
+audience if there is one, and otherwise the accessor of this mount. This
+endpoint doesn't require authentication.
+`
@@ -0,0 +1,106 @@
+package cf
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathCacheStatus() *framework.Path {
+	return &framework.Path{
+		Pattern: "cache/status",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.operationCacheStatusRead,
+				Summary:  "Read how many CF API records are cached on this node, and how often they've been used.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "The state of the cache.",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"ttl":           300,
+								"enabled":       true,
+								"total_entries": 3,
+								"entries":       map[string]int{cacheKindApp: 1, cacheKindServiceInstance: 0, cacheKindOrg: 1, cacheKindSpace: 1},
+								"hits":          map[string]uint64{cacheKindApp: 42, cacheKindServiceInstance: 0, cacheKindOrg: 42, cacheKindSpace: 42},
+								"misses":        map[string]uint64{cacheKindApp: 3, cacheKindServiceInstance: 0, cacheKindOrg: 3, cacheKindSpace: 3},
+								"hit_rate":      0.933,
+								"max_staleness": 3600,
+								"stale_served":  map[string]uint64{cacheKindApp: 0, cacheKindServiceInstance: 0, cacheKindOrg: 0, cacheKindSpace: 0},
+								"age_seconds":   map[string]int64{"p50": 120, "p90": 250, "max": 280},
+							},
+						},
+					}},
+				},
+			},
+		},
+		HelpSynopsis:    pathCacheSyn,
+		HelpDescription: pathCacheDesc,
+	}
+}
+
+func (b *backend) pathCacheEntries() *framework.Path {
+	return &framework.Path{
+		Pattern: "cache/(?P<kind>" + strings.Join(cacheKinds, "|") + ")/" + framework.GenericNameRegex("guid"),
+		Fields: map[string]*framework.FieldSchema{
+			"kind": {
+				Type:        framework.TypeString,
+				Required:    true,
+				Description: `The kind of record: "apps", "service_instances", "orgs", or "spaces".`,
+			},
+			"guid": {
+				Type:        framework.TypeString,
+				Required:    true,
+				Description: "The GUID of the record.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.operationCacheEntryDelete,
+				Summary:  "Remove a record from this node's cache, so it's fetched from the CF API again.",
+			},
+		},
+		HelpSynopsis:    pathCacheSyn,
+		HelpDescription: pathCacheDesc,
+	}
+}
+
+func (b *backend) operationCacheStatusRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	config, err := config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	var ttl, maxStaleness time.Duration
+	if config != nil {
+		ttl = config.CFAPICacheTTL
+		maxStaleness = config.CFAPIMaxStaleness
+	}
+	return &logical.Response{
+		Data: b.cfAPICache.status(ttl, maxStaleness, time.Now()),
+	}, nil
+}
+
+func (b *backend) operationCacheEntryDelete(_ context.Context, _ *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.cfAPICache.invalidate(data.Get("kind").(string), data.Get("guid").(string))
+	return nil, nil
+}
+
+const pathCacheSyn = `
+Inspect and invalidate the cache of records fetched from the CF API.
+`
+
+const pathCacheDesc = `
+When "cf_api_cache_ttl" is configured, the apps, service instances, orgs, and
+spaces fetched from the CF API while checking logins and renewals are reused
+until it passes. Reading "cache/status" returns how many records of each kind
+are cached, how many lookups of each kind were answered from the cache or had
+to be fetched, how many were answered with a stale record because the CF API
+was unavailable, and percentiles of how long ago the cached records were fetched.
+Deleting "cache/<kind>/<guid>" removes one record, so that a change to it is
+noticed at the next login. Each Vault node keeps its own cache, so these only
+describe and affect the node that handles the request.
+`
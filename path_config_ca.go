@@ -0,0 +1,103 @@
+package cf
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/util"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/certutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathConfigCA() *framework.Path {
+	return &framework.Path{
+		Pattern: "config/ca",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.operationConfigCARead,
+				Summary:  "Read the details of the configured identity CA certificates.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "The details of each identity CA certificate, in the order they're configured.",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"certificates": []map[string]interface{}{{
+									"subject":            "CN=instanceIdentityCA",
+									"issuer":             "CN=instanceIdentityCA",
+									"serial_number":      "3b:6e:56:6c:b7:1c:e4:d7:ef:4c:5c:1b:8c:1f:2b:7a",
+									"not_before":         "2019-04-03T14:39:52Z",
+									"not_after":          "2020-04-03T14:39:52Z",
+									"sha256_fingerprint": "9c:5d:4b:64:12:f0:8d:0b:1f:ad:ab:c0:0c:8a:d2:89:1b:d6:03:c0:5e:bb:a1:2b:e8:73:ba:e9:1a:11:1a:b8",
+									"is_ca":              true,
+								}},
+							},
+						},
+					}},
+					http.StatusNoContent: {{
+						Description: "The backend hasn't been configured.",
+					}},
+				},
+			},
+		},
+		HelpSynopsis:    pathConfigCASyn,
+		HelpDescription: pathConfigCADesc,
+	}
+}
+
+func (b *backend) operationConfigCARead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	config, err := config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, nil
+	}
+
+	resp := &logical.Response{}
+	certificates := []map[string]interface{}{}
+	for i, caCert := range config.IdentityCACertificates {
+		certs, err := util.ParseCertificates(caCert)
+		if err != nil {
+			// A certificate that can't be parsed can't be trusted, but the rest still may be.
+			resp.AddWarning(fmt.Sprintf("identity CA certificate %d couldn't be parsed: %s", i, err))
+			continue
+		}
+		for _, cert := range certs {
+			certificates = append(certificates, certificateDetails(cert))
+		}
+	}
+	resp.Data = map[string]interface{}{
+		"certificates": certificates,
+	}
+	return resp, nil
+}
+
+// certificateDetails describes the given certificate in the terms openssl would.
+func certificateDetails(cert *x509.Certificate) map[string]interface{} {
+	fingerprint := sha256.Sum256(cert.Raw)
+	return map[string]interface{}{
+		"subject":            cert.Subject.String(),
+		"issuer":             cert.Issuer.String(),
+		"serial_number":      certutil.GetHexFormatted(cert.SerialNumber.Bytes(), ":"),
+		"not_before":         cert.NotBefore.UTC().Format(time.RFC3339),
+		"not_after":          cert.NotAfter.UTC().Format(time.RFC3339),
+		"sha256_fingerprint": certutil.GetHexFormatted(fingerprint[:], ":"),
+		"is_ca":              cert.IsCA,
+	}
+}
+
+const pathConfigCASyn = `
+Read the details of the configured identity CA certificates.
+`
+
+const pathConfigCADesc = `
+Returns the subject, issuer, serial number, validity period, and SHA-256
+fingerprint of each configured identity CA certificate, which instance identity
+certificates must chain to in order to log in. Certificates configured as a
+bundle are listed individually.
+`
@@ -0,0 +1,39 @@
+package cf
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestConfigCARead(t *testing.T) {
+	b := newTestBackend(t)
+	ctx, storage := b.ctx, b.storage
+	fakeCA, err := ioutil.ReadFile("testdata/fake-certificates/ca.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storeConfig(ctx, storage, &models.Configuration{
+		Version:                1,
+		IdentityCACertificates: []string{string(fakeCA), "not a certificate"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := b.handle(logical.ReadOperation, "config/ca", nil)
+	certificates := resp.Data["certificates"].([]map[string]interface{})
+	if len(certificates) != 1 {
+		t.Fatalf("expected 1 certificate but received %d", len(certificates))
+	}
+	if len(resp.Warnings) != 1 {
+		t.Fatalf("expected a warning about the certificate that couldn't be parsed but received %v", resp.Warnings)
+	}
+	if certificates[0]["not_after"] != "2119-05-21T22:35:30Z" {
+		t.Fatalf("expected the CA to expire at 2119-05-21T22:35:30Z but received %v", certificates[0]["not_after"])
+	}
+	if certificates[0]["is_ca"] != true {
+		t.Fatal("expected the certificate to be a CA")
+	}
+}
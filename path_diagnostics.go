@@ -0,0 +1,62 @@
+package cf
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathDiagnosticsFailures() *framework.Path {
+	return &framework.Path{
+		Pattern: "diagnostics/failures/" + framework.GenericNameRegex("failure_id"),
+		Fields: map[string]*framework.FieldSchema{
+			"failure_id": {
+				Type:        framework.TypeString,
+				Required:    true,
+				Description: "The failure ID returned to the caller whose login failed.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.operationDiagnosticsFailureRead,
+				Summary:  "Look up a failed login by its failure ID.",
+			},
+		},
+		HelpSynopsis:    pathDiagnosticsFailuresSyn,
+		HelpDescription: pathDiagnosticsFailuresDesc,
+	}
+}
+
+func (b *backend) operationDiagnosticsFailureRead(_ context.Context, _ *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	record := b.failures.get(data.Get("failure_id").(string))
+	if record == nil {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"failure_id":  record.ID,
+			"time":        record.Time.Format(time.RFC3339Nano),
+			"category":    record.Category,
+			"stage":       record.Stage,
+			"error":       record.Error,
+			"role":        record.Role,
+			"app_id":      record.AppID,
+			"remote_addr": record.RemoteAddr,
+			"request_id":  record.RequestID,
+		},
+	}, nil
+}
+
+const pathDiagnosticsFailuresSyn = `
+Look up the details of a failed login by its failure ID.
+`
+
+const pathDiagnosticsFailuresDesc = `
+Each failed login is given a failure ID that's returned to the caller. The
+full error for recent failures is kept in memory so operators can look it up
+here without searching Vault's logs. Only failures handled by the Vault node
+serving this request are available, and they're lost when Vault restarts or
+the plugin is reloaded. Reading this path requires sudo.
+`
@@ -0,0 +1,80 @@
+package cf
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathHealthCFCredential() *framework.Path {
+	return &framework.Path{
+		Pattern: "health/cf_credential",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.operationHealthCFCredentialRead,
+				Summary:  "Read what the last check of the CF API credentials on this node found.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "The outcome of the last check.",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"state":                    cfCredentialStateOK,
+								"checked_at":               "2019-05-20T17:58:09Z",
+								"last_success_at":          "2019-05-20T17:58:09Z",
+								"consecutive_failures":     0,
+								"error":                    "",
+								"access_token_expires_at":  "2019-05-20T18:08:08Z",
+								"refresh_token_expires_at": "2019-06-19T17:58:09Z",
+							},
+						},
+					}},
+				},
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.operationHealthCFCredentialUpdate,
+				Summary:  "Check the CF API credentials now, such as after rotating them.",
+			},
+		},
+		HelpSynopsis:    pathHealthCFCredentialSyn,
+		HelpDescription: pathHealthCFCredentialDesc,
+	}
+}
+
+func (b *backend) operationHealthCFCredentialRead(_ context.Context, _ *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	return &logical.Response{
+		Data: b.cfCredential.status(),
+	}, nil
+}
+
+func (b *backend) operationHealthCFCredentialUpdate(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	config, err := config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, errors.New("no configuration is available for reaching the CF API")
+	}
+	b.checkCFCredential(config, time.Now())
+	return &logical.Response{
+		Data: b.cfCredential.status(),
+	}, nil
+}
+
+const pathHealthCFCredentialSyn = `
+Check whether the configured CF API credentials still work.
+`
+
+const pathHealthCFCredentialDesc = `
+Every hour, each Vault node obtains a new token from UAA with the configured
+credentials, so that a changed, expired, or locked-out credential is noticed
+before the shared client's tokens run out and logins begin to fail. Reading
+this path returns what the last check found: a state of "ok", "failing",
+"expired", "locked_out", or "unknown" if it hasn't run yet, how many checks in
+a row have failed, and when the tokens UAA last issued expire. Writing to it
+checks the credentials now. Each node checks for itself, so these only describe
+the node that handles the request.
+`
@@ -2,68 +2,118 @@ package cf
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
 	"net"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/hashicorp/go-sockaddr"
+	"github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault-plugin-auth-cf/models"
 	"github.com/hashicorp/vault-plugin-auth-cf/signatures"
 	"github.com/hashicorp/vault-plugin-auth-cf/util"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/cidrutil"
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
+	"github.com/hashicorp/vault/sdk/helper/policyutil"
 	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/helper/wrapping"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/pkg/errors"
 )
 
+// errNoMatchingRole is the error of logins naming a role that doesn't exist.
+var errNoMatchingRole = errors.New("no matching role")
+
 func (b *backend) pathLogin() *framework.Path {
 	return &framework.Path{
 		Pattern: "login",
-		Fields: map[string]*framework.FieldSchema{
-			"role": {
-				Required: true,
-				Type:     framework.TypeString,
-				DisplayAttrs: &framework.DisplayAttributes{
-					Name:  "Role Name",
-					Value: "internally-defined-role",
+		Fields:  loginFields(),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.operationLoginUpdate,
+				Summary:  "Log in with a CF instance identity certificate.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "The login succeeded, and a token was issued.",
+						Example: &logical.Response{
+							Auth: &logical.Auth{
+								DisplayName: "f9c7cd7d-1612-4f57-63a8-f995-0",
+								Policies:    []string{"default", "foo-policies"},
+								Metadata: map[string]string{
+									"role":           "test-role",
+									"app_id":         "2d3e834a-3a25-4591-974c-fa5626d5d0a1",
+									"app_name":       "testApp",
+									"instance_id":    "f9c7cd7d-1612-4f57-63a8-f995",
+									"org_id":         "34a878d0-c2f9-4521-ba73-a9f664e82c7bf",
+									"org_name":       "system",
+									"space_id":       "3d2eba6b-ef19-44d5-91dd-1975b0db5cc9",
+									"space_name":     "development",
+									"cert_not_after": "2019-04-28T04:30:00Z",
+								},
+							},
+						},
+					}},
+					http.StatusBadRequest: {{
+						Description: `The login failed. The error is of the form "login failed: <category>: <error> (failure ID: <id>)", with as much detail as "login_error_detail" allows.`,
+					}},
 				},
-				Description: "The name of the role to authenticate against.",
 			},
-			"cf_instance_cert": {
-				Required: true,
-				Type:     framework.TypeString,
-				DisplayAttrs: &framework.DisplayAttributes{
-					Name: "CF_INSTANCE_CERT Contents",
-				},
-				Description: "The full body of the file available at the CF_INSTANCE_CERT path on the CF instance.",
+		},
+		HelpSynopsis:    pathLoginSyn,
+		HelpDescription: pathLoginDesc,
+	}
+}
+
+// loginFields are the fields accepted for logging in, and for verifying a login.
+func loginFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"role": {
+			Type: framework.TypeString,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name:  "Role Name",
+				Value: "internally-defined-role",
 			},
-			"signing_time": {
-				Required: true,
-				Type:     framework.TypeString,
-				DisplayAttrs: &framework.DisplayAttributes{
-					Name:  "Signing Time",
-					Value: "2006-01-02T15:04:05Z",
-				},
-				Description: "The date and time used to construct the signature.",
+			Description: `The name of the role to authenticate against. Required unless a default role is configured
+or role selection is enabled, in which case the most specific role whose constraints the certificate meets is used.`,
+		},
+		"cf_instance_cert": {
+			Type: framework.TypeString,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "CF_INSTANCE_CERT Contents",
 			},
-			"signature": {
-				Required: true,
-				Type:     framework.TypeString,
-				DisplayAttrs: &framework.DisplayAttributes{
-					Name: "Signature",
-				},
-				Description: "The signature generated by the client certificate's private key.",
+			Description: "The full body of the file available at the CF_INSTANCE_CERT path on the CF instance. It may contain any number of certificates; the identity certificate is selected as the leaf of the bundle and the rest are treated as intermediates. The certificates may be PEM-encoded or, if there are no PEM blocks, given as base64-encoded DER.",
+		},
+		"signing_time": {
+			Type: framework.TypeString,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name:  "Signing Time",
+				Value: "2006-01-02T15:04:05Z",
 			},
+			Description: `The date and time used to construct the signature. Accepted as ISO 8601/RFC 3339, Unix epoch
+seconds, the output of Bash's "date -u", or the output of PowerShell's "(Get-Date).ToUniversalTime()".`,
 		},
-		Operations: map[logical.Operation]framework.OperationHandler{
-			logical.UpdateOperation: &framework.PathOperation{
-				Callback: b.operationLoginUpdate,
+		"signature": {
+			Type: framework.TypeString,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Signature",
 			},
+			Description: `The signature generated by the client certificate's private key. May be omitted along with
+"cf_instance_cert" and "signing_time" if TLS client certificate login is enabled and the instance identity
+certificate is presented while connecting to Vault.`,
+		},
+		"audience": {
+			Type: framework.TypeString,
+			DisplayAttrs: &framework.DisplayAttributes{
+				Name: "Audience",
+			},
+			Description: `The audience the signature is bound to, as read from the audience endpoint. Only version 2
+signatures may be bound to an audience. Required if the config requires an audience.`,
 		},
-		HelpSynopsis:    pathLoginSyn,
-		HelpDescription: pathLoginDesc,
 	}
 }
 
@@ -76,90 +126,238 @@ func (b *backend) operationLoginUpdate(ctx context.Context, req *logical.Request
 	// Grab the time immediately for checking against the request's signingTime.
 	timeReceived := time.Now().UTC()
 
-	roleName := data.Get("role").(string)
-	if roleName == "" {
-		return logical.ErrorResponse("'role-name' is required"), nil
-	}
-
-	// Ensure the cf certificate meets the role's constraints.
-	role, err := getRole(ctx, req.Storage, roleName)
+	config, err := config(ctx, req.Storage)
 	if err != nil {
 		return nil, err
 	}
-	if role == nil {
-		return nil, errors.New("no matching role")
+	if config == nil {
+		return nil, errors.New("no CA is configured for verifying client certificates")
 	}
 
-	if len(role.TokenBoundCIDRs) > 0 {
-		if req.Connection == nil {
-			b.Logger().Warn("token bound CIDRs found but no connection information available for validation")
-			return nil, logical.ErrPermissionDenied
-		}
-		if !cidrutil.RemoteAddrIsOk(req.Connection.RemoteAddr, role.TokenBoundCIDRs) {
-			return nil, logical.ErrPermissionDenied
+	ipSource := "ip:" + clientAddr(config, req)
+	if config.LoginFailureLimit > 0 {
+		if lockedUntil := b.limiter.lockedUntil(ipSource, timeReceived); !lockedUntil.IsZero() {
+			// The role hasn't been looked up yet, so it isn't used as a label.
+			recordLoginFailure("", failureCategoryRateLimited)
+			return b.loginFailureResponse(req, config, newLoginFailure(failureCategoryRateLimited, fmt.Errorf("too many failed logins from this address; try again after %s", lockedUntil.Format(time.RFC3339))))
 		}
 	}
 
-	signature := data.Get("signature").(string)
-	if signature == "" {
-		return logical.ErrorResponse("'signature' is required"), nil
-	}
-
-	cfInstanceCertContents := data.Get("cf_instance_cert").(string)
-	if cfInstanceCertContents == "" {
-		return logical.ErrorResponse("'cf_instance_cert' is required"), nil
+	roleName := loginRoleName(config, data)
+	auth, err := b.attemptLogin(ctx, req, data, config, timeReceived, nil)
+	if err != nil {
+		if failure, ok := err.(*loginFailure); ok {
+			// Only named roles are used as labels, since a selected role may not have been found. Names
+			// of roles that don't exist aren't either, since callers could send any number of them.
+			if failure.err == errNoMatchingRole {
+				roleName = ""
+			}
+			recordLoginFailure(roleName, failure.category)
+			if config.LoginFailureLimit > 0 && failure.category != failureCategoryRateLimited {
+				b.limiter.recordFailure(ipSource, timeReceived, config.LoginFailureLimit, config.LoginFailureWindow, config.LoginLockoutDuration)
+				if failure.appID != "" {
+					b.limiter.recordFailure("app:"+failure.appID, timeReceived, config.LoginFailureLimit, config.LoginFailureWindow, config.LoginLockoutDuration)
+				}
+			}
+			return b.loginFailureResponse(req, config, failure)
+		}
+		recordLoginFailure("", failureCategoryInternalError)
+		return nil, err
 	}
 
-	signingTimeRaw := data.Get("signing_time").(string)
-	if signingTimeRaw == "" {
-		return logical.ErrorResponse("'signing_time' is required"), nil
+	if appID, ok := auth.Alias.Metadata["app_id"]; ok && config.AppReconciliationInterval > 0 {
+		// Failing to index the app only delays noticing that it's been deleted, so it shouldn't fail the login.
+		if err := b.indexAppLogin(ctx, req.Storage, appID, auth.Alias.Metadata["space_id"], auth.Alias.Metadata["org_id"], timeReceived); err != nil {
+			b.Logger().Warn(fmt.Sprintf("unable to index app %s for reconciliation: %s", appID, err))
+		}
 	}
-	signingTime, err := parseTime(signingTimeRaw)
+	// The role may have been selected rather than named.
+	roleName = auth.InternalData["role"].(string)
+	role, err := getRole(ctx, req.Storage, roleName)
 	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+		return nil, err
 	}
 
-	config, err := config(ctx, req.Storage)
-	if err != nil {
-		return nil, err
+	// The quota is only claimed once the login is certain to succeed, so failed logins don't use it up.
+	if role != nil && role.TokenQuota > 0 {
+		appID := auth.Alias.Metadata["app_id"]
+		key := tokenQuotaKey(roleName, role, appID, auth.InternalData["instance_id"].(string))
+		quotaID, err := b.claimTokenQuota(ctx, req.Storage, key, role, timeReceived)
+		if failure, ok := err.(*loginFailure); ok {
+			failure.appID = appID
+			recordLoginFailure(roleName, failure.category)
+			return b.loginFailureResponse(req, config, failure)
+		}
+		if err != nil {
+			return nil, err
+		}
+		auth.InternalData["token_quota_key"] = key
+		auth.InternalData["token_quota_id"] = quotaID
 	}
-	if config == nil {
-		return nil, errors.New("no CA is configured for verifying client certificates")
+
+	// The token's accessor isn't known until it's renewed, so it's indexed by an ID of its own until then.
+	if appID, ok := auth.Alias.Metadata["app_id"]; ok {
+		loginID, err := uuid.GenerateUUID()
+		if err != nil {
+			return nil, err
+		}
+		auth.InternalData["token_index_id"] = loginID
+		// Failing to index the token only keeps it from being listed, so it shouldn't fail the login.
+		if err := b.indexTokenLogin(ctx, req.Storage, appID, auth.Alias.Metadata["space_id"], loginID, timeReceived); err != nil {
+			b.Logger().Warn(fmt.Sprintf("unable to index a token of app %s: %s", appID, err))
+		}
 	}
 
-	// Ensure the time it was signed isn't too far in the past or future.
-	oldestAllowableSigningTime := timeReceived.Add(-1 * config.LoginMaxSecNotBefore)
-	furthestFutureAllowableSigningTime := timeReceived.Add(config.LoginMaxSecNotAfter)
-	if signingTime.Before(oldestAllowableSigningTime) {
-		return logical.ErrorResponse(fmt.Sprintf("request is too old; signed at %s but received request at %s; allowable seconds old is %d", signingTime, timeReceived, config.LoginMaxSecNotBefore/time.Second)), nil
+	recordLoginSuccess(roleName)
+	resp := &logical.Response{
+		Auth: auth,
 	}
-	if signingTime.After(furthestFutureAllowableSigningTime) {
-		return logical.ErrorResponse(fmt.Sprintf("request is too far in the future; signed at %s but received request at %s; allowable seconds in the future is %d", signingTime, timeReceived, config.LoginMaxSecNotAfter/time.Second)), nil
+
+	// Roles for sensitive workloads may require that their tokens are only delivered wrapped.
+	if role != nil && role.ResponseWrapTTL > 0 {
+		resp.WrapInfo = &wrapping.ResponseWrapInfo{
+			TTL: role.ResponseWrapTTL,
+		}
 	}
+	return resp, nil
+}
 
-	intermediateCert, identityCert, err := util.ExtractCertificates(cfInstanceCertContents)
-	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+// attemptLogin runs every check a login must pass, and returns the resulting auth if they all do.
+// Errors that are the caller's fault are returned as a *loginFailure. If checks is non-nil, the
+// outcome of each check is recorded in it.
+func (b *backend) attemptLogin(ctx context.Context, req *logical.Request, data *framework.FieldData, config *models.Configuration, timeReceived time.Time, checks *loginChecks) (*logical.Auth, error) {
+	// The role is signed as it was sent, even if it's left empty for the default role to be used
+	// or for the role to be selected.
+	signedRoleName := data.Get("role").(string)
+	roleName := loginRoleName(config, data)
+
+	// A named role is looked up right away, so that logins it can't allow fail early. A role
+	// can only be selected once the certificate has been verified.
+	var role *models.RoleEntry
+	var err error
+	if roleName != "" {
+		role, err = getRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			return nil, checks.fail(checkNameRequest, newLoginFailure(failureCategoryInvalidRequest, errNoMatchingRole))
+		}
+		if err := b.checkTokenBoundCIDRs(config, req, role, checks); err != nil {
+			return nil, err
+		}
+	} else if !config.EnableRoleSelection {
+		return nil, checks.fail(checkNameRequest, newLoginFailure(failureCategoryInvalidRequest, errors.New("'role-name' is required")))
 	}
 
-	// Ensure the private key used to create the signature matches our identity
-	// certificate, and that it signed the same data as is presented in the body.
-	// This offers some protection against MITM attacks.
-	signingCert, err := signatures.Verify(signature, &signatures.SignatureData{
-		SigningTime:            signingTime,
-		Role:                   roleName,
-		CFInstanceCertContents: cfInstanceCertContents,
-	})
-	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+	signature := data.Get("signature").(string)
+	cfInstanceCertContents := data.Get("cf_instance_cert").(string)
+
+	var signingCert *x509.Certificate
+	if signature == "" && cfInstanceCertContents == "" && clientCertLoginEnabled(config) {
+		checks.pass(checkNameRequest)
+		// The caller didn't sign anything, so the only remaining way they can prove who they are
+		// is by having presented their instance identity certificate while connecting to Vault,
+		// either directly or through a trusted proxy.
+		checks.skip(checkNameSigningTime)
+		checks.skip(checkNameSignature)
+		signingCert, err = b.verifyClientCert(config, req)
+		if err != nil {
+			return nil, checks.fail(checkNameCertificateChain, err)
+		}
+	} else {
+		if signature == "" {
+			return nil, checks.fail(checkNameRequest, newLoginFailure(failureCategoryInvalidRequest, errors.New("'signature' is required")))
+		}
+		if cfInstanceCertContents == "" {
+			return nil, checks.fail(checkNameRequest, newLoginFailure(failureCategoryInvalidRequest, errors.New("'cf_instance_cert' is required")))
+		}
+
+		signingTimeRaw := data.Get("signing_time").(string)
+		if signingTimeRaw == "" {
+			return nil, checks.fail(checkNameRequest, newLoginFailure(failureCategoryInvalidRequest, errors.New("'signing_time' is required")))
+		}
+		signingTime, err := signatures.ParseSigningTime(signingTimeRaw, config.SigningTimeLayouts...)
+		if err != nil {
+			return nil, checks.fail(checkNameRequest, newLoginFailure(failureCategoryInvalidRequest, err))
+		}
+
+		// A signature bound to an audience must be bound to this one, or it was made for a different Vault.
+		audience := data.Get("audience").(string)
+		if audience == "" && config.RequireAudience {
+			return nil, checks.fail(checkNameRequest, newLoginFailure(failureCategoryInvalidRequest, errors.New("'audience' is required")))
+		}
+		if audience != "" && audience != loginAudience(config, req) {
+			return nil, checks.fail(checkNameRequest, newLoginFailure(failureCategoryInvalidRequest, fmt.Errorf("audience %q doesn't match this Vault's audience %q", audience, loginAudience(config, req))))
+		}
+
+		intermediateCerts, identityCert, err := util.ExtractCertificates(cfInstanceCertContents)
+		if err != nil {
+			return nil, checks.fail(checkNameRequest, newLoginFailure(failureCategoryInvalidRequest, err))
+		}
+		checks.pass(checkNameRequest)
+
+		// Ensure the time it was signed isn't too far in the past or future.
+		oldestAllowableSigningTime := timeReceived.Add(-1 * config.LoginMaxSecNotBefore)
+		furthestFutureAllowableSigningTime := timeReceived.Add(config.LoginMaxSecNotAfter)
+		if signingTime.Before(oldestAllowableSigningTime) {
+			return nil, checks.fail(checkNameSigningTime, newLoginFailure(failureCategoryExpiredSigningTime, fmt.Errorf("request is too old; signed at %s but received request at %s; allowable seconds old is %d", signingTime, timeReceived, config.LoginMaxSecNotBefore/time.Second)))
+		}
+		if signingTime.After(furthestFutureAllowableSigningTime) {
+			return nil, checks.fail(checkNameSigningTime, newLoginFailure(failureCategoryExpiredSigningTime, fmt.Errorf("request is too far in the future; signed at %s but received request at %s; allowable seconds in the future is %d", signingTime, timeReceived, config.LoginMaxSecNotAfter/time.Second)))
+		}
+		checks.pass(checkNameSigningTime)
+
+		// Ensure the private key used to create the signature matches our identity
+		// certificate, and that it signed the same data as is presented in the body.
+		// This offers some protection against MITM attacks.
+		signingCert, err = signatures.Verify(signature, &signatures.SignatureData{
+			SigningTime:            signingTime,
+			Role:                   signedRoleName,
+			CFInstanceCertContents: cfInstanceCertContents,
+			Audience:               audience,
+		})
+		if err != nil {
+			return nil, checks.fail(checkNameSignature, newLoginFailure(failureCategoryBadSignature, err))
+		}
+		// Verify has already parsed the signature, so its version is known to be readable.
+		if version, _ := signatures.VersionOf(signature); version < minimumSignatureVersion(config) {
+			return nil, checks.fail(checkNameSignature, newLoginFailure(failureCategoryBadSignature, fmt.Errorf("signature version %d is not accepted; the minimum version is %d", version, minimumSignatureVersion(config))))
+		}
+		checks.pass(checkNameSignature)
+
+		// Make sure the identity/signing cert was actually issued by our CA.
+		roots, err := b.getIdentityCAPool(config)
+		if err != nil {
+			return nil, checks.fail(checkNameCertificateChain, newLoginFailure(failureCategoryUntrustedCertificate, err))
+		}
+		if err := util.ValidateWithRoots(roots, intermediateCerts, identityCert, signingCert); err != nil {
+			return nil, checks.fail(checkNameCertificateChain, newLoginFailure(failureCategoryUntrustedCertificate, err))
+		}
+	}
+
+	// However it was presented, the certificate must be as strong and as short-lived as required.
+	if err := util.CheckKeyStrength(signingCert, config.MinimumRSAKeyBits, config.MinimumECDSAKeyBits); err != nil {
+		return nil, checks.fail(checkNameCertificateChain, newLoginFailure(failureCategoryUntrustedCertificate, err))
+	}
+	if err := util.CheckValidityPeriod(signingCert, config.MaxCertValidityPeriod); err != nil {
+		return nil, checks.fail(checkNameCertificateChain, newLoginFailure(failureCategoryUntrustedCertificate, err))
 	}
-	// Make sure the identity/signing cert was actually issued by our CA.
-	if err := util.Validate(config.IdentityCACertificates, intermediateCert, identityCert, signingCert); err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+	if config.StrictIdentityFormat {
+		if err := models.CheckStrictIdentityFormat(signingCert); err != nil {
+			return nil, checks.fail(checkNameCertificateChain, newLoginFailure(failureCategoryUntrustedCertificate, err))
+		}
 	}
+	checks.pass(checkNameCertificateChain)
 
 	// Read CF's identity fields from the certificate.
 	cfCert, err := models.NewCFCertificateFromx509(signingCert)
+	if err != nil && (role == nil || role.AllowServiceInstanceLogin) {
+		// Certificates issued to service instances have no app, so they're parsed separately.
+		if serviceInstanceCert, serviceInstanceErr := models.NewServiceInstanceCertificateFromx509(signingCert); serviceInstanceErr == nil {
+			cfCert, err = serviceInstanceCert, nil
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -171,59 +369,129 @@ func (b *backend) operationLoginUpdate(ctx context.Context, req *logical.Request
 		b.Logger().Debug(fmt.Sprintf("handling login attempt from %+v", cfCert))
 	}
 
-	client, err := util.NewCFClient(config)
-	if err != nil {
-		return nil, err
+	// Now that the certificate is known to be genuine, failures can be attributed to its app.
+	if config.LoginFailureLimit > 0 && cfCert.AppID != "" {
+		if lockedUntil := b.limiter.lockedUntil("app:"+cfCert.AppID, timeReceived); !lockedUntil.IsZero() {
+			return nil, checks.fail(checkNameRoleConstraints, newLoginFailure(failureCategoryRateLimited, fmt.Errorf("too many failed logins from app %s; try again after %s", cfCert.AppID, lockedUntil.Format(time.RFC3339))))
+		}
+	}
+	if cfCert.AppID != "" {
+		tokenIndexEntry, err := getTokenIndexEntry(ctx, req.Storage, cfCert.AppID)
+		if err != nil {
+			return nil, err
+		}
+		if tokenIndexEntry != nil && tokenIndexEntry.Revoked() {
+			return nil, checks.fail(checkNameRoleConstraints, attributeToApp(newLoginFailure(failureCategoryRevoked, fmt.Errorf("app %s was cut off at %s", cfCert.AppID, tokenIndexEntry.RevokedAt.Format(time.RFC3339))), cfCert.AppID))
+		}
 	}
 
-	if err := b.validate(client, role, cfCert, req.Connection.RemoteAddr); err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+	if role == nil {
+		roleName, role, err = selectRole(ctx, req.Storage, cfCert, clientAddr(config, req))
+		if err != nil {
+			return nil, checks.fail(checkNameRoleConstraints, attributeToApp(err, cfCert.AppID))
+		}
+		if err := b.checkTokenBoundCIDRs(config, req, role, checks); err != nil {
+			return nil, err
+		}
 	}
 
-	orgName, err := b.getOrgName(client, cfCert)
+	if config.RequireBoundConstraints && !role.HasBoundConstraints() {
+		return nil, checks.fail(checkNameRoleConstraints, attributeToApp(newLoginFailure(failureCategoryRoleConstraint, fmt.Errorf("role %q has no bound constraints, which the config requires", roleName)), cfCert.AppID))
+	}
+	if err := checkRoleConstraints(role, cfCert, clientAddr(config, req)); err != nil {
+		return nil, checks.fail(checkNameRoleConstraints, attributeToApp(err, cfCert.AppID))
+	}
+	instanceCIDRs, err := instanceBoundCIDRs(role, cfCert)
 	if err != nil {
-		return nil, err
+		return nil, checks.fail(checkNameRoleConstraints, attributeToApp(err, cfCert.AppID))
 	}
+	checks.pass(checkNameRoleConstraints)
 
-	appName, err := b.getAppName(client, cfCert)
+	client, err := b.getCFClient(config)
 	if err != nil {
 		return nil, err
 	}
+	// The calls made for this login are tagged with its request ID, so they can be traced in Cloud Controller's logs.
+	client = util.WithRequestID(client, req.ID)
 
-	spaceName, err := b.getSpaceName(client, cfCert)
+	resources, err := checkCFAPI(client, b.cfAPICache, config.CFAPICacheTTL, config.CFAPIMaxStaleness, cfCert)
 	if err != nil {
-		return nil, err
+		return nil, checks.fail(checkNameCFAPI, attributeToApp(err, cfCert.AppID))
+	}
+	if err := checkOrgStatus(config, resources); err != nil {
+		return nil, checks.fail(checkNameCFAPI, attributeToApp(err, cfCert.AppID))
+	}
+	// Constraints on the app or service instance's record can only be checked once it's been fetched.
+	if err := checkAppConstraints(client, role, cfCert, resources); err != nil {
+		return nil, checks.fail(checkNameCFAPI, attributeToApp(err, cfCert.AppID))
+	}
+	// Policies may be templated on the metadata, so a login that can't include it fails rather than
+	// issuing a token that's missing it.
+	if resources.Metadata, err = selectedCFMetadata(client, role, cfCert); err != nil {
+		return nil, checks.fail(checkNameCFAPI, attributeToApp(newLoginFailure(failureCategoryCFAPIError, err), cfCert.AppID))
+	}
+	checks.pass(checkNameCFAPI)
+
+	// The instance index is only used to describe the instance, so failing to find it shouldn't fail the login.
+	displayName := cfCert.InstanceID
+	if !cfCert.IsServiceInstance() && resources.Task == nil {
+		if index, err := getInstanceIndex(client, cfCert); err == nil {
+			resources.InstanceIndex = strconv.Itoa(index)
+			displayName = fmt.Sprintf("%s-%d", cfCert.InstanceID, index)
+		} else if task, taskErr := getAppTask(client, cfCert); taskErr == nil {
+			// Tasks run alongside the app's instances, but aren't among them.
+			resources.Task = task
+		} else {
+			b.Logger().Warn(fmt.Sprintf("unable to determine the instance index of %s: %s", cfCert.InstanceID, err))
+		}
 	}
 
 	// Everything checks out.
+	certNotAfter := signingCert.NotAfter.UTC().Format(time.RFC3339)
 	auth := &logical.Auth{
 		InternalData: map[string]interface{}{
-			"role":        roleName,
-			"instance_id": cfCert.InstanceID,
-			"ip_address":  cfCert.IPAddress,
+			"role":           roleName,
+			"instance_id":    cfCert.InstanceID,
+			"ip_address":     cfCert.IPAddress,
+			"cert_not_after": certNotAfter,
 		},
-		DisplayName: cfCert.InstanceID,
+		DisplayName: displayName,
+		Metadata:    loginMetadata(cfCert, resources),
 		Alias: &logical.Alias{
-			Name: cfCert.AppID,
-			Metadata: map[string]string{
-				"org_id":     cfCert.OrgID,
-				"app_id":     cfCert.AppID,
-				"space_id":   cfCert.SpaceID,
-				"org_name":   orgName,
-				"app_name":   appName,
-				"space_name": spaceName,
-			},
+			Name:     aliasName(config, cfCert, resources),
+			Metadata: loginMetadata(cfCert, resources),
 		},
+		GroupAliases: groupAliases(config, cfCert.OrgID, cfCert.SpaceID, resources.Org.Name, resources.Space.Name),
+	}
+	// The expiry is left out of the alias's metadata because it changes with every certificate.
+	auth.Metadata["cert_not_after"] = certNotAfter
+	// Only the token's metadata is written to audit logs. The alias's is kept whole, since renewals use it.
+	if err := b.redactMetadata(ctx, req.Storage, config, auth.Metadata); err != nil {
+		return nil, err
 	}
 
 	role.PopulateTokenAuth(auth)
-
-	return &logical.Response{
-		Auth: auth,
-	}, nil
+	if len(auth.BoundCIDRs) == 0 {
+		auth.BoundCIDRs = instanceCIDRs
+	}
+	mapped, err := mappedPolicies(ctx, req.Storage, cfCert, resources)
+	if err != nil {
+		return nil, err
+	}
+	if len(mapped) > 0 {
+		auth.Policies = policyutil.SanitizePolicies(append(auth.Policies, mapped...), false)
+	}
+	return auth, nil
 }
 
 func (b *backend) pathLoginRenew(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	resp, err := b.renew(ctx, req)
+	roleName, _ := req.Auth.InternalData["role"].(string)
+	recordRenewal(roleName, err == nil && !resp.IsError())
+	return resp, err
+}
+
+func (b *backend) renew(ctx context.Context, req *logical.Request) (*logical.Response, error) {
 	config, err := config(ctx, req.Storage)
 	if err != nil {
 		return nil, err
@@ -242,19 +510,38 @@ func (b *backend) pathLoginRenew(ctx context.Context, req *logical.Request, data
 		return nil, err
 	}
 	if role == nil {
-		return nil, errors.New("no matching role")
+		return logical.ErrorResponse(fmt.Sprintf("role %q no longer exists", roleName)), nil
+	}
+	if config.RequireBoundConstraints && !role.HasBoundConstraints() {
+		return logical.ErrorResponse(fmt.Sprintf("role %q has no bound constraints, which the config requires", roleName)), nil
 	}
 
-	instanceID, err := getOrErr("instance_id", req.Auth.InternalData)
-	if err != nil {
-		return nil, err
+	// Tokens issued before the certificate's expiry was recorded can't be checked against it.
+	if raw, ok := req.Auth.InternalData["cert_not_after"]; ok && !role.DisableCertExpiryRenewalCheck {
+		certNotAfter, err := time.Parse(time.RFC3339, fmt.Sprintf("%v", raw))
+		if err != nil {
+			return nil, err
+		}
+		if now := time.Now(); now.After(certNotAfter) {
+			// A current certificate may have been presented for the token since it was issued.
+			presentedNotAfter, err := renewalCertNotAfter(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			if !now.Before(presentedNotAfter) {
+				return logical.ErrorResponse(fmt.Sprintf("the instance identity certificate used to log in expired at %s; log in again with a current certificate, or present one to renew-with-cert", certNotAfter.Format(time.RFC3339))), nil
+			}
+		}
 	}
 
-	ipAddr, err := getOrErr("ip_address", req.Auth.InternalData)
+	instanceID, err := getOrErr("instance_id", req.Auth.InternalData)
 	if err != nil {
 		return nil, err
 	}
 
+	// Service instance certificates may have no IP address.
+	ipAddr, _ := req.Auth.InternalData["ip_address"].(string)
+
 	orgID, err := getOrErr("org_id", req.Auth.Alias.Metadata)
 	if err != nil {
 		return nil, err
@@ -265,122 +552,439 @@ func (b *backend) pathLoginRenew(ctx context.Context, req *logical.Request, data
 		return nil, err
 	}
 
-	appID, err := getOrErr("app_id", req.Auth.Alias.Metadata)
-	if err != nil {
-		return nil, err
+	// Reconstruct the certificate and ensure it still meets all constraints.
+	var cfCert *models.CFCertificate
+	if _, ok := req.Auth.Alias.Metadata["app_id"]; ok {
+		appID, err := getOrErr("app_id", req.Auth.Alias.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		cfCert, err = models.NewCFCertificate(instanceID, orgID, spaceID, appID, ipAddr)
+		if err != nil {
+			return nil, err
+		}
+
+		indexEntry, err := getAppIndexEntry(ctx, req.Storage, appID)
+		if err != nil {
+			return nil, err
+		}
+		if indexEntry != nil && indexEntry.Deleted() {
+			return logical.ErrorResponse(fmt.Sprintf("app %s was found to have been deleted from CF at %s", appID, indexEntry.DeletedAt.Format(time.RFC3339))), nil
+		}
+		tokenIndexEntry, err := getTokenIndexEntry(ctx, req.Storage, appID)
+		if err != nil {
+			return nil, err
+		}
+		if tokenIndexEntry != nil && tokenIndexEntry.Revoked() {
+			return logical.ErrorResponse(fmt.Sprintf("app %s was cut off at %s", appID, tokenIndexEntry.RevokedAt.Format(time.RFC3339))), nil
+		}
+	} else {
+		// Only tokens issued to service instances have no app ID.
+		if !role.AllowServiceInstanceLogin {
+			return logical.ErrorResponse(fmt.Sprintf("role %q no longer allows service instance logins", roleName)), nil
+		}
+		cfCert, err = models.NewServiceInstanceCertificate(instanceID, orgID, spaceID, ipAddr)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// Reconstruct the certificate and ensure it still meets all constraints.
-	cfCert, err := models.NewCFCertificate(instanceID, orgID, spaceID, appID, ipAddr)
+	if role.DisableCFAPIRenewalCheck {
+		// Only what can be checked locally is re-checked, so renewals don't depend on the CF API.
+		if err := checkRoleConstraints(role, cfCert, clientAddr(config, req)); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	} else {
+		client, err := b.getCFClient(config)
+		if err != nil {
+			return nil, err
+		}
+		client = util.WithRequestID(client, req.ID)
+		if _, err := b.validate(config, client, role, cfCert, clientAddr(config, req)); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
 
-	client, err := util.NewCFClient(config)
-	if err != nil {
-		return nil, err
+	// Tokens issued before the role had a quota aren't counted against it.
+	quotaKey, _ := req.Auth.InternalData["token_quota_key"].(string)
+	quotaID, _ := req.Auth.InternalData["token_quota_id"].(string)
+	if role.TokenQuota > 0 && quotaKey != "" && quotaID != "" {
+		counted, err := b.renewTokenQuota(ctx, req.Storage, quotaKey, quotaID, role, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if !counted {
+			return logical.ErrorResponse(fmt.Sprintf("the token was displaced by newer logins beyond the role's token_quota of %d", role.TokenQuota)), nil
+		}
 	}
 
-	if err := b.validate(client, role, cfCert, req.Connection.RemoteAddr); err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+	if !cfCert.IsServiceInstance() && req.Auth.Accessor != "" {
+		// Failing to index the token only keeps it from being listed, so it shouldn't fail the renewal.
+		loginID, _ := req.Auth.InternalData["token_index_id"].(string)
+		if err := b.indexTokenRenewal(ctx, req.Storage, cfCert.AppID, cfCert.SpaceID, loginID, req.Auth.Accessor, time.Now().UTC()); err != nil {
+			b.Logger().Warn(fmt.Sprintf("unable to index a token of app %s: %s", cfCert.AppID, err))
+		}
 	}
 
 	resp := &logical.Response{Auth: req.Auth}
+	// The names are those recorded at login, so the groups follow a rename at the next login.
+	resp.Auth.GroupAliases = groupAliases(config, orgID, spaceID, req.Auth.Alias.Metadata["org_name"], req.Auth.Alias.Metadata["space_name"])
 	resp.Auth.TTL = role.TokenTTL
 	resp.Auth.MaxTTL = role.TokenMaxTTL
 	resp.Auth.Period = role.TokenPeriod
 	return resp, nil
 }
 
-func (b *backend) validate(client *cfclient.Client, role *models.RoleEntry, cfCert *models.CFCertificate, reqConnRemoteAddr string) error {
-	if !role.DisableIPMatching {
-		if !matchesIPAddress(reqConnRemoteAddr, net.ParseIP(cfCert.IPAddress)) {
-			return errors.New("no matching IP address")
+// cfResources are the records fetched from the CF API while validating a certificate.
+type cfResources struct {
+	App             cfclient.App
+	ServiceInstance cfclient.ServiceInstance
+	Task            *cfclient.Task
+	Org             cfclient.Org
+	Space           cfclient.Space
+
+	// InstanceIndex is the index of the app instance, or empty if it couldn't be determined.
+	InstanceIndex string
+
+	// Metadata holds the annotations and labels the role copies into the token's metadata, keyed
+	// as they're copied.
+	Metadata map[string]string
+}
+
+// aliasName returns the name of the entity alias for the instance that logged in.
+func aliasName(config *models.Configuration, cfCert *models.CFCertificate, resources *cfResources) string {
+	source := aliasNameSource(config)
+	if cfCert.IsServiceInstance() && (source == aliasNameSourceAppID || source == aliasNameSourceAppName) {
+		// Service instances have no app to name the alias after.
+		source = aliasNameSourceInstanceID
+	}
+	switch source {
+	case aliasNameSourceAppName:
+		return resources.App.Name
+	case aliasNameSourceSpaceID:
+		return cfCert.SpaceID
+	case aliasNameSourceOrgID:
+		return cfCert.OrgID
+	case aliasNameSourceInstanceID:
+		return cfCert.InstanceID
+	default:
+		return cfCert.AppID
+	}
+}
+
+// groupAliases returns the group aliases for the given org and space, named as configured, so that
+// the entity is a member of the external groups they're attached to. Names that aren't known, such as
+// those of records that couldn't be fetched, are left out.
+func groupAliases(config *models.Configuration, orgID, spaceID, orgName, spaceName string) []*logical.Alias {
+	var names []string
+	switch groupAliasSource(config) {
+	case groupAliasSourceID:
+		names = []string{orgID, spaceID}
+	case groupAliasSourceName:
+		names = []string{orgName, ""}
+		// Space names are only unique within an org, so they're qualified by it.
+		if orgName != "" && spaceName != "" {
+			names[1] = orgName + "/" + spaceName
 		}
 	}
-	if !meetsBoundConstraints(cfCert.InstanceID, role.BoundInstanceIDs) {
-		return fmt.Errorf("instance ID %s doesn't match role constraints of %s", cfCert.InstanceID, role.BoundInstanceIDs)
+	var aliases []*logical.Alias
+	for _, name := range names {
+		if name != "" {
+			aliases = append(aliases, &logical.Alias{Name: name})
+		}
 	}
-	if !meetsBoundConstraints(cfCert.AppID, role.BoundAppIDs) {
-		return fmt.Errorf("app ID %s doesn't match role constraints of %s", cfCert.AppID, role.BoundAppIDs)
+	return aliases
+}
+
+// loginMetadata returns the metadata describing the instance that logged in, for use on both the token
+// and its entity alias so the fields are available for templated policies and identity group mapping.
+func loginMetadata(cfCert *models.CFCertificate, resources *cfResources) map[string]string {
+	metadata := map[string]string{
+		"instance_id": cfCert.InstanceID,
+		"org_id":      cfCert.OrgID,
+		"space_id":    cfCert.SpaceID,
+		"org_name":    resources.Org.Name,
+		"space_name":  resources.Space.Name,
 	}
-	if !meetsBoundConstraints(cfCert.OrgID, role.BoundOrgIDs) {
-		return fmt.Errorf("org ID %s doesn't match role constraints of %s", cfCert.OrgID, role.BoundOrgIDs)
+	if cfCert.IsServiceInstance() {
+		metadata["service_instance_name"] = resources.ServiceInstance.Name
+	} else {
+		metadata["app_id"] = cfCert.AppID
+		metadata["app_name"] = resources.App.Name
 	}
-	if !meetsBoundConstraints(cfCert.SpaceID, role.BoundSpaceIDs) {
-		return fmt.Errorf("space ID %s doesn't match role constraints of %s", cfCert.SpaceID, role.BoundSpaceIDs)
+	if resources.Task != nil {
+		metadata["task_name"] = resources.Task.Name
 	}
-	// Use the CF API to ensure everything still exists and to verify whatever we can.
-
-	// Here, if it were possible, we _would_ do an API call to check the instance ID,
-	// but currently there's no known way to do that via the cf API.
+	if resources.InstanceIndex != "" {
+		metadata["instance_index"] = resources.InstanceIndex
+	}
+	for key, value := range resources.Metadata {
+		metadata[key] = value
+	}
+	return metadata
+}
 
-	// Check everything we can using the app ID.
-	app, err := client.AppByGuid(cfCert.AppID)
+// selectedCFMetadata returns the annotations and labels of the app or service instance that the role
+// copies into the token's metadata, prefixed so they can't replace any other metadata. They're only
+// looked up if the role needs them.
+func selectedCFMetadata(client *cfclient.Client, role *models.RoleEntry, cfCert *models.CFCertificate) (map[string]string, error) {
+	if len(role.MetadataAnnotationKeys) == 0 && len(role.MetadataLabelKeys) == 0 {
+		return nil, nil
+	}
+	cfMetadata, err := getCFMetadata(client, cfCert)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if app.Guid != cfCert.AppID {
-		return fmt.Errorf("cert app ID %s doesn't match API's expected one of %s", cfCert.AppID, app.Guid)
+	selected := make(map[string]string)
+	for _, key := range role.MetadataAnnotationKeys {
+		if value, ok := cfMetadata.Annotations[key]; ok {
+			selected["annotation_"+key] = value
+		}
 	}
-	if app.SpaceGuid != cfCert.SpaceID {
-		return fmt.Errorf("cert space ID %s doesn't match API's expected one of %s", cfCert.SpaceID, app.SpaceGuid)
+	for _, key := range role.MetadataLabelKeys {
+		if value, ok := cfMetadata.Labels[key]; ok {
+			selected["label_"+key] = value
+		}
 	}
-	if app.Instances <= 0 {
-		return errors.New("app doesn't have any live instances")
+	return selected, nil
+}
+
+// loginRoleName returns the name of the role a login is for: the one it names, or the default role
+// if it doesn't name one. If it's empty, the role is left to be selected.
+func loginRoleName(config *models.Configuration, data *framework.FieldData) string {
+	if roleName := data.Get("role").(string); roleName != "" {
+		return roleName
 	}
+	return config.DefaultRole
+}
 
-	// Check everything we can using the org ID.
-	org, err := client.GetOrgByGuid(cfCert.OrgID)
-	if err != nil {
-		return err
+// instanceBoundCIDRs returns the network the role binds tokens to by default, which contains the
+// certificate's IP address, or nil if it doesn't bind them to one.
+func instanceBoundCIDRs(role *models.RoleEntry, cfCert *models.CFCertificate) ([]*sockaddr.SockAddrMarshaler, error) {
+	if role.InstanceBoundCIDRPrefixLength == 0 || len(role.TokenBoundCIDRs) > 0 {
+		return nil, nil
 	}
-	if org.Guid != cfCert.OrgID {
-		return fmt.Errorf("cert org ID %s doesn't match API's expected one of %s", cfCert.OrgID, org.Guid)
+	ip := net.ParseIP(cfCert.IPAddress)
+	if ip == nil {
+		return nil, newLoginFailure(failureCategoryRoleConstraint, errors.New("the certificate has no IP address to bind the token to"))
+	}
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		ip, bits = ip.To4(), 8*net.IPv4len
+	}
+	if role.InstanceBoundCIDRPrefixLength > bits {
+		return nil, newLoginFailure(failureCategoryRoleConstraint, fmt.Errorf("prefix length %d is too long for IP address %s", role.InstanceBoundCIDRPrefixLength, cfCert.IPAddress))
 	}
+	mask := net.CIDRMask(role.InstanceBoundCIDRPrefixLength, bits)
+	network := &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+	return parseutil.ParseAddrs([]string{network.String()})
+}
 
-	// Check everything we can using the space ID.
-	space, err := client.GetSpaceByGuid(cfCert.SpaceID)
-	if err != nil {
-		return err
+// checkTokenBoundCIDRs ensures the caller's address is allowed by the role's token_bound_cidrs,
+// recording the outcome in checks.
+func (b *backend) checkTokenBoundCIDRs(config *models.Configuration, req *logical.Request, role *models.RoleEntry, checks *loginChecks) error {
+	if len(role.TokenBoundCIDRs) == 0 {
+		checks.skip(checkNameTokenBoundCIDRs)
+		return nil
 	}
-	if space.Guid != cfCert.SpaceID {
-		return fmt.Errorf("cert space ID %s doesn't match API's expected one of %s", cfCert.SpaceID, space.Guid)
+	if req.Connection == nil {
+		b.Logger().Warn("token bound CIDRs found but no connection information available for validation")
+		return checks.fail(checkNameTokenBoundCIDRs, logical.ErrPermissionDenied)
 	}
-	if space.OrganizationGuid != cfCert.OrgID {
-		return fmt.Errorf("cert org ID %s doesn't match API's expected one of %s", cfCert.OrgID, space.OrganizationGuid)
+	if !cidrutil.RemoteAddrIsOk(clientAddr(config, req), role.TokenBoundCIDRs) {
+		return checks.fail(checkNameTokenBoundCIDRs, logical.ErrPermissionDenied)
 	}
+	checks.pass(checkNameTokenBoundCIDRs)
 	return nil
 }
 
-func (b *backend) getOrgName(client *cfclient.Client, cfCert *models.CFCertificate) (string, error) {
-
-	org, err := client.GetOrgByGuid(cfCert.OrgID)
+// validate ensures the certificate meets the role's constraints and still matches what the CF API knows
+// about the instance. It returns the records fetched along the way so callers needn't fetch them again.
+func (b *backend) validate(config *models.Configuration, client *cfclient.Client, role *models.RoleEntry, cfCert *models.CFCertificate, reqConnRemoteAddr string) (*cfResources, error) {
+	if err := checkRoleConstraints(role, cfCert, reqConnRemoteAddr); err != nil {
+		return nil, err
+	}
+	resources, err := checkCFAPI(client, b.cfAPICache, config.CFAPICacheTTL, config.CFAPIMaxStaleness, cfCert)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	return org.Name, nil
-
+	if err := checkOrgStatus(config, resources); err != nil {
+		return nil, err
+	}
+	if err := checkAppConstraints(client, role, cfCert, resources); err != nil {
+		return nil, err
+	}
+	return resources, nil
 }
 
-func (b *backend) getAppName(client *cfclient.Client, cfCert *models.CFCertificate) (string, error) {
-
-	app, err := client.AppByGuid(cfCert.AppID)
-	if err != nil {
-		return "", err
+// checkRoleConstraints ensures the certificate meets the role's constraints.
+func checkRoleConstraints(role *models.RoleEntry, cfCert *models.CFCertificate, reqConnRemoteAddr string) error {
+	// When the IP address is matched against the CF API's, that's done with the other app constraints.
+	if !role.DisableIPMatching && ipMatchingSource(role) == ipMatchingSourceRemoteAddr {
+		if !matchesIPAddress(reqConnRemoteAddr, net.ParseIP(cfCert.IPAddress)) {
+			return newLoginFailure(failureCategoryRoleConstraint, errors.New("no matching IP address"))
+		}
+	}
+	if !meetsBoundConstraints(cfCert.InstanceID, role.BoundInstanceIDs) {
+		return newLoginFailure(failureCategoryRoleConstraint, fmt.Errorf("instance ID %s doesn't match role constraints of %s", cfCert.InstanceID, role.BoundInstanceIDs))
 	}
+	if !meetsBoundConstraints(cfCert.AppID, role.BoundAppIDs) {
+		return newLoginFailure(failureCategoryRoleConstraint, fmt.Errorf("app ID %s doesn't match role constraints of %s", cfCert.AppID, role.BoundAppIDs))
+	}
+	if !meetsBoundConstraints(cfCert.OrgID, role.BoundOrgIDs) {
+		return newLoginFailure(failureCategoryRoleConstraint, fmt.Errorf("org ID %s doesn't match role constraints of %s", cfCert.OrgID, role.BoundOrgIDs))
+	}
+	if !meetsBoundConstraints(cfCert.SpaceID, role.BoundSpaceIDs) {
+		return newLoginFailure(failureCategoryRoleConstraint, fmt.Errorf("space ID %s doesn't match role constraints of %s", cfCert.SpaceID, role.BoundSpaceIDs))
+	}
+	return nil
+}
 
-	return app.Name, nil
+// checkOrgStatus ensures the org fetched from the CF API isn't suspended, unless the config allows it.
+// Suspending an org is how operators cut off its apps, so they should lose access to secrets too.
+func checkOrgStatus(config *models.Configuration, resources *cfResources) error {
+	if config.AllowSuspendedOrgs || resources.Org.Status != orgStatusSuspended {
+		return nil
+	}
+	return newLoginFailure(failureCategoryOrgSuspended, fmt.Errorf("org %s is suspended", resources.Org.Guid))
+}
 
+// checkAppConstraints ensures the app or service instance fetched from the CF API meets the role's
+// constraints on what it's named, how it was built, and where it's running. The stack, isolation segment,
+// and the app's instances are only looked up if the role needs them.
+func checkAppConstraints(client *cfclient.Client, role *models.RoleEntry, cfCert *models.CFCertificate, resources *cfResources) error {
+	if len(role.BoundServiceInstanceNames) > 0 {
+		if !cfCert.IsServiceInstance() {
+			return newLoginFailure(failureCategoryRoleConstraint, fmt.Errorf("only service instances may log in with roles bound to service instance names, but the certificate is for app %s", cfCert.AppID))
+		}
+		if !meetsBoundConstraints(resources.ServiceInstance.Name, role.BoundServiceInstanceNames) {
+			return newLoginFailure(failureCategoryRoleConstraint, fmt.Errorf("service instance name %s doesn't match role constraints of %s", resources.ServiceInstance.Name, role.BoundServiceInstanceNames))
+		}
+	}
+	if len(role.BoundBuildpacks) > 0 {
+		if !meetsBoundConstraints(resources.App.Buildpack, role.BoundBuildpacks) && !meetsBoundConstraints(resources.App.DetectedBuildpack, role.BoundBuildpacks) {
+			return newLoginFailure(failureCategoryRoleConstraint, fmt.Errorf("app %s was built with buildpack %q, detected as %q, which doesn't match role constraints of %s", resources.App.Guid, resources.App.Buildpack, resources.App.DetectedBuildpack, role.BoundBuildpacks))
+		}
+	}
+	if len(role.BoundStacks) > 0 {
+		stack, err := getStack(client, resources.App.StackGuid)
+		if err != nil {
+			return newLoginFailure(failureCategoryCFAPIError, err)
+		}
+		if !meetsBoundConstraints(stack.Name, role.BoundStacks) {
+			return newLoginFailure(failureCategoryRoleConstraint, fmt.Errorf("app %s runs on stack %s, which doesn't match role constraints of %s", resources.App.Guid, stack.Name, role.BoundStacks))
+		}
+	}
+	if len(role.BoundIsolationSegments) > 0 {
+		segment, err := getIsolationSegmentName(client, cfCert.SpaceID, cfCert.OrgID)
+		if err != nil {
+			return newLoginFailure(failureCategoryCFAPIError, err)
+		}
+		if !meetsBoundConstraints(segment, role.BoundIsolationSegments) {
+			return newLoginFailure(failureCategoryRoleConstraint, fmt.Errorf("app %s runs in isolation segment %s, which doesn't match role constraints of %s", resources.App.Guid, segment, role.BoundIsolationSegments))
+		}
+	}
+	if role.RequireInstanceIPMatch || (!role.DisableIPMatching && ipMatchingSource(role) == ipMatchingSourceCFAPI) {
+		matches, err := instanceIPMatches(client, cfCert)
+		if err != nil {
+			return newLoginFailure(failureCategoryCFAPIError, err)
+		}
+		if !matches {
+			return newLoginFailure(failureCategoryRoleConstraint, fmt.Errorf("IP address %s isn't that of any running instance of app %s", cfCert.IPAddress, cfCert.AppID))
+		}
+	}
+	return nil
 }
 
-func (b *backend) getSpaceName(client *cfclient.Client, cfCert *models.CFCertificate) (string, error) {
+// checkCFAPI uses the CF API to ensure everything still exists and to verify whatever we can about the
+// certificate. It returns the records fetched so callers needn't fetch them again. Records fetched within
+// the TTL are used from the cache rather than fetched again, and those fetched within the max staleness
+// are used if the CF API is unavailable, unless the cache is nil.
+func checkCFAPI(client *cfclient.Client, cache *cfAPICache, ttl, maxStaleness time.Duration, cfCert *models.CFCertificate) (*cfResources, error) {
+	// Here, if it were possible, we _would_ do an API call to check the instance ID,
+	// but currently there's no known way to do that via the cf API.
 
-	space, err := client.GetSpaceByGuid(cfCert.SpaceID)
+	resources := &cfResources{}
+	if cfCert.IsServiceInstance() {
+		// Check everything we can using the service instance ID.
+		raw, err := cache.lookup(cacheKindServiceInstance, cfCert.InstanceID, ttl, maxStaleness, func() (interface{}, error) {
+			return client.GetServiceInstanceByGuid(cfCert.InstanceID)
+		})
+		if err != nil {
+			return nil, newLoginFailure(failureCategoryCFAPIError, err)
+		}
+		serviceInstance := raw.(cfclient.ServiceInstance)
+		if serviceInstance.Guid != cfCert.InstanceID {
+			return nil, newLoginFailure(failureCategoryCFAPIError, fmt.Errorf("cert service instance ID %s doesn't match API's expected one of %s", cfCert.InstanceID, serviceInstance.Guid))
+		}
+		if serviceInstance.SpaceGuid != cfCert.SpaceID {
+			return nil, newLoginFailure(failureCategoryCFAPIError, fmt.Errorf("cert space ID %s doesn't match API's expected one of %s", cfCert.SpaceID, serviceInstance.SpaceGuid))
+		}
+		resources.ServiceInstance = serviceInstance
+	} else {
+		// Check everything we can using the app ID.
+		raw, err := cache.lookup(cacheKindApp, cfCert.AppID, ttl, maxStaleness, func() (interface{}, error) {
+			return client.AppByGuid(cfCert.AppID)
+		})
+		if err != nil {
+			return nil, newLoginFailure(failureCategoryCFAPIError, err)
+		}
+		app := raw.(cfclient.App)
+		if app.Guid != cfCert.AppID {
+			return nil, newLoginFailure(failureCategoryCFAPIError, fmt.Errorf("cert app ID %s doesn't match API's expected one of %s", cfCert.AppID, app.Guid))
+		}
+		if app.SpaceGuid != cfCert.SpaceID {
+			return nil, newLoginFailure(failureCategoryCFAPIError, fmt.Errorf("cert space ID %s doesn't match API's expected one of %s", cfCert.SpaceID, app.SpaceGuid))
+		}
+		if app.Instances <= 0 {
+			// The app may still be running tasks, which aren't counted among its instances.
+			task, err := getAppTask(client, cfCert)
+			if err != nil {
+				return nil, newLoginFailure(failureCategoryCFAPIError, fmt.Errorf("app doesn't have any live instances, and the certificate isn't for one of its running tasks: %s", err))
+			}
+			resources.Task = task
+		}
+		resources.App = app
+	}
+
+	// Check everything we can using the org ID.
+	raw, err := cache.lookup(cacheKindOrg, cfCert.OrgID, ttl, maxStaleness, func() (interface{}, error) {
+		return client.GetOrgByGuid(cfCert.OrgID)
+	})
 	if err != nil {
-		return "", err
+		return nil, newLoginFailure(failureCategoryCFAPIError, err)
+	}
+	org := raw.(cfclient.Org)
+	if org.Guid != cfCert.OrgID {
+		return nil, newLoginFailure(failureCategoryCFAPIError, fmt.Errorf("cert org ID %s doesn't match API's expected one of %s", cfCert.OrgID, org.Guid))
 	}
 
-	return space.Name, nil
+	// Check everything we can using the space ID.
+	raw, err = cache.lookup(cacheKindSpace, cfCert.SpaceID, ttl, maxStaleness, func() (interface{}, error) {
+		return client.GetSpaceByGuid(cfCert.SpaceID)
+	})
+	if err != nil {
+		return nil, newLoginFailure(failureCategoryCFAPIError, err)
+	}
+	space := raw.(cfclient.Space)
+	if space.Guid != cfCert.SpaceID {
+		return nil, newLoginFailure(failureCategoryCFAPIError, fmt.Errorf("cert space ID %s doesn't match API's expected one of %s", cfCert.SpaceID, space.Guid))
+	}
+	if space.OrganizationGuid != cfCert.OrgID {
+		return nil, newLoginFailure(failureCategoryCFAPIError, fmt.Errorf("cert org ID %s doesn't match API's expected one of %s", cfCert.OrgID, space.OrganizationGuid))
+	}
+	resources.Org = org
+	resources.Space = space
+	return resources, nil
+}
 
+// ipMatchingSource returns what the role matches the certificate's IP address against, accounting for
+// roles stored before it was configurable.
+func ipMatchingSource(role *models.RoleEntry) string {
+	if role.IPMatchingSource == "" {
+		return ipMatchingSourceRemoteAddr
+	}
+	return role.IPMatchingSource
 }
 
 func meetsBoundConstraints(certValue string, constraints []string) bool {
@@ -405,15 +1009,44 @@ func matchesIPAddress(remoteAddr string, certIP net.IP) bool {
 	return false
 }
 
-// Try parsing this as ISO 8601 AND the way that is default provided by Bash to make it easier to give via the CLI as well.
-func parseTime(signingTime string) (time.Time, error) {
-	if signingTime, err := time.Parse(signatures.TimeFormat, signingTime); err == nil {
-		return signingTime, nil
+// clientAddr returns the address of the caller. Ordinarily this is the remote address of the connection,
+// but when that's a trusted proxy, the X-Forwarded-For header is walked from right to left, skipping
+// further trusted proxies, until the first address that isn't trusted is found. Addresses prior to that
+// one could have been supplied by the caller, so they're never used.
+func clientAddr(config *models.Configuration, req *logical.Request) string {
+	if req.Connection == nil {
+		return ""
+	}
+	addr := req.Connection.RemoteAddr
+	if len(config.ForwardedForTrustedProxyCIDRs) == 0 || !remoteAddrIsTrusted(addr, config.ForwardedForTrustedProxyCIDRs) {
+		return addr
+	}
+	var forwardedFor []string
+	for k, v := range req.Headers {
+		if strings.EqualFold(k, HeaderXForwardedFor) {
+			forwardedFor = append(forwardedFor, v...)
+		}
 	}
-	if signingTime, err := time.Parse(util.BashTimeFormat, signingTime); err == nil {
-		return signingTime, nil
+	// Proxies may each add their own header or append to a single comma-separated one.
+	var hops []string
+	for _, v := range forwardedFor {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			// Anything to the left of an unparseable hop can't be trusted.
+			break
+		}
+		addr = hops[i]
+		if !remoteAddrIsTrusted(addr, config.ForwardedForTrustedProxyCIDRs) {
+			break
+		}
 	}
-	return time.Time{}, fmt.Errorf("couldn't parse %s", signingTime)
+	return addr
 }
 
 // getOrErr is a convenience method for pulling a string from a map.
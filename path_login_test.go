@@ -1,8 +1,14 @@
 package cf
 
 import (
+	"fmt"
 	"net"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/logical"
 )
 
 func TestMatchesIPAddr(t *testing.T) {
@@ -38,3 +44,163 @@ func TestMeetsBoundConstraints(t *testing.T) {
 		t.Fatal("shouldn't meet constraints")
 	}
 }
+
+func TestClientAddr(t *testing.T) {
+	config := &models.Configuration{
+		ForwardedForTrustedProxyCIDRs: []string{"10.0.0.0/24"},
+	}
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		expected     string
+	}{
+		{"no header", "10.0.0.5", nil, "10.0.0.5"},
+		{"untrusted proxy", "192.168.1.1", []string{"10.255.181.105"}, "192.168.1.1"},
+		{"trusted proxy", "10.0.0.5", []string{"10.255.181.105"}, "10.255.181.105"},
+		{"chained proxies", "10.0.0.5", []string{"1.2.3.4, 10.255.181.105, 10.0.0.6"}, "10.255.181.105"},
+		{"multiple headers", "10.0.0.5", []string{"1.2.3.4", "10.255.181.105"}, "10.255.181.105"},
+		{"all trusted", "10.0.0.5", []string{"10.0.0.7, 10.0.0.6"}, "10.0.0.7"},
+		{"unparseable hop", "10.0.0.5", []string{"10.255.181.105, unknown"}, "10.0.0.5"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := &logical.Request{
+				Connection: &logical.Connection{RemoteAddr: test.remoteAddr},
+				Headers:    map[string][]string{"X-Forwarded-For": test.forwardedFor},
+			}
+			if actual := clientAddr(config, req); actual != test.expected {
+				t.Fatalf("expected %s but received %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestAliasName(t *testing.T) {
+	cfCert := &models.CFCertificate{
+		InstanceID: "instance-id",
+		OrgID:      "org-id",
+		SpaceID:    "space-id",
+		AppID:      "app-id",
+		IPAddress:  "10.255.181.105",
+	}
+	resources := &cfResources{}
+	resources.App.Name = "app-name"
+
+	expected := map[string]string{
+		"":            "app-id",
+		"app_id":      "app-id",
+		"app_name":    "app-name",
+		"space_id":    "space-id",
+		"org_id":      "org-id",
+		"instance_id": "instance-id",
+	}
+	for source, expectedName := range expected {
+		config := &models.Configuration{AliasNameSource: source}
+		if actual := aliasName(config, cfCert, resources); actual != expectedName {
+			t.Fatalf("expected %s for %q but received %s", expectedName, source, actual)
+		}
+	}
+}
+
+func TestGroupAliases(t *testing.T) {
+	expected := map[string]string{
+		"":     "[]",
+		"none": "[]",
+		"id":   "[org-id space-id]",
+		"name": "[my-org my-org/my-space]",
+	}
+	for source, expectedNames := range expected {
+		config := &models.Configuration{GroupAliasSource: source}
+		var names []string
+		for _, alias := range groupAliases(config, "org-id", "space-id", "my-org", "my-space") {
+			names = append(names, alias.Name)
+		}
+		if actual := fmt.Sprint(names); actual != expectedNames {
+			t.Fatalf("expected %s for %q but received %s", expectedNames, source, actual)
+		}
+	}
+
+	// Spaces aren't named without their org.
+	config := &models.Configuration{GroupAliasSource: "name"}
+	if aliases := groupAliases(config, "org-id", "space-id", "", "my-space"); len(aliases) != 0 {
+		t.Fatalf("expected no aliases without the org's name but received %d", len(aliases))
+	}
+}
+
+func TestInstanceBoundCIDRs(t *testing.T) {
+	cfCert := &models.CFCertificate{IPAddress: "10.255.181.105"}
+	for _, testCase := range []struct {
+		role      *models.RoleEntry
+		expected  string
+		expectErr bool
+	}{
+		{&models.RoleEntry{}, "", false},
+		{&models.RoleEntry{InstanceBoundCIDRPrefixLength: 32}, "10.255.181.105", false},
+		{&models.RoleEntry{InstanceBoundCIDRPrefixLength: 24}, "10.255.181.0/24", false},
+		{&models.RoleEntry{InstanceBoundCIDRPrefixLength: 64}, "", true},
+	} {
+		cidrs, err := instanceBoundCIDRs(testCase.role, cfCert)
+		if testCase.expectErr {
+			if err == nil {
+				t.Fatalf("expected an error for a prefix length of %d", testCase.role.InstanceBoundCIDRPrefixLength)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := ""
+		if len(cidrs) > 0 {
+			actual = cidrs[0].String()
+		}
+		if actual != testCase.expected {
+			t.Fatalf("expected %q for a prefix length of %d but received %q", testCase.expected, testCase.role.InstanceBoundCIDRPrefixLength, actual)
+		}
+	}
+}
+
+func TestUnknownRole(t *testing.T) {
+	env := newLoadTestEnv(t)
+	defer env.close()
+	env.mustHandle(logical.UpdateOperation, "config", map[string]interface{}{"login_failure_limit": 1})
+
+	// Probing for role names is a failed login like any other, so it's limited like one.
+	req := env.loginRequest(t)
+	req.Data["role"] = "missing-role"
+	if resp := env.handleRequest(req); resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), failureCategoryInvalidRequest) {
+		t.Fatalf("expected the login to fail as an invalid request but received %#v", resp)
+	}
+	if env.backend.limiter.lockedUntil("ip:"+loadTestInstanceIP, time.Now()).IsZero() {
+		t.Fatal("expected the failure to count against the address")
+	}
+
+	resp := env.handleRequest(&logical.Request{
+		Operation: logical.RenewOperation,
+		Path:      "login",
+		Auth: &logical.Auth{
+			InternalData: map[string]interface{}{"role": "missing-role"},
+		},
+	})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected the renewal to be refused but received %#v", resp)
+	}
+}
+
+func TestLoginKeyStrength(t *testing.T) {
+	env := newLoadTestEnv(t)
+	defer env.close()
+	setMinimumRSAKeyBits := func(bits int) {
+		env.mustHandle(logical.UpdateOperation, "config", map[string]interface{}{"minimum_rsa_key_bits": bits})
+	}
+
+	// The test certificates have 2048-bit keys, and signed logins are held to the minimum like any other.
+	setMinimumRSAKeyBits(4096)
+	if err := env.login(env.loginRequest(t)); err == nil || !strings.Contains(err.Error(), failureCategoryUntrustedCertificate) {
+		t.Fatalf("expected the login with a weak key to be refused but received %v", err)
+	}
+	setMinimumRSAKeyBits(2048)
+	if err := env.login(env.loginRequest(t)); err != nil {
+		t.Fatal(err)
+	}
+}
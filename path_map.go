@@ -0,0 +1,227 @@
+package cf
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/policyutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// The kinds of policy mappings. Each is the second part of the paths and storage keys of its mappings.
+const (
+	mapKindOrgs   = "orgs"
+	mapKindSpaces = "spaces"
+)
+
+const mapStoragePrefix = "map/"
+
+// spaceNameRegex matches the name of a space mapping, which is either a space's GUID or the name of
+// its org and its own name separated by a slash, since space names are only unique within an org.
+const spaceNameRegex = `(?P<name>\w(([\w-.]+)?\w)?(/\w(([\w-.]+)?\w)?)?)`
+
+func (b *backend) pathListMaps() *framework.Path {
+	return &framework.Path{
+		Pattern: "map/(?P<kind>" + mapKindOrgs + "|" + mapKindSpaces + ")/?$",
+		Fields: map[string]*framework.FieldSchema{
+			"kind": {
+				Type:        framework.TypeString,
+				Required:    true,
+				Description: `The kind of mapping: "orgs" or "spaces".`,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.operationMapsList,
+				Summary:  "List the orgs or spaces that policies are mapped to.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "The GUIDs and names of the orgs or spaces that policies are mapped to.",
+						Example:     logical.ListResponse([]string{"my-org/my-space", "3d2eba6b-ef19-44d5-91dd-1975b0db5cc9"}),
+					}},
+				},
+			},
+		},
+		HelpSynopsis:    pathMapHelpSyn,
+		HelpDescription: pathMapHelpDesc,
+	}
+}
+
+func (b *backend) pathMaps() []*framework.Path {
+	return []*framework.Path{
+		b.pathMap(mapKindOrgs, framework.GenericNameRegex("name"), "The GUID or name of the org."),
+		b.pathMap(mapKindSpaces, spaceNameRegex, `The GUID of the space, or the name of its org and its own name, as "<org>/<space>".`),
+	}
+}
+
+func (b *backend) pathMap(kind, namePattern, nameDescription string) *framework.Path {
+	return &framework.Path{
+		Pattern: "map/" + kind + "/" + namePattern,
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Required:    true,
+				Description: nameDescription,
+			},
+			"policies": {
+				Type: framework.TypeCommaStringSlice,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:  "Policies",
+					Value: "default",
+				},
+				Description: "The policies added to the tokens of instances that log in from it, on top of the role's.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.operationMapUpdate(kind),
+				Summary:  "Map policies to the " + kind[:len(kind)-1] + ".",
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.operationMapRead(kind),
+				Summary:  "Read the policies mapped to the " + kind[:len(kind)-1] + ".",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "The mapped policies.",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"policies": []string{"team-secrets"},
+							},
+						},
+					}},
+					http.StatusNoContent: {{
+						Description: "No policies are mapped to it.",
+					}},
+				},
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.operationMapDelete(kind),
+				Summary:  "Remove the policies mapped to the " + kind[:len(kind)-1] + ".",
+			},
+		},
+		HelpSynopsis:    pathMapHelpSyn,
+		HelpDescription: pathMapHelpDesc,
+	}
+}
+
+func (b *backend) operationMapsList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, mapStoragePrefix+data.Get("kind").(string)+"/")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name, err := url.PathUnescape(entry)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return logical.ListResponse(names), nil
+}
+
+func (b *backend) operationMapUpdate(kind string) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		mapping := &models.PolicyMapping{
+			Policies: policyutil.SanitizePolicies(data.Get("policies").([]string), false),
+		}
+		entry, err := logical.StorageEntryJSON(mapStorageKey(kind, data.Get("name").(string)), mapping)
+		if err != nil {
+			return nil, err
+		}
+		return nil, req.Storage.Put(ctx, entry)
+	}
+}
+
+func (b *backend) operationMapRead(kind string) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		mapping, err := getPolicyMapping(ctx, req.Storage, kind, data.Get("name").(string))
+		if err != nil {
+			return nil, err
+		}
+		if mapping == nil {
+			return nil, nil
+		}
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"policies": mapping.Policies,
+			},
+		}, nil
+	}
+}
+
+func (b *backend) operationMapDelete(kind string) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		return nil, req.Storage.Delete(ctx, mapStorageKey(kind, data.Get("name").(string)))
+	}
+}
+
+// mapStorageKey returns where the mapping of the given kind and name is stored. The slash in the names
+// of space mappings is escaped so that every mapping of a kind can be listed together.
+func mapStorageKey(kind, name string) string {
+	return mapStoragePrefix + kind + "/" + url.PathEscape(name)
+}
+
+func getPolicyMapping(ctx context.Context, storage logical.Storage, kind, name string) (*models.PolicyMapping, error) {
+	entry, err := storage.Get(ctx, mapStorageKey(kind, name))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	mapping := &models.PolicyMapping{}
+	if err := entry.DecodeJSON(mapping); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// mappedPolicies returns the policies mapped to the org and space of the instance that logged in, by
+// their GUIDs and their names.
+func mappedPolicies(ctx context.Context, storage logical.Storage, cfCert *models.CFCertificate, resources *cfResources) ([]string, error) {
+	spaceName := ""
+	if resources.Org.Name != "" && resources.Space.Name != "" {
+		spaceName = resources.Org.Name + "/" + resources.Space.Name
+	}
+	var policies []string
+	for _, key := range []struct {
+		kind, name string
+	}{
+		{mapKindOrgs, cfCert.OrgID},
+		{mapKindOrgs, resources.Org.Name},
+		{mapKindSpaces, cfCert.SpaceID},
+		{mapKindSpaces, spaceName},
+	} {
+		// The names are missing if the records weren't fetched.
+		if key.name == "" {
+			continue
+		}
+		mapping, err := getPolicyMapping(ctx, storage, key.kind, key.name)
+		if err != nil {
+			return nil, err
+		}
+		if mapping != nil {
+			policies = append(policies, mapping.Policies...)
+		}
+	}
+	return policies, nil
+}
+
+const pathMapHelpSyn = `
+Map additional policies to every instance in an org or space.
+`
+
+const pathMapHelpDesc = `
+The policies mapped to an instance's org and space are added to its token at
+login, on top of those of the role it logs in with, so that baseline policies
+can be granted to a whole org or team without a role for each. Orgs may be
+mapped by GUID or name. Spaces may be mapped by GUID, or by the name of their
+org and their own name as "<org>/<space>", since space names are only unique
+within an org. Like the role's, the policies are fixed when the token is issued.
+`
@@ -0,0 +1,52 @@
+package cf
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPolicyMappings(t *testing.T) {
+	b := newTestBackend(t)
+	ctx, storage := b.ctx, b.storage
+
+	b.mustHandle(logical.UpdateOperation, "map/orgs/org-id", map[string]interface{}{"policies": "org-secrets,default"})
+	b.mustHandle(logical.UpdateOperation, "map/orgs/my-org", map[string]interface{}{"policies": "org-names"})
+	b.mustHandle(logical.UpdateOperation, "map/spaces/my-org/my-space", map[string]interface{}{"policies": "team-secrets"})
+	b.mustHandle(logical.UpdateOperation, "map/spaces/other-org/my-space", map[string]interface{}{"policies": "other-team-secrets"})
+
+	if policies := b.mustHandle(logical.ReadOperation, "map/orgs/org-id", nil).Data["policies"]; fmt.Sprint(policies) != "[default org-secrets]" {
+		t.Fatalf("expected the mapped policies but received %v", policies)
+	}
+	if keys := b.mustHandle(logical.ListOperation, "map/spaces/", nil).Data["keys"]; fmt.Sprint(keys) != "[my-org/my-space other-org/my-space]" {
+		t.Fatalf("expected both spaces but received %v", keys)
+	}
+
+	// Spaces are matched by the name of their org as well as their own.
+	cfCert, err := models.NewCFCertificate("instance-id", "org-id", "space-id", "app-id", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources := &cfResources{
+		Org:   cfclient.Org{Name: "my-org"},
+		Space: cfclient.Space{Name: "my-space"},
+	}
+	policies, err := mappedPolicies(ctx, storage, cfCert, resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(policies) != "[default org-secrets org-names team-secrets]" {
+		t.Fatalf("unexpected policies %v", policies)
+	}
+
+	b.mustHandle(logical.DeleteOperation, "map/orgs/org-id", nil)
+	if resp := b.mustHandle(logical.ReadOperation, "map/orgs/org-id", nil); resp != nil {
+		t.Fatalf("expected the mapping to be deleted but received %#v", resp)
+	}
+	if policies, err = mappedPolicies(ctx, storage, cfCert, &cfResources{}); err != nil || len(policies) != 0 {
+		t.Fatalf("expected no policies without the names of the org and space but received %v, %v", policies, err)
+	}
+}
@@ -0,0 +1,176 @@
+package cf
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const renewalCertStoragePrefix = "renewal_certs/"
+
+func (b *backend) pathRenewWithCert() *framework.Path {
+	return &framework.Path{
+		Pattern: "renew-with-cert",
+		Fields:  loginFields(),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.operationRenewWithCertUpdate,
+				Summary:  "Present a current instance identity certificate for the calling token, so it can be renewed past the expiry of the certificate used to log in.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "The certificate passed every login check, and the token can be renewed until it expires.",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"role":           "test-role",
+								"instance_id":    "f9c7cd7d-1612-4f57-63a8-f995",
+								"cert_not_after": "2019-04-29T04:30:00Z",
+							},
+						},
+					}},
+					http.StatusBadRequest: {{
+						Description: `The certificate failed a login check. The error is of the same form as a failed login's.`,
+					}},
+				},
+			},
+		},
+		HelpSynopsis:    pathRenewWithCertSyn,
+		HelpDescription: pathRenewWithCertDesc,
+	}
+}
+
+// operationRenewWithCertUpdate runs the same checks as logging in, and if they pass, records the
+// certificate's expiry against the calling token's accessor. The token isn't renewed here, since
+// only Vault can renew it; a renewal that follows consults the record once the certificate the
+// token was issued with has expired.
+func (b *backend) operationRenewWithCertUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	timeReceived := time.Now().UTC()
+
+	if req.ClientTokenAccessor == "" {
+		return logical.ErrorResponse("the token to renew must be used to present the certificate"), nil
+	}
+
+	config, err := config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, errors.New("no CA is configured for verifying client certificates")
+	}
+
+	auth, err := b.attemptLogin(ctx, req, data, config, timeReceived, nil)
+	if err != nil {
+		if failure, ok := err.(*loginFailure); ok {
+			return b.loginFailureResponse(req, config, failure)
+		}
+		return nil, err
+	}
+
+	certNotAfter, err := time.Parse(time.RFC3339, auth.InternalData["cert_not_after"].(string))
+	if err != nil {
+		return nil, err
+	}
+	renewalCert := &models.RenewalCertEntry{
+		Role:         auth.InternalData["role"].(string),
+		InstanceID:   auth.InternalData["instance_id"].(string),
+		IPAddress:    auth.InternalData["ip_address"].(string),
+		AppID:        auth.Alias.Metadata["app_id"],
+		CertNotAfter: certNotAfter,
+	}
+	if err := putRenewalCertEntry(ctx, req.Storage, req.ClientTokenAccessor, renewalCert); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"role":           renewalCert.Role,
+			"instance_id":    renewalCert.InstanceID,
+			"cert_not_after": renewalCert.CertNotAfter.Format(time.RFC3339),
+		},
+	}, nil
+}
+
+func putRenewalCertEntry(ctx context.Context, storage logical.Storage, accessor string, renewalCert *models.RenewalCertEntry) error {
+	entry, err := logical.StorageEntryJSON(renewalCertStoragePrefix+accessor, renewalCert)
+	if err != nil {
+		return err
+	}
+	return storage.Put(ctx, entry)
+}
+
+func getRenewalCertEntry(ctx context.Context, storage logical.Storage, accessor string) (*models.RenewalCertEntry, error) {
+	entry, err := storage.Get(ctx, renewalCertStoragePrefix+accessor)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	renewalCert := &models.RenewalCertEntry{}
+	if err := entry.DecodeJSON(renewalCert); err != nil {
+		return nil, err
+	}
+	return renewalCert, nil
+}
+
+// renewalCertNotAfter returns when the most recent certificate presented for the token being
+// renewed expires, or the zero time if none was presented, or the one presented was for another
+// instance or role.
+func renewalCertNotAfter(ctx context.Context, req *logical.Request) (time.Time, error) {
+	if req.Auth.Accessor == "" {
+		return time.Time{}, nil
+	}
+	renewalCert, err := getRenewalCertEntry(ctx, req.Storage, req.Auth.Accessor)
+	if err != nil || renewalCert == nil {
+		return time.Time{}, err
+	}
+	roleName, _ := req.Auth.InternalData["role"].(string)
+	instanceID, _ := req.Auth.InternalData["instance_id"].(string)
+	ipAddr, _ := req.Auth.InternalData["ip_address"].(string)
+	if renewalCert.Role != roleName || renewalCert.InstanceID != instanceID || renewalCert.IPAddress != ipAddr || renewalCert.AppID != req.Auth.Alias.Metadata["app_id"] {
+		return time.Time{}, nil
+	}
+	return renewalCert.CertNotAfter, nil
+}
+
+// tidyRenewalCerts removes the presented certificates that expired before the cutoff, since
+// they can no longer extend a token's life. It returns how many were removed.
+func tidyRenewalCerts(ctx context.Context, storage logical.Storage, cutoff time.Time) (int, error) {
+	accessors, err := storage.List(ctx, renewalCertStoragePrefix)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, accessor := range accessors {
+		renewalCert, err := getRenewalCertEntry(ctx, storage, accessor)
+		if err != nil {
+			return removed, err
+		}
+		if renewalCert == nil || !renewalCert.CertNotAfter.Before(cutoff) {
+			continue
+		}
+		if err := storage.Delete(ctx, renewalCertStoragePrefix+accessor); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+const pathRenewWithCertSyn = `
+Present a current instance identity certificate so the calling token can be renewed past the expiry of the one used to log in.
+`
+
+const pathRenewWithCertDesc = `
+Accepts the same fields as logging in, and runs the same checks, but must be
+called with the token to be renewed rather than without one. Rather than
+issuing a new token, the expiry of the presented certificate is recorded
+against the calling token's accessor. Once the certificate the token was
+issued with has expired, renewing the token checks the recorded certificate's
+expiry instead, so long as it was presented for the same role, instance, and
+app. The token keeps its accessor and entity alias, so instances can keep
+renewing it as their certificate is rotated rather than logging in again.
+`
@@ -3,21 +3,48 @@ package cf
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"sort"
+	"time"
 
 	"github.com/hashicorp/vault-plugin-auth-cf/models"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/helper/tokenutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
 const roleStoragePrefix = "roles/"
 
+// These are the values accepted for "ip_matching_source".
+const (
+	ipMatchingSourceRemoteAddr = "remote_addr"
+	ipMatchingSourceCFAPI      = "cf_api"
+)
+
 func (b *backend) pathListRoles() *framework.Path {
 	return &framework.Path{
 		Pattern: "roles/?$",
+		Fields: map[string]*framework.FieldSchema{
+			"after": {
+				Type:        framework.TypeString,
+				Description: "Only list the roles whose names sort after this one, such as the last name of the previous page.",
+			},
+			"limit": {
+				Type:        framework.TypeInt,
+				Description: "The most role names to list. If 0, the default, every remaining role is listed.",
+			},
+		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ListOperation: &framework.PathOperation{
 				Callback: b.operationRolesList,
+				Summary:  "List the names of the roles.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "The names of the roles.",
+						Example:     logical.ListResponse([]string{"test-role"}),
+					}},
+				},
 			},
 		},
 		HelpSynopsis:    pathListRolesHelpSyn,
@@ -25,12 +52,30 @@ func (b *backend) pathListRoles() *framework.Path {
 	}
 }
 
-func (b *backend) operationRolesList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+func (b *backend) operationRolesList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	after := data.Get("after").(string)
+	limit := data.Get("limit").(int)
+	if limit < 0 {
+		return logical.ErrorResponse("'limit' must not be negative"), nil
+	}
 	entries, err := req.Storage.List(ctx, roleStoragePrefix)
 	if err != nil {
 		return nil, err
 	}
-	return logical.ListResponse(entries), nil
+	return logical.ListResponse(page(entries, after, limit)), nil
+}
+
+// page returns up to limit of the keys that sort after the given one, in order. If limit is 0,
+// every key after it is returned. Storage doesn't promise to list keys in order, so they're sorted
+// first, so that pages neither overlap nor skip keys.
+func page(keys []string, after string, limit int) []string {
+	sort.Strings(keys)
+	start := sort.Search(len(keys), func(i int) bool { return keys[i] > after })
+	keys = keys[start:]
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys
 }
 
 func (b *backend) pathRoles() *framework.Path {
@@ -83,6 +128,172 @@ func (b *backend) pathRoles() *framework.Path {
 				},
 				Description: `If set to true, disables the default behavior that logging in must be performed from 
 an acceptable IP address described by the certificate presented.`,
+			},
+			"disable_cf_api_renewal_check": {
+				Type:    framework.TypeBool,
+				Default: false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:  "Disable CF API Check on Renewal",
+					Value: "false",
+				},
+				Description: `If set to true, token renewals only re-check the role's bound constraints and the 
+caller's IP address, rather than also confirming through the CF API that the app, space, and org still exist.`,
+			},
+			"disable_cert_expiry_renewal_check": {
+				Type:    framework.TypeBool,
+				Default: false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:  "Disable Certificate Expiry Check on Renewal",
+					Value: "false",
+				},
+				Description: `If set to true, tokens may be renewed after the instance identity certificate used 
+to log in has expired.`,
+			},
+			"allow_service_instance_login": {
+				Type:    framework.TypeBool,
+				Default: false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:  "Allow Service Instance Login",
+					Value: "false",
+				},
+				Description: `If set to true, certificates issued to service instances, which have no app, may 
+log in. Their instance ID is checked against the CF API as a service instance. Roles allowing this can't 
+set "bound_application_ids".`,
+			},
+			"bound_service_instance_names": {
+				Type: framework.TypeCommaStringSlice,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:  "Bound Service Instance Names",
+					Value: "my-service",
+				},
+				Description: `Require that the certificate presented was issued to a service instance with at least one
+of these names, as reported by the CF API. Unlike instance IDs, names are stable across foundations. Can only be
+set when "allow_service_instance_login" is true.`,
+			},
+			"bound_buildpacks": {
+				Type: framework.TypeCommaStringSlice,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:  "Bound Buildpacks",
+					Value: "java_buildpack_offline",
+				},
+				Description: `Require that the app has been staged with at least one of these buildpacks, as reported
+by the CF API. Either the buildpack the app was pushed with or the one detected for it may match, by name or URL.`,
+			},
+			"bound_stacks": {
+				Type: framework.TypeCommaStringSlice,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:  "Bound Stacks",
+					Value: "cflinuxfs3",
+				},
+				Description: "Require that the app runs on one of these stacks, by name, as reported by the CF API.",
+			},
+			"bound_isolation_segments": {
+				Type: framework.TypeCommaStringSlice,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:  "Bound Isolation Segments",
+					Value: "regulated",
+				},
+				Description: `Require that the app runs in one of these isolation segments, by name, as reported by the
+CF API. An app runs in its space's isolation segment, or its org's default one if the space has none, or otherwise in
+the one named "shared".`,
+			},
+			"ip_matching_source": {
+				Type:          framework.TypeString,
+				Default:       ipMatchingSourceRemoteAddr,
+				AllowedValues: []interface{}{ipMatchingSourceRemoteAddr, ipMatchingSourceCFAPI},
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:  "IP Matching Source",
+					Value: ipMatchingSourceRemoteAddr,
+				},
+				Description: `What the IP address in the certificate presented is matched against. If "remote_addr", it
+must be the caller's address. If "cf_api", for container networks where the caller's address never is, it must
+instead be the internal IP of one of the app's running instances, as reported by the CF API, and
+"disable_cf_api_renewal_check" can't be set. Defaults to "remote_addr".`,
+			},
+			"require_instance_ip_match": {
+				Type:    framework.TypeBool,
+				Default: false,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:  "Require Instance IP Match",
+					Value: "false",
+				},
+				Description: `If set to true, the IP address in the certificate presented must be the internal IP of
+one of the app's running instances, as reported by the CF API. Certificates issued to tasks can't pass this check.`,
+			},
+			"response_wrap_ttl": {
+				Type: framework.TypeDurationSecond,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name: "Response Wrap TTL",
+				},
+				Description: `If set, login responses are always response-wrapped, with a wrapping token that has this
+TTL in seconds, so the token can only be unwrapped once.`,
+			},
+			"instance_bound_cidr_prefix_length": {
+				Type: framework.TypeInt,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:  "Instance Bound CIDR Prefix Length",
+					Value: "32",
+				},
+				Description: `If set and "token_bound_cidrs" isn't, tokens are bound to the network of this prefix
+length that contains the IP address in the certificate presented, such as 32 to bind them to the instance's
+container alone.`,
+			},
+			"metadata_annotation_keys": {
+				Type: framework.TypeCommaStringSlice,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:  "Metadata Annotation Keys",
+					Value: "example.com/team",
+				},
+				Description: `The keys of the app or service instance's CF annotations to copy into the metadata
+of tokens at login, as "annotation_<key>". Annotations the app doesn't have are left out.`,
+			},
+			"metadata_label_keys": {
+				Type: framework.TypeCommaStringSlice,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:  "Metadata Label Keys",
+					Value: "environment",
+				},
+				Description: `The keys of the app or service instance's CF labels to copy into the metadata of
+tokens at login, as "label_<key>". Labels the app doesn't have are left out.`,
+			},
+			"token_quota": {
+				Type: framework.TypeInt,
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:  "Token Quota",
+					Value: "20",
+				},
+				Description: `If set, the most tokens that may be counted at once for each app, or for each instance if
+"token_quota_scope" is "instance_id". Logins beyond it are handled as "token_quota_action" says. A token is
+counted until its TTL runs out without it being renewed, even if it's revoked through Vault.`,
+			},
+			"token_quota_scope": {
+				Type:          framework.TypeString,
+				Default:       tokenQuotaScopeAppID,
+				AllowedValues: []interface{}{tokenQuotaScopeAppID, tokenQuotaScopeInstanceID},
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:  "Token Quota Scope",
+					Value: tokenQuotaScopeAppID,
+				},
+				Description: `What "token_quota" counts tokens by: "app_id" or "instance_id". Service instances are
+always counted by their own GUID. Defaults to "app_id".`,
+			},
+			"token_quota_action": {
+				Type:          framework.TypeString,
+				Default:       tokenQuotaActionReject,
+				AllowedValues: []interface{}{tokenQuotaActionReject, tokenQuotaActionDenyOldestRenewal},
+				DisplayAttrs: &framework.DisplayAttributes{
+					Name:  "Token Quota Action",
+					Value: tokenQuotaActionReject,
+				},
+				Description: `What's done when a login would exceed "token_quota": "reject" refuses the login, and
+"deny_oldest_renewal" issues the token and refuses the next renewal of the oldest counted token, which stays
+valid until then, since plugins can't revoke tokens. Defaults to "reject".`,
+			},
+			"cas": {
+				Type: framework.TypeInt,
+				Description: `If set, the write only succeeds if the role's current revision, as returned when it's
+read, is this. It's 0 if the role doesn't exist, so that the write only creates it. If not set, the write is
+always allowed.`,
 			},
 			"policies": &framework.FieldSchema{
 				Type:        framework.TypeCommaStringSlice,
@@ -114,15 +325,40 @@ an acceptable IP address described by the certificate presented.`,
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.CreateOperation: &framework.PathOperation{
 				Callback: b.operationRolesCreateUpdate,
+				Summary:  "Create a role that instances meeting its constraints may log in with.",
 			},
 			logical.UpdateOperation: &framework.PathOperation{
 				Callback: b.operationRolesCreateUpdate,
+				Summary:  "Update a role. Fields that aren't given are left as they were.",
 			},
 			logical.ReadOperation: &framework.PathOperation{
 				Callback: b.operationRolesRead,
+				Summary:  "Read a role.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "The role, including its token settings.",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"bound_application_ids":        []string{"2d3e834a-3a25-4591-974c-fa5626d5d0a1"},
+								"bound_space_ids":              []string{"3d2eba6b-ef19-44d5-91dd-1975b0db5cc9"},
+								"bound_organization_ids":       []string{"34a878d0-c2f9-4521-ba73-a9f664e82c7bf"},
+								"bound_instance_ids":           []string{},
+								"disable_ip_matching":          false,
+								"allow_service_instance_login": false,
+								"token_policies":               []string{"foo-policies"},
+								"token_ttl":                    86400,
+								"token_max_ttl":                172800,
+							},
+						},
+					}},
+					http.StatusNoContent: {{
+						Description: "The role doesn't exist.",
+					}},
+				},
 			},
 			logical.DeleteOperation: &framework.PathOperation{
 				Callback: b.operationRolesDelete,
+				Summary:  "Delete a role. Tokens already issued with it can no longer be renewed.",
 			},
 		},
 		HelpSynopsis:    pathRolesHelpSyn,
@@ -144,15 +380,25 @@ func (b *backend) operationRolesExistenceCheck(ctx context.Context, req *logical
 func (b *backend) operationRolesCreateUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	roleName := data.Get("role").(string)
 
+	lock := locksutil.LockForKey(b.roleLocks, roleName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	storedRole, err := getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	revision := 0
+	if storedRole != nil {
+		revision = storedRole.Revision
+	}
+	if raw, ok := data.GetOk("cas"); ok && raw.(int) != revision {
+		return logical.ErrorResponse(fmt.Sprintf("'cas' is %d but the role is at revision %d; it's been changed since it was read", raw.(int), revision)), nil
+	}
+
 	role := &models.RoleEntry{}
-	if req.Operation == logical.UpdateOperation {
-		storedRole, err := getRole(ctx, req.Storage, roleName)
-		if err != nil {
-			return nil, err
-		}
-		if storedRole != nil {
-			role = storedRole
-		}
+	if req.Operation == logical.UpdateOperation && storedRole != nil {
+		role = storedRole
 	}
 	if raw, ok := data.GetOk("bound_application_ids"); ok {
 		role.BoundAppIDs = raw.([]string)
@@ -169,6 +415,108 @@ func (b *backend) operationRolesCreateUpdate(ctx context.Context, req *logical.R
 	if raw, ok := data.GetOk("disable_ip_matching"); ok {
 		role.DisableIPMatching = raw.(bool)
 	}
+	if raw, ok := data.GetOk("disable_cf_api_renewal_check"); ok {
+		role.DisableCFAPIRenewalCheck = raw.(bool)
+	}
+	if raw, ok := data.GetOk("disable_cert_expiry_renewal_check"); ok {
+		role.DisableCertExpiryRenewalCheck = raw.(bool)
+	}
+	if raw, ok := data.GetOk("allow_service_instance_login"); ok {
+		role.AllowServiceInstanceLogin = raw.(bool)
+	}
+	if raw, ok := data.GetOk("bound_buildpacks"); ok {
+		role.BoundBuildpacks = raw.([]string)
+	}
+	if raw, ok := data.GetOk("bound_service_instance_names"); ok {
+		role.BoundServiceInstanceNames = raw.([]string)
+	}
+	if raw, ok := data.GetOk("bound_stacks"); ok {
+		role.BoundStacks = raw.([]string)
+	}
+	if raw, ok := data.GetOk("bound_isolation_segments"); ok {
+		role.BoundIsolationSegments = raw.([]string)
+	}
+	if raw, ok := data.GetOk("require_instance_ip_match"); ok {
+		role.RequireInstanceIPMatch = raw.(bool)
+	}
+	if raw, ok := data.GetOk("ip_matching_source"); ok {
+		role.IPMatchingSource = raw.(string)
+	}
+	if raw, ok := data.GetOk("response_wrap_ttl"); ok {
+		role.ResponseWrapTTL = time.Duration(raw.(int)) * time.Second
+	}
+	if raw, ok := data.GetOk("instance_bound_cidr_prefix_length"); ok {
+		role.InstanceBoundCIDRPrefixLength = raw.(int)
+	}
+	if raw, ok := data.GetOk("metadata_annotation_keys"); ok {
+		role.MetadataAnnotationKeys = raw.([]string)
+	}
+	if raw, ok := data.GetOk("metadata_label_keys"); ok {
+		role.MetadataLabelKeys = raw.([]string)
+	}
+	if raw, ok := data.GetOk("token_quota"); ok {
+		role.TokenQuota = raw.(int)
+	}
+	if raw, ok := data.GetOk("token_quota_scope"); ok {
+		role.TokenQuotaScope = raw.(string)
+	}
+	if raw, ok := data.GetOk("token_quota_action"); ok {
+		role.TokenQuotaAction = raw.(string)
+	}
+	if role.ResponseWrapTTL < 0 {
+		return logical.ErrorResponse("'response_wrap_ttl' must not be negative"), nil
+	}
+	if role.InstanceBoundCIDRPrefixLength < 0 || role.InstanceBoundCIDRPrefixLength > 128 {
+		return logical.ErrorResponse("'instance_bound_cidr_prefix_length' must be between 0 and 128"), nil
+	}
+	if role.TokenQuota < 0 {
+		return logical.ErrorResponse("'token_quota' must not be negative"), nil
+	}
+	switch role.TokenQuotaScope {
+	case "", tokenQuotaScopeAppID, tokenQuotaScopeInstanceID:
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("%q is not a valid 'token_quota_scope'", role.TokenQuotaScope)), nil
+	}
+	switch role.TokenQuotaAction {
+	case "", tokenQuotaActionReject, tokenQuotaActionDenyOldestRenewal:
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("%q is not a valid 'token_quota_action'", role.TokenQuotaAction)), nil
+	}
+	if role.AllowServiceInstanceLogin && len(role.BoundAppIDs) > 0 {
+		return logical.ErrorResponse("'bound_application_ids' can't be set when 'allow_service_instance_login' is true"), nil
+	}
+	if !role.AllowServiceInstanceLogin && len(role.BoundServiceInstanceNames) > 0 {
+		return logical.ErrorResponse("'bound_service_instance_names' can only be set when 'allow_service_instance_login' is true"), nil
+	}
+	// Service instances have no app to have been built with a buildpack, to run on a stack or in an isolation
+	// segment, or to have instances.
+	if role.AllowServiceInstanceLogin && (len(role.BoundBuildpacks) > 0 || len(role.BoundStacks) > 0 || len(role.BoundIsolationSegments) > 0 || role.RequireInstanceIPMatch) {
+		return logical.ErrorResponse("'bound_buildpacks', 'bound_stacks', 'bound_isolation_segments', and 'require_instance_ip_match' can't be set when 'allow_service_instance_login' is true"), nil
+	}
+	switch role.IPMatchingSource {
+	case "", ipMatchingSourceRemoteAddr:
+	case ipMatchingSourceCFAPI:
+		if role.DisableIPMatching {
+			return logical.ErrorResponse("'ip_matching_source' can't be \"cf_api\" when 'disable_ip_matching' is true"), nil
+		}
+		if role.AllowServiceInstanceLogin {
+			return logical.ErrorResponse("'ip_matching_source' can't be \"cf_api\" when 'allow_service_instance_login' is true"), nil
+		}
+		// Renewals that skip the CF API would otherwise not match the IP address against anything.
+		if role.DisableCFAPIRenewalCheck {
+			return logical.ErrorResponse("'ip_matching_source' can't be \"cf_api\" when 'disable_cf_api_renewal_check' is true"), nil
+		}
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("%q is not a valid 'ip_matching_source'", role.IPMatchingSource)), nil
+	}
+
+	config, err := config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil && config.RequireBoundConstraints && !role.HasBoundConstraints() {
+		return logical.ErrorResponse("the config requires roles to set at least one of 'bound_application_ids', 'bound_space_ids', 'bound_organization_ids', or 'bound_instance_ids'"), nil
+	}
 
 	if err := role.ParseTokenFields(req, data); err != nil {
 		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
@@ -201,6 +549,7 @@ func (b *backend) operationRolesCreateUpdate(ctx context.Context, req *logical.R
 		return logical.ErrorResponse("ttl exceeds max ttl"), nil
 	}
 
+	role.Revision = revision + 1
 	entry, err := logical.StorageEntryJSON(roleStoragePrefix+roleName, role)
 	if err != nil {
 		return nil, err
@@ -232,11 +581,28 @@ func (b *backend) operationRolesRead(ctx context.Context, req *logical.Request,
 	}
 
 	d := map[string]interface{}{
-		"bound_application_ids":  role.BoundAppIDs,
-		"bound_space_ids":        role.BoundSpaceIDs,
-		"bound_organization_ids": role.BoundOrgIDs,
-		"bound_instance_ids":     role.BoundInstanceIDs,
-		"disable_ip_matching":    role.DisableIPMatching,
+		"bound_application_ids":             role.BoundAppIDs,
+		"bound_space_ids":                   role.BoundSpaceIDs,
+		"bound_organization_ids":            role.BoundOrgIDs,
+		"bound_instance_ids":                role.BoundInstanceIDs,
+		"disable_ip_matching":               role.DisableIPMatching,
+		"disable_cf_api_renewal_check":      role.DisableCFAPIRenewalCheck,
+		"disable_cert_expiry_renewal_check": role.DisableCertExpiryRenewalCheck,
+		"allow_service_instance_login":      role.AllowServiceInstanceLogin,
+		"bound_service_instance_names":      role.BoundServiceInstanceNames,
+		"bound_buildpacks":                  role.BoundBuildpacks,
+		"bound_stacks":                      role.BoundStacks,
+		"bound_isolation_segments":          role.BoundIsolationSegments,
+		"require_instance_ip_match":         role.RequireInstanceIPMatch,
+		"ip_matching_source":                ipMatchingSource(role),
+		"response_wrap_ttl":                 role.ResponseWrapTTL / time.Second,
+		"instance_bound_cidr_prefix_length": role.InstanceBoundCIDRPrefixLength,
+		"metadata_annotation_keys":          role.MetadataAnnotationKeys,
+		"metadata_label_keys":               role.MetadataLabelKeys,
+		"token_quota":                       role.TokenQuota,
+		"token_quota_scope":                 tokenQuotaScope(role),
+		"token_quota_action":                tokenQuotaAction(role),
+		"revision":                          role.Revision,
 	}
 
 	role.PopulateTokenData(d)
@@ -244,9 +610,9 @@ func (b *backend) operationRolesRead(ctx context.Context, req *logical.Request,
 	if len(role.Policies) > 0 {
 		d["policies"] = d["token_policies"]
 	}
-	if len(role.BoundCIDRs) > 0 {
-		d["bound_cidrs"] = d["token_bound_cidrs"]
-	}
+	// Automation built before token_bound_cidrs existed may still read bound_cidrs, so it's
+	// always returned until it's removed.
+	d["bound_cidrs"] = d["token_bound_cidrs"]
 	if role.TTL > 0 {
 		d["ttl"] = int64(role.TTL.Seconds())
 	}
@@ -264,12 +630,37 @@ func (b *backend) operationRolesRead(ctx context.Context, req *logical.Request,
 
 func (b *backend) operationRolesDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	roleName := data.Get("role").(string)
+
+	// Otherwise a write that began before the role was deleted could recreate it.
+	lock := locksutil.LockForKey(b.roleLocks, roleName)
+	lock.Lock()
+	defer lock.Unlock()
+
 	if err := req.Storage.Delete(ctx, roleStoragePrefix+roleName); err != nil {
 		return nil, err
 	}
 	return nil, nil
 }
 
+// unboundRoles returns the names of the roles that aren't bound to any app, space, org, or instance ID.
+func unboundRoles(ctx context.Context, storage logical.Storage) ([]string, error) {
+	roleNames, err := storage.List(ctx, roleStoragePrefix)
+	if err != nil {
+		return nil, err
+	}
+	var unbound []string
+	for _, roleName := range roleNames {
+		role, err := getRole(ctx, storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil && !role.HasBoundConstraints() {
+			unbound = append(unbound, roleName)
+		}
+	}
+	return unbound, nil
+}
+
 func getRole(ctx context.Context, storage logical.Storage, roleName string) (*models.RoleEntry, error) {
 	role := &models.RoleEntry{}
 	entry, err := storage.Get(ctx, roleStoragePrefix+roleName)
@@ -304,7 +695,10 @@ func getRole(ctx context.Context, storage logical.Storage, roleName string) (*mo
 
 const pathListRolesHelpSyn = "List the existing roles in this backend."
 
-const pathListRolesHelpDesc = "Roles will be listed by the role name."
+const pathListRolesHelpDesc = `
+Roles will be listed by the role name, in order. To page through them, give a
+"limit", and the last name listed as "after" to list the next page.
+`
 
 const pathRolesHelpSyn = `
 Read, write and reference policies and roles that tokens can be made for.
@@ -0,0 +1,165 @@
+package cf
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-sockaddr"
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault-plugin-auth-cf/testing/cf"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestRoleBoundCIDRsCompatibility(t *testing.T) {
+	b := newTestBackend(t)
+	ctx, storage := b.ctx, b.storage
+
+	// Roles written with the standard field return it under both names.
+	b.mustHandle(logical.CreateOperation, "roles/test-role", map[string]interface{}{"token_bound_cidrs": "10.0.0.0/24"})
+	data := b.mustHandle(logical.ReadOperation, "roles/test-role", nil).Data
+	for _, key := range []string{"bound_cidrs", "token_bound_cidrs"} {
+		if fmt.Sprint(data[key]) != "[10.0.0.0/24]" {
+			t.Fatalf("expected %s of [10.0.0.0/24] but received %v", key, data[key])
+		}
+	}
+
+	// So do roles stored with only the deprecated field.
+	cidr, err := sockaddr.NewSockAddr("192.168.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := logical.StorageEntryJSON(roleStoragePrefix+"test-role", &models.RoleEntry{
+		BoundCIDRs: []*sockaddr.SockAddrMarshaler{{SockAddr: cidr}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+	data = b.mustHandle(logical.ReadOperation, "roles/test-role", nil).Data
+	for _, key := range []string{"bound_cidrs", "token_bound_cidrs"} {
+		if fmt.Sprint(data[key]) != "[192.168.0.0/16]" {
+			t.Fatalf("expected %s of [192.168.0.0/16] but received %v", key, data[key])
+		}
+	}
+
+	// Writing the standard field to such a role updates both.
+	b.mustHandle(logical.UpdateOperation, "roles/test-role", map[string]interface{}{"token_bound_cidrs": "10.0.0.0/24"})
+	data = b.mustHandle(logical.ReadOperation, "roles/test-role", nil).Data
+	for _, key := range []string{"bound_cidrs", "token_bound_cidrs"} {
+		if fmt.Sprint(data[key]) != "[10.0.0.0/24]" {
+			t.Fatalf("expected %s of [10.0.0.0/24] but received %v", key, data[key])
+		}
+	}
+}
+
+func TestListRolesPaginated(t *testing.T) {
+	b := newTestBackend(t)
+	for _, roleName := range []string{"space-c", "space-a", "space-b", "space-d"} {
+		b.mustHandle(logical.CreateOperation, "roles/"+roleName, nil)
+	}
+	list := func(data map[string]interface{}) string {
+		return fmt.Sprint(b.mustHandle(logical.ListOperation, "roles", data).Data["keys"])
+	}
+
+	if keys := list(nil); keys != "[space-a space-b space-c space-d]" {
+		t.Fatalf("expected every role but received %s", keys)
+	}
+	if keys := list(map[string]interface{}{"limit": 3}); keys != "[space-a space-b space-c]" {
+		t.Fatalf("expected the first page but received %s", keys)
+	}
+	if keys := list(map[string]interface{}{"after": "space-c", "limit": 3}); keys != "[space-d]" {
+		t.Fatalf("expected the last page but received %s", keys)
+	}
+	if keys := list(map[string]interface{}{"after": "space-bb"}); keys != "[space-c space-d]" {
+		t.Fatalf("expected the roles after space-bb but received %s", keys)
+	}
+
+	if resp := b.handle(logical.ListOperation, "roles", map[string]interface{}{"limit": -1}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected a negative limit to be refused but received %#v", resp)
+	}
+}
+
+func TestRoleCheckAndSet(t *testing.T) {
+	b := newTestBackend(t)
+
+	// A cas of 0 only creates the role.
+	if resp := b.handle(logical.CreateOperation, "roles/test-role", map[string]interface{}{"bound_space_ids": "space-a", "cas": 0}); resp != nil {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	if resp := b.handle(logical.UpdateOperation, "roles/test-role", map[string]interface{}{"bound_space_ids": "space-b", "cas": 0}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected the existing role not to be replaced but received %#v", resp)
+	}
+
+	// Writers that read the same revision can't both change it.
+	if resp := b.handle(logical.UpdateOperation, "roles/test-role", map[string]interface{}{"bound_space_ids": "space-b", "cas": 1}); resp != nil {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	if resp := b.handle(logical.UpdateOperation, "roles/test-role", map[string]interface{}{"bound_space_ids": "space-c", "cas": 1}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected a stale revision to be refused but received %#v", resp)
+	}
+
+	// Writes without a cas are always allowed.
+	if resp := b.handle(logical.UpdateOperation, "roles/test-role", map[string]interface{}{"bound_space_ids": "space-d"}); resp != nil {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	resp := b.mustHandle(logical.ReadOperation, "roles/test-role", nil)
+	if resp.Data["revision"] != 3 || fmt.Sprint(resp.Data["bound_space_ids"]) != "[space-d]" {
+		t.Fatalf("unexpected role %v", resp.Data)
+	}
+}
+
+func TestRoleIPMatchingSource(t *testing.T) {
+	b := newTestBackend(t)
+
+	if resp := b.handle(logical.UpdateOperation, "roles/test-role", map[string]interface{}{"ip_matching_source": ipMatchingSourceCFAPI}); resp != nil {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	// Renewals that skip the CF API couldn't match the IP address against anything.
+	if resp := b.handle(logical.UpdateOperation, "roles/test-role", map[string]interface{}{"disable_cf_api_renewal_check": true}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected the combination to be refused but received %#v", resp)
+	}
+	if resp := b.handle(logical.UpdateOperation, "roles/test-role", map[string]interface{}{"ip_matching_source": ipMatchingSourceRemoteAddr, "disable_cf_api_renewal_check": true}); resp != nil {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+}
+
+func TestRequireBoundConstraints(t *testing.T) {
+	env := newLoadTestEnv(t)
+	defer env.close()
+
+	// Until bound constraints are required, roles may allow any app.
+	if resp := env.handle(logical.UpdateOperation, "roles/test-role", map[string]interface{}{"bound_application_ids": []string{}}); resp != nil {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	resp := env.handle(logical.UpdateOperation, "config", map[string]interface{}{"require_bound_constraints": true})
+	if resp == nil || len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "test-role") {
+		t.Fatalf("expected a warning about the unbound role but received %#v", resp)
+	}
+
+	if resp := env.handle(logical.UpdateOperation, "roles/test-role", map[string]interface{}{"policies": []string{"other"}}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected the unbound role to be refused but received %#v", resp)
+	}
+	if err := env.login(env.loginRequest(t)); err == nil || !strings.Contains(err.Error(), failureCategoryRoleConstraint) {
+		t.Fatalf("expected the login to be refused but received %v", err)
+	}
+	renewResp := env.handleRequest(&logical.Request{
+		Operation: logical.RenewOperation,
+		Path:      "login",
+		Auth: &logical.Auth{
+			InternalData: map[string]interface{}{"role": "test-role"},
+		},
+	})
+	if renewResp == nil || !renewResp.IsError() {
+		t.Fatalf("expected the renewal to be refused but received %#v", renewResp)
+	}
+
+	if resp := env.handle(logical.UpdateOperation, "roles/test-role", map[string]interface{}{"bound_space_ids": []string{cf.FoundSpaceGUID}}); resp != nil {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	if err := env.login(env.loginRequest(t)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,403 @@
+package cf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/jsonutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// snapshotVersion is the version of the format of exported snapshots. Imports of other versions are refused.
+const snapshotVersion = 1
+
+// snapshotOmittedConfigKeys are returned when reading the config, but describe the mount it's stored
+// in, or are deprecated aliases of other keys, so they aren't exported.
+var snapshotOmittedConfigKeys = []string{
+	"version",
+	"revision",
+	// The audience must be unique to each cluster, or signatures made for one could be replayed
+	// to the other.
+	snapshotConfigAudienceKey,
+	"pcf_api_trusted_certificates",
+	"pcf_api_addr",
+	"pcf_username",
+}
+
+// snapshotConfigAudienceKey is the config's audience, which is neither exported nor imported.
+const snapshotConfigAudienceKey = "audience"
+
+// snapshotOmittedRoleKeys are returned when reading a role, but describe the mount it's stored in, or
+// are deprecated aliases of other keys, so they aren't exported.
+var snapshotOmittedRoleKeys = []string{
+	"revision",
+	"policies",
+	"bound_cidrs",
+	"ttl",
+	"max_ttl",
+	"period",
+}
+
+// snapshotSecretKeys are the config's secrets, which are never exported but may be given when importing.
+var snapshotSecretKeys = []string{
+	"cf_password",
+	"cf_client_secret",
+	"cf_api_mutual_tls_key",
+}
+
+func (b *backend) pathExport() *framework.Path {
+	return &framework.Path{
+		Pattern: "export",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.operationExportRead,
+				Summary:  "Export the configuration, roles, and policy mappings, without secrets.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "A snapshot that can be written to the import endpoint of another mount.",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"version": snapshotVersion,
+								"config": map[string]interface{}{
+									"identity_ca_certificates": []string{"-----BEGIN CERTIFICATE-----\n..."},
+									"cf_api_addr":              "https://api.sys.example.com",
+									"cf_username":              "vault",
+								},
+								"roles": map[string]interface{}{
+									"my-role": map[string]interface{}{
+										"bound_application_ids": []string{"2d3e834a-3a25-4591-974c-fa5626d5d0a1"},
+										"token_policies":        []string{"my-policy"},
+									},
+								},
+								"maps": map[string]interface{}{
+									mapKindOrgs:   map[string]interface{}{"my-org": map[string]interface{}{"policies": []string{"org-policy"}}},
+									mapKindSpaces: map[string]interface{}{},
+								},
+							},
+						},
+					}},
+				},
+			},
+		},
+		HelpSynopsis:    pathExportSyn,
+		HelpDescription: pathExportDesc,
+	}
+}
+
+func (b *backend) pathImport() *framework.Path {
+	return &framework.Path{
+		Pattern: "import",
+		Fields: map[string]*framework.FieldSchema{
+			"version": {
+				Type:        framework.TypeInt,
+				Required:    true,
+				Description: "The version of the snapshot's format, as exported.",
+			},
+			"config": {
+				Type:        framework.TypeMap,
+				Description: "The configuration to write, as exported. If left out, the configuration isn't changed.",
+			},
+			"roles": {
+				Type:        framework.TypeMap,
+				Description: "The roles to write, by name, as exported. Roles that aren't given aren't changed.",
+			},
+			"maps": {
+				Type:        framework.TypeMap,
+				Description: `The policy mappings to write, by kind and name, as exported. Mappings that aren't given aren't changed.`,
+			},
+			"cf_password": {
+				Type:        framework.TypeString,
+				Description: "The password for the CF API, if the configuration is given and it's to be changed or the mount has none.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"cf_client_secret": {
+				Type:        framework.TypeString,
+				Description: "The client secret for the CF API, if the configuration is given and it's to be changed or the mount has none.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+			"cf_api_mutual_tls_key": {
+				Type:        framework.TypeString,
+				Description: "The key for mutual TLS with the CF API, if the configuration is given and it's to be changed or the mount has none.",
+				DisplayAttrs: &framework.DisplayAttributes{
+					Sensitive: true,
+				},
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.operationImportUpdate,
+				Summary:  "Import a snapshot exported from this or another mount.",
+			},
+		},
+		HelpSynopsis:    pathImportSyn,
+		HelpDescription: pathImportDesc,
+	}
+}
+
+func (b *backend) operationExportRead(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	snapshot := map[string]interface{}{
+		"version": snapshotVersion,
+	}
+
+	resp, err := b.handleSnapshotRequest(ctx, req.Storage, logical.ReadOperation, "config", nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp != nil {
+		for _, key := range snapshotOmittedConfigKeys {
+			delete(resp.Data, key)
+		}
+		snapshot["config"] = resp.Data
+	}
+
+	roles := make(map[string]interface{})
+	roleNames, err := req.Storage.List(ctx, roleStoragePrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, roleName := range roleNames {
+		resp, err := b.handleSnapshotRequest(ctx, req.Storage, logical.ReadOperation, roleStoragePrefix+roleName, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp == nil {
+			// It was deleted while exporting.
+			continue
+		}
+		for _, key := range snapshotOmittedRoleKeys {
+			delete(resp.Data, key)
+		}
+		roles[roleName] = resp.Data
+	}
+	snapshot["roles"] = roles
+
+	maps := make(map[string]interface{})
+	for _, kind := range []string{mapKindOrgs, mapKindSpaces} {
+		mappings := make(map[string]interface{})
+		resp, err := b.handleSnapshotRequest(ctx, req.Storage, logical.ListOperation, mapStoragePrefix+kind+"/", nil)
+		if err != nil {
+			return nil, err
+		}
+		names, _ := resp.Data["keys"].([]string)
+		for _, name := range names {
+			resp, err := b.handleSnapshotRequest(ctx, req.Storage, logical.ReadOperation, mapStoragePrefix+kind+"/"+name, nil)
+			if err != nil {
+				return nil, err
+			}
+			if resp == nil {
+				continue
+			}
+			mappings[name] = resp.Data
+		}
+		maps[kind] = mappings
+	}
+	snapshot["maps"] = maps
+
+	// The snapshot is returned as it would be decoded by a client, so that what's imported in tests
+	// is what would be imported from a file.
+	encoded, err := jsonutil.EncodeJSON(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	decoded := make(map[string]interface{})
+	if err := jsonutil.DecodeJSON(encoded, &decoded); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: decoded,
+	}, nil
+}
+
+func (b *backend) operationImportUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if version := data.Get("version").(int); version != snapshotVersion {
+		return logical.ErrorResponse(fmt.Sprintf("snapshots of version %d can't be imported; only version %d can", version, snapshotVersion)), nil
+	}
+
+	// Each part of the snapshot is written through the same endpoint it would be if it were written
+	// directly, so it's validated the same way.
+	var writes []snapshotWrite
+	if raw, ok := data.GetOk("config"); ok {
+		config := raw.(map[string]interface{})
+		if _, ok := config[snapshotConfigAudienceKey]; ok {
+			return logical.ErrorResponse(fmt.Sprintf("'config' can't include %q; each mount must keep its own", snapshotConfigAudienceKey)), nil
+		}
+		for _, key := range snapshotSecretKeys {
+			if secret, ok := data.GetOk(key); ok {
+				config[key] = secret
+			}
+		}
+		writes = append(writes, snapshotWrite{path: "config", data: config})
+	}
+	if raw, ok := data.GetOk("maps"); ok {
+		for kind, rawMappings := range raw.(map[string]interface{}) {
+			if kind != mapKindOrgs && kind != mapKindSpaces {
+				return logical.ErrorResponse(fmt.Sprintf("'maps' has an unknown kind of mapping %q", kind)), nil
+			}
+			mappings, ok := rawMappings.(map[string]interface{})
+			if !ok {
+				return logical.ErrorResponse(fmt.Sprintf("'maps' has %s that aren't an object", kind)), nil
+			}
+			for _, name := range sortedKeys(mappings) {
+				mapping, ok := mappings[name].(map[string]interface{})
+				if !ok {
+					return logical.ErrorResponse(fmt.Sprintf("the mapping of %s %q isn't an object", kind, name)), nil
+				}
+				writes = append(writes, snapshotWrite{path: mapStoragePrefix + kind + "/" + name, data: mapping})
+			}
+		}
+	}
+	if raw, ok := data.GetOk("roles"); ok {
+		roles := raw.(map[string]interface{})
+		for _, roleName := range sortedKeys(roles) {
+			role, ok := roles[roleName].(map[string]interface{})
+			if !ok {
+				return logical.ErrorResponse(fmt.Sprintf("role %q isn't an object", roleName)), nil
+			}
+			writes = append(writes, snapshotWrite{path: roleStoragePrefix + roleName, data: role})
+		}
+	}
+
+	// The snapshot is first imported into a copy of what it would change, so that none of it is
+	// imported unless all of it can be.
+	scratch, err := copySnapshotStorage(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	dryRunCtx := context.WithValue(ctx, dryRunContextKey{}, true)
+	for _, write := range writes {
+		if _, err := b.handleSnapshotRequest(dryRunCtx, scratch, logical.UpdateOperation, write.path, write.data); err != nil {
+			if _, ok := err.(snapshotRequestError); ok {
+				return logical.ErrorResponse(fmt.Sprintf("nothing was imported: %s", err)), nil
+			}
+			return nil, err
+		}
+	}
+	for i, write := range writes {
+		if _, err := b.handleSnapshotRequest(ctx, req.Storage, logical.UpdateOperation, write.path, write.data); err != nil {
+			// Something changed since the snapshot was checked, such as the CF API becoming unreachable.
+			return nil, fmt.Errorf("only %d of the snapshot's %d writes were imported: %s", i, len(writes), err)
+		}
+	}
+	return nil, nil
+}
+
+// dryRunContextKey marks the context of the writes checked before importing a snapshot, whose
+// handlers must only change the storage they're given, and not the backend's shared state.
+type dryRunContextKey struct{}
+
+// isDryRun is whether the request with the given context is only checking that a snapshot can be imported.
+func isDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunContextKey{}).(bool)
+	return dryRun
+}
+
+// snapshotWrite is a write made to import part of a snapshot.
+type snapshotWrite struct {
+	path string
+	data map[string]interface{}
+}
+
+// snapshotRequestError is the error response to a request made while exporting or importing a snapshot.
+type snapshotRequestError struct {
+	path string
+	err  error
+}
+
+func (e snapshotRequestError) Error() string {
+	return fmt.Sprintf("%s: %s", e.path, e.err)
+}
+
+// handleSnapshotRequest handles a request made on behalf of an export or import as though it had
+// been made directly. Error responses are returned as a snapshotRequestError.
+func (b *backend) handleSnapshotRequest(ctx context.Context, storage logical.Storage, operation logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: operation,
+		Path:      path,
+		Storage:   storage,
+		Data:      data,
+	})
+	if resp != nil && resp.IsError() {
+		return nil, snapshotRequestError{path: path, err: resp.Error()}
+	}
+	if err == logical.ErrUnsupportedPath {
+		return nil, snapshotRequestError{path: path, err: errors.New("the name is invalid")}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// copySnapshotStorage returns an in-memory copy of what importing a snapshot may change.
+func copySnapshotStorage(ctx context.Context, storage logical.Storage) (logical.Storage, error) {
+	scratch := &logical.InmemStorage{}
+	keys := []string{configStorageKey}
+	for _, prefix := range []string{roleStoragePrefix, mapStoragePrefix + mapKindOrgs + "/", mapStoragePrefix + mapKindSpaces + "/"} {
+		entries, err := storage.List(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			keys = append(keys, prefix+entry)
+		}
+	}
+	for _, key := range keys {
+		entry, err := storage.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		if err := scratch.Put(ctx, entry); err != nil {
+			return nil, err
+		}
+	}
+	return scratch, nil
+}
+
+// sortedKeys returns the keys of m in order, so that imports are made in the same order every time.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+const pathExportSyn = `
+Export the configuration, roles, and policy mappings, without secrets.
+`
+
+const pathExportDesc = `
+Returns a snapshot of the configuration, every role, and every policy mapping,
+in the form they're read in, which can be written as it is to the "import"
+endpoint of another mount to set it up identically, such as to promote a setup
+from one foundation to the next. The CF API password, client secret, and mutual
+TLS key aren't exported, nor is the salt used to hash token metadata, so hashed
+metadata differs between mounts. The audience isn't exported either, since it
+must be unique to each Vault cluster.
+`
+
+const pathImportSyn = `
+Import a snapshot exported from this or another mount.
+`
+
+const pathImportDesc = `
+Writes the configuration, roles, and policy mappings in a snapshot read from
+the "export" endpoint, each as though it had been written to its own endpoint.
+Roles and mappings that the snapshot doesn't include are left as they are. If
+the mount is already configured, its CF API secrets are kept unless new ones
+are given; if it isn't, they must be given. The mount's audience is always kept,
+and snapshots whose configuration includes one are refused. Nothing is imported
+unless every part of the snapshot is valid.
+`
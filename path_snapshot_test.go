@@ -0,0 +1,159 @@
+package cf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/testing/certificates"
+	"github.com/hashicorp/vault-plugin-auth-cf/testing/cf"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestExportImport(t *testing.T) {
+	cfServer := cf.NewServer(cf.DefaultFoundation())
+	defer cfServer.Close()
+	testCerts, err := certificates.Generate(cf.FoundServiceGUID, cf.FoundOrgGUID, cf.FoundSpaceGUID, cf.FoundAppGUID, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testCerts.Close()
+
+	source := newTestBackend(t)
+	source.mustHandle(logical.UpdateOperation, "config", map[string]interface{}{
+		"identity_ca_certificates": []string{testCerts.CACertificate},
+		"cf_api_addr":              cfServer.URL,
+		"cf_username":              cf.AuthUsername,
+		"cf_password":              cf.AuthPassword,
+		"group_alias_source":       groupAliasSourceName,
+		"audience":                 "vault.dev.example.com",
+	})
+	source.mustHandle(logical.UpdateOperation, "roles/web", map[string]interface{}{
+		"bound_application_ids": []string{cf.FoundAppGUID},
+		"bound_cidrs":           []string{"10.0.0.0/8"},
+		"policies":              []string{"web"},
+		"ttl":                   "1h",
+		"token_quota":           3,
+	})
+	source.mustHandle(logical.UpdateOperation, "roles/worker", map[string]interface{}{
+		"bound_space_ids": []string{cf.FoundSpaceGUID},
+		"token_policies":  []string{"worker"},
+	})
+	source.mustHandle(logical.UpdateOperation, "map/orgs/"+cf.FoundOrgGUID, map[string]interface{}{"policies": "org-secrets"})
+	source.mustHandle(logical.UpdateOperation, "map/spaces/my-org/my-space", map[string]interface{}{"policies": "team-secrets"})
+
+	snapshot := source.mustHandle(logical.ReadOperation, "export", nil).Data
+	config := snapshot["config"].(map[string]interface{})
+	for _, key := range snapshotSecretKeys {
+		if _, ok := config[key]; ok {
+			t.Fatalf("expected %q not to be exported", key)
+		}
+	}
+	if _, ok := config["revision"]; ok {
+		t.Fatal("expected the config's revision not to be exported")
+	}
+	if _, ok := config["audience"]; ok {
+		t.Fatal("expected the config's audience not to be exported")
+	}
+
+	// The secrets must be given to import the config into a mount without one.
+	target := newTestBackend(t)
+	resp := target.handle(logical.UpdateOperation, "import", snapshot)
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "nothing was imported") {
+		t.Fatalf("expected the import to be refused but received %#v", resp)
+	}
+	if resp := target.mustHandle(logical.ListOperation, "roles", nil); len(resp.Data) != 0 {
+		t.Fatalf("expected no roles to have been imported but received %v", resp.Data)
+	}
+
+	withSecrets := make(map[string]interface{}, len(snapshot)+1)
+	for key, value := range snapshot {
+		withSecrets[key] = value
+	}
+	withSecrets["cf_password"] = cf.AuthPassword
+	target.mustHandle(logical.UpdateOperation, "import", withSecrets)
+	// Lists that were never set are exported as null, but once they've been written they're empty.
+	if imported := target.mustHandle(logical.ReadOperation, "export", nil).Data; !reflect.DeepEqual(withoutEmptyValues(imported), withoutEmptyValues(snapshot)) {
+		t.Fatalf("expected the mounts to be identical but exported\n%v\nand\n%v", snapshot, imported)
+	}
+
+	// The target keeps its own audience, and snapshots that would replace it are refused.
+	target.mustHandle(logical.UpdateOperation, "config", map[string]interface{}{"audience": "vault.prod.example.com"})
+	target.mustHandle(logical.UpdateOperation, "import", withSecrets)
+	if resp := target.mustHandle(logical.ReadOperation, "config", nil); resp.Data["audience"] != "vault.prod.example.com" {
+		t.Fatalf("expected the target to keep its own audience but received %v", resp.Data["audience"])
+	}
+	resp = target.handle(logical.UpdateOperation, "import", map[string]interface{}{
+		"version": snapshotVersion,
+		"config":  map[string]interface{}{"audience": "vault.dev.example.com"},
+	})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected the import to be refused but received %#v", resp)
+	}
+	if resp := target.mustHandle(logical.ReadOperation, "config", nil); resp.Data["audience"] != "vault.prod.example.com" {
+		t.Fatalf("expected the target to keep its own audience but received %v", resp.Data["audience"])
+	}
+
+	// Checking a snapshot's config doesn't switch the mount to it, even when the rest of the snapshot is refused.
+	otherCFServer := cf.NewServer(cf.DefaultFoundation())
+	defer otherCFServer.Close()
+	cfClient := target.cfClient
+	if cfClient == nil {
+		t.Fatal("expected the imported config's CF API client to be in use")
+	}
+	resp = target.handle(logical.UpdateOperation, "import", map[string]interface{}{
+		"version":     snapshotVersion,
+		"config":      map[string]interface{}{"cf_api_addr": otherCFServer.URL},
+		"cf_password": cf.AuthPassword,
+		"roles": map[string]interface{}{
+			"invalid": map[string]interface{}{"token_quota_scope": "nonsense"},
+		},
+	})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected the import to be refused but received %#v", resp)
+	}
+	if target.cfClient != cfClient {
+		t.Fatal("expected the refused import to leave the CF API client in use")
+	}
+	if resp := target.mustHandle(logical.ReadOperation, "config", nil); resp.Data["cf_api_addr"] != cfServer.URL {
+		t.Fatalf("expected the config not to have been imported but received %v", resp.Data["cf_api_addr"])
+	}
+
+	// An invalid role prevents the rest of the snapshot from being imported.
+	resp = target.handle(logical.UpdateOperation, "import", map[string]interface{}{
+		"version": snapshotVersion,
+		"roles": map[string]interface{}{
+			"added":   map[string]interface{}{"token_policies": []string{"added"}},
+			"invalid": map[string]interface{}{"token_quota_scope": "nonsense"},
+		},
+	})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected the import to be refused but received %#v", resp)
+	}
+	if resp := target.mustHandle(logical.ReadOperation, "roles/added", nil); resp != nil {
+		t.Fatal("expected no roles to have been imported")
+	}
+
+	if resp := target.handle(logical.UpdateOperation, "import", map[string]interface{}{"version": snapshotVersion + 1}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected snapshots of other versions to be refused but received %#v", resp)
+	}
+}
+
+// withoutEmptyValues returns a copy of m without the values that are null or empty lists, recursively.
+func withoutEmptyValues(m map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		switch v := value.(type) {
+		case nil:
+			continue
+		case []interface{}:
+			if len(v) == 0 {
+				continue
+			}
+		case map[string]interface{}:
+			value = withoutEmptyValues(v)
+		}
+		result[key] = value
+	}
+	return result
+}
@@ -0,0 +1,149 @@
+package cf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// defaultTidySafetyBuffer is how long records are kept past the point they could still matter.
+const defaultTidySafetyBuffer = 72 * time.Hour
+
+func (b *backend) pathTidy() *framework.Path {
+	return &framework.Path{
+		Pattern: "tidy",
+		Fields: map[string]*framework.FieldSchema{
+			"safety_buffer": {
+				Type:    framework.TypeDurationSecond,
+				Default: int(defaultTidySafetyBuffer / time.Second),
+				Description: `Duration in seconds records are kept for past the point they could still matter,
+to allow for clock skew between Vault nodes. Defaults to 72 hours.`,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.operationTidyUpdate,
+				Summary:  "Remove stale records kept by the backend.",
+			},
+		},
+		HelpSynopsis:    pathTidySyn,
+		HelpDescription: pathTidyDesc,
+	}
+}
+
+func (b *backend) operationTidyUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	safetyBuffer := time.Duration(data.Get("safety_buffer").(int)) * time.Second
+	if safetyBuffer < 0 {
+		return logical.ErrorResponse("'safety_buffer' must not be negative"), nil
+	}
+
+	config, err := config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := b.tidy(ctx, req.Storage, config, safetyBuffer, time.Now())
+	if err == errTidyRunning {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"apps_removed":            result.appsRemoved,
+			"tokens_removed":          result.tokensRemoved,
+			"quota_tokens_removed":    result.quotaTokensRemoved,
+			"failures_removed":        result.failuresRemoved,
+			"limiter_entries_removed": result.limiterEntriesRemoved,
+			"renewal_certs_removed":   result.renewalCertsRemoved,
+		},
+	}, nil
+}
+
+type tidyResult struct {
+	appsRemoved           int
+	tokensRemoved         int
+	quotaTokensRemoved    int
+	failuresRemoved       int
+	limiterEntriesRemoved int
+	renewalCertsRemoved   int
+}
+
+var errTidyRunning = errors.New("a tidy operation is already in progress")
+
+// tidy removes indexed apps and tokens that can no longer be valid, along with the login
+// failures and failure limiter entries this node no longer needs. The config may be nil.
+func (b *backend) tidy(ctx context.Context, storage logical.Storage, config *models.Configuration, safetyBuffer time.Duration, now time.Time) (*tidyResult, error) {
+	if !atomic.CompareAndSwapUint32(&b.tidyRunning, 0, 1) {
+		return nil, errTidyRunning
+	}
+	defer atomic.StoreUint32(&b.tidyRunning, 0)
+
+	result := &tidyResult{}
+
+	// An app's tokens can't outlive the system's max TTL, unless they're periodic.
+	appsRemoved, err := b.tidyApps(ctx, storage, now.Add(-b.System().MaxLeaseTTL()-safetyBuffer))
+	result.appsRemoved = appsRemoved
+	if err != nil {
+		return result, err
+	}
+
+	// Likewise, a token that hasn't been issued or renewed in that time has expired.
+	tokensRemoved, err := b.tidyTokenIndex(ctx, storage, now.Add(-b.System().MaxLeaseTTL()-safetyBuffer))
+	result.tokensRemoved = tokensRemoved
+	if err != nil {
+		return result, err
+	}
+
+	// Counted tokens are kept until they've expired, so they needn't be kept longer.
+	quotaTokensRemoved, err := b.tidyTokenQuotas(ctx, storage, now.Add(-safetyBuffer))
+	result.quotaTokensRemoved = quotaTokensRemoved
+	if err != nil {
+		return result, err
+	}
+
+	// A presented certificate can't extend a token's life once it's expired.
+	renewalCertsRemoved, err := tidyRenewalCerts(ctx, storage, now.Add(-safetyBuffer))
+	result.renewalCertsRemoved = renewalCertsRemoved
+	if err != nil {
+		return result, err
+	}
+
+	result.failuresRemoved = b.failures.prune(now.Add(-safetyBuffer))
+
+	var window time.Duration
+	if config != nil {
+		window = config.LoginFailureWindow
+	}
+	result.limiterEntriesRemoved = b.limiter.tidy(now, window)
+
+	if result.appsRemoved > 0 || result.tokensRemoved > 0 || result.quotaTokensRemoved > 0 || result.renewalCertsRemoved > 0 || result.failuresRemoved > 0 || result.limiterEntriesRemoved > 0 {
+		b.Logger().Info(fmt.Sprintf("tidy removed %d apps, %d tokens, %d tokens counted against quotas, %d presented certificates, %d login failures, and %d failure limiter entries", result.appsRemoved, result.tokensRemoved, result.quotaTokensRemoved, result.renewalCertsRemoved, result.failuresRemoved, result.limiterEntriesRemoved))
+	}
+	return result, nil
+}
+
+const pathTidySyn = `
+Remove stale records kept by the backend.
+`
+
+const pathTidyDesc = `
+Removes apps recorded for reconciliation that haven't logged in for longer
+than the system's max TTL plus the safety buffer, since they can no longer
+have valid tokens unless those tokens are periodic, or, if they were found to
+have been deleted from CF, haven't been for that long, and likewise indexed
+tokens that haven't been issued or renewed in that time. Tokens counted
+against role token quotas are removed once they're older than their TTL plus
+the safety buffer. Certificates presented for renewing tokens are removed once they've
+been expired for longer than the safety buffer. Recent login failures
+older than the safety buffer, and failure limiter entries that are neither
+locked out nor have failed within the login failure window, are also removed
+from the memory of the Vault node serving this request.
+`
@@ -0,0 +1,70 @@
+package cf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestTidy(t *testing.T) {
+	b := newTestBackendWithConfig(t, hclog.NewNullLogger(), &logical.StaticSystemView{
+		MaxLeaseTTLVal: time.Hour,
+	})
+	ctx, storage := b.ctx, b.storage
+
+	now := time.Now()
+	for _, indexEntry := range []*models.AppIndexEntry{
+		{AppID: "stale", LastLogin: now.Add(-2 * time.Hour)},
+		{AppID: "recent", LastLogin: now.Add(-30 * time.Minute)},
+		{AppID: "recently-deleted", LastLogin: now.Add(-2 * time.Hour), DeletedAt: now.Add(-30 * time.Minute)},
+	} {
+		if err := putAppIndexEntry(ctx, storage, indexEntry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := putTokenIndexEntry(ctx, storage, &models.TokenIndexEntry{
+		AppID:     "recent",
+		Accessors: map[string]time.Time{"stale": now.Add(-2 * time.Hour), "recent": now},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for accessor, certNotAfter := range map[string]time.Time{"stale": now.Add(-time.Hour), "recent": now.Add(time.Hour)} {
+		if err := putRenewalCertEntry(ctx, storage, accessor, &models.RenewalCertEntry{CertNotAfter: certNotAfter}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.failures.add(&failureRecord{ID: "stale", Time: now.Add(-2 * time.Hour)})
+	b.failures.add(&failureRecord{ID: "recent", Time: now})
+	b.limiter.recordFailure("ip:10.0.0.1", now.Add(-time.Hour), 5, time.Minute, time.Minute)
+
+	resp := b.mustHandle(logical.UpdateOperation, "tidy", map[string]interface{}{
+		"safety_buffer": "30m",
+	})
+	for field, expected := range map[string]int{
+		"apps_removed":            1,
+		"tokens_removed":          1,
+		"failures_removed":        1,
+		"limiter_entries_removed": 1,
+		"renewal_certs_removed":   1,
+	} {
+		if resp.Data[field] != expected {
+			t.Fatalf("expected %s to be %d but received %v", field, expected, resp.Data[field])
+		}
+	}
+
+	if entry, err := getAppIndexEntry(ctx, storage, "recent"); err != nil || entry == nil {
+		t.Fatalf("expected the recent app to be kept: %v", err)
+	}
+	if entry, err := getAppIndexEntry(ctx, storage, "recently-deleted"); err != nil || entry == nil || !entry.Deleted() {
+		t.Fatalf("expected the recently deleted app to be kept: %v", err)
+	}
+	if entry, err := getRenewalCertEntry(ctx, storage, "recent"); err != nil || entry == nil {
+		t.Fatalf("expected the recent presented certificate to be kept: %v", err)
+	}
+	if b.failures.get("recent") == nil {
+		t.Fatal("expected the recent failure to be kept")
+	}
+}
@@ -0,0 +1,178 @@
+package cf
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathListTokensByApp() *framework.Path {
+	return &framework.Path{
+		Pattern: "tokens/by-app/?$",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.operationTokensByAppList,
+				Summary:  "List the apps whose tokens have been indexed, or that have been cut off.",
+			},
+		},
+		HelpSynopsis:    pathTokensByAppSyn,
+		HelpDescription: pathTokensByAppDesc,
+	}
+}
+
+func (b *backend) pathTokensByApp() *framework.Path {
+	return &framework.Path{
+		Pattern: "tokens/by-app/" + framework.GenericNameRegex("app_id"),
+		Fields: map[string]*framework.FieldSchema{
+			"app_id": {
+				Type:        framework.TypeString,
+				Required:    true,
+				Description: "The ID of the app.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.operationTokensByAppRead,
+				Summary:  "List the accessors of the app's renewed tokens, when its other tokens were issued, and whether it's been cut off.",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.operationTokensByAppDelete,
+				Summary:  "Forget the app's indexed tokens, and allow it to log in again if it was cut off.",
+			},
+		},
+		HelpSynopsis:    pathTokensByAppSyn,
+		HelpDescription: pathTokensByAppDesc,
+	}
+}
+
+func (b *backend) pathTokensByAppRevoke() *framework.Path {
+	return &framework.Path{
+		Pattern: "tokens/by-app/" + framework.GenericNameRegex("app_id") + "/revoke",
+		Fields: map[string]*framework.FieldSchema{
+			"app_id": {
+				Type:        framework.TypeString,
+				Required:    true,
+				Description: "The ID of the app.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.operationTokensByAppRevoke,
+				Summary:  "Cut the app off, refusing its logins and the renewal of its tokens.",
+			},
+		},
+		HelpSynopsis:    pathTokensByAppSyn,
+		HelpDescription: pathTokensByAppDesc,
+	}
+}
+
+func (b *backend) operationTokensByAppList(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	appIDs, err := listTokenIndexApps(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(appIDs), nil
+}
+
+func (b *backend) operationTokensByAppRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	appID := data.Get("app_id").(string)
+	indexEntry, err := getTokenIndexEntry(ctx, req.Storage, appID)
+	if err != nil {
+		return nil, err
+	}
+	if indexEntry == nil {
+		return nil, nil
+	}
+	logins, err := getTokenLogins(ctx, req.Storage, appID)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: tokenIndexData(indexEntry, logins),
+	}, nil
+}
+
+func (b *backend) operationTokensByAppDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := b.deleteTokenIndex(ctx, req.Storage, data.Get("app_id").(string)); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (b *backend) operationTokensByAppRevoke(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	appID := data.Get("app_id").(string)
+	lock := locksutil.LockForKey(b.tokenIndexLocks, appID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	indexEntry, err := getTokenIndexEntry(ctx, req.Storage, appID)
+	if err != nil {
+		return nil, err
+	}
+	if indexEntry == nil {
+		// The app needn't have renewed a token to be cut off.
+		indexEntry = &models.TokenIndexEntry{AppID: appID}
+	}
+	if !indexEntry.Revoked() {
+		indexEntry.RevokedAt = time.Now().UTC()
+		if err := putTokenIndexEntry(ctx, req.Storage, indexEntry); err != nil {
+			return nil, err
+		}
+		b.Logger().Info(fmt.Sprintf("app %s has been cut off; its logins and renewals will be refused", appID))
+	}
+	logins, err := getTokenLogins(ctx, req.Storage, appID)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: tokenIndexData(indexEntry, logins),
+	}, nil
+}
+
+// tokenIndexData describes the indexed app and its unrenewed tokens, with its accessors sorted so
+// they can be compared between reads.
+func tokenIndexData(indexEntry *models.TokenIndexEntry, logins map[string]time.Time) map[string]interface{} {
+	accessors := make([]string, 0, len(indexEntry.Accessors))
+	for accessor := range indexEntry.Accessors {
+		accessors = append(accessors, accessor)
+	}
+	sort.Strings(accessors)
+	unrenewedTokens := make(map[string]string, len(logins))
+	for loginID, issued := range logins {
+		unrenewedTokens[loginID] = issued.Format(time.RFC3339)
+	}
+
+	revokedAt := ""
+	if indexEntry.Revoked() {
+		revokedAt = indexEntry.RevokedAt.Format(time.RFC3339)
+	}
+	return map[string]interface{}{
+		"app_id":           indexEntry.AppID,
+		"space_id":         indexEntry.SpaceID,
+		"accessors":        accessors,
+		"unrenewed_tokens": unrenewedTokens,
+		"revoked_at":       revokedAt,
+	}
+}
+
+const pathTokensByAppSyn = `
+Find the tokens issued to an app, and cut the app off.
+`
+
+const pathTokensByAppDesc = `
+Each token issued to an app is indexed at login, but its accessor is only
+indexed when the token is first renewed, since Vault doesn't reveal it to the
+backend before then. Reading an app lists the accessors of its renewed tokens,
+which may be passed to "vault token revoke -accessor", and when each of its
+tokens that haven't been renewed was issued. Writing to an app's revoke path cuts the app off:
+its logins and the renewal of its existing tokens are refused from then on, so
+tokens that can't be revoked individually expire at the end of their current TTL.
+Deleting an app forgets its indexed tokens and lifts the cut-off. Every path
+here requires sudo.
+`
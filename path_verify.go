@@ -0,0 +1,185 @@
+package cf
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// These are the checks a login must pass, in the order they're performed.
+const (
+	checkNameRequest          = "request"
+	checkNameTokenBoundCIDRs  = "token_bound_cidrs"
+	checkNameSigningTime      = "signing_time"
+	checkNameSignature        = "signature"
+	checkNameCertificateChain = "certificate_chain"
+	checkNameRoleConstraints  = "role_constraints"
+	checkNameCFAPI            = "cf_api"
+)
+
+var checkNames = []string{
+	checkNameRequest,
+	checkNameTokenBoundCIDRs,
+	checkNameSigningTime,
+	checkNameSignature,
+	checkNameCertificateChain,
+	checkNameRoleConstraints,
+	checkNameCFAPI,
+}
+
+// These are the outcomes a check may have.
+const (
+	checkStatusPassed  = "passed"
+	checkStatusFailed  = "failed"
+	checkStatusSkipped = "skipped"
+)
+
+// loginChecks records the outcome of each check performed while attempting a login.
+// Its methods may be called on a nil *loginChecks, in which case nothing is recorded.
+type loginChecks struct {
+	statuses map[string]string
+	errors   map[string]string
+
+	// failure is the error the failed check returned, if any.
+	failure error
+}
+
+func newLoginChecks() *loginChecks {
+	return &loginChecks{
+		statuses: make(map[string]string),
+		errors:   make(map[string]string),
+	}
+}
+
+func (c *loginChecks) pass(name string) {
+	if c == nil {
+		return
+	}
+	c.statuses[name] = checkStatusPassed
+}
+
+func (c *loginChecks) skip(name string) {
+	if c == nil {
+		return
+	}
+	c.statuses[name] = checkStatusSkipped
+}
+
+// fail records that the named check failed with the given error, and returns the error
+// for convenience. Login failures are marked with the check's name even when c is nil,
+// so that they can be logged with it.
+func (c *loginChecks) fail(name string, err error) error {
+	if failure, ok := err.(*loginFailure); ok && failure.stage == "" {
+		failure.stage = name
+	}
+	if c == nil {
+		return err
+	}
+	c.statuses[name] = checkStatusFailed
+	c.errors[name] = err.Error()
+	c.failure = err
+	return err
+}
+
+// results returns the outcome of every check, in order. Checks that weren't reached are skipped.
+func (c *loginChecks) results() []map[string]interface{} {
+	results := make([]map[string]interface{}, len(checkNames))
+	for i, name := range checkNames {
+		status, ok := c.statuses[name]
+		if !ok {
+			status = checkStatusSkipped
+		}
+		result := map[string]interface{}{
+			"name":   name,
+			"status": status,
+		}
+		if err, ok := c.errors[name]; ok {
+			result["error"] = err
+		}
+		results[i] = result
+	}
+	return results
+}
+
+func (b *backend) pathVerify() *framework.Path {
+	return &framework.Path{
+		Pattern: "verify",
+		Fields:  loginFields(),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.operationVerifyUpdate,
+				Summary:  "Check whether a login would succeed, without issuing a token.",
+				Responses: map[int][]framework.Response{
+					http.StatusOK: {{
+						Description: "The outcome of each check a login must pass, in order. Details of the token that would be issued are only included if every check passed.",
+						Example: &logical.Response{
+							Data: map[string]interface{}{
+								"success": false,
+								"checks": []map[string]interface{}{
+									{"name": checkNameRequest, "status": checkStatusPassed},
+									{"name": checkNameRoleConstraints, "status": checkStatusFailed, "error": "app ID 2d3e834a-3a25-4591-974c-fa5626d5d0a1 doesn't match role constraints"},
+									{"name": checkNameCFAPI, "status": checkStatusSkipped},
+								},
+							},
+						},
+					}},
+				},
+			},
+		},
+		HelpSynopsis:    pathVerifySyn,
+		HelpDescription: pathVerifyDesc,
+	}
+}
+
+// operationVerifyUpdate runs the same checks as logging in, but rather than issuing a token, it
+// reports the outcome of each check.
+func (b *backend) operationVerifyUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	timeReceived := time.Now().UTC()
+
+	config, err := config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, errors.New("no CA is configured for verifying client certificates")
+	}
+
+	checks := newLoginChecks()
+	auth, err := b.attemptLogin(ctx, req, data, config, timeReceived, checks)
+	// Errors that were recorded as a failed check are reported in the results; anything
+	// else is a problem with Vault rather than with the login.
+	if err != nil && err != checks.failure {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"success": auth != nil,
+			"checks":  checks.results(),
+		},
+	}
+	if auth != nil {
+		resp.Data["role"] = auth.InternalData["role"]
+		resp.Data["display_name"] = auth.DisplayName
+		resp.Data["metadata"] = auth.Metadata
+		resp.Data["alias_name"] = auth.Alias.Name
+		resp.Data["policies"] = auth.Policies
+	}
+	return resp, nil
+}
+
+const pathVerifySyn = `
+Check whether a login would succeed without issuing a token.
+`
+
+const pathVerifyDesc = `
+Accepts the same fields as logging in, and runs the same signature, certificate,
+role constraint, and CF API checks, but rather than issuing a token, reports
+whether each check passed, failed, or was skipped. This is useful for onboarding
+new apps and debugging role bindings, including seeing which role would be
+selected for a login that doesn't name one.
+`
@@ -0,0 +1,90 @@
+package cf
+
+import (
+	"sync"
+	"time"
+)
+
+// maxLimiterEntries is how many sources the failure limiter tracks before it sweeps away
+// those that no longer matter.
+const maxLimiterEntries = 10000
+
+// failureLimiter tracks failed logins by source, and locks out sources that fail too often.
+// Its state is kept in memory, so each Vault node enforces its limits independently.
+type failureLimiter struct {
+	mu      sync.Mutex
+	entries map[string]*limiterEntry
+}
+
+type limiterEntry struct {
+	failures    []time.Time
+	lockedUntil time.Time
+}
+
+func newFailureLimiter() *failureLimiter {
+	return &failureLimiter{
+		entries: make(map[string]*limiterEntry),
+	}
+}
+
+// lockedUntil returns when the given source's lockout ends, or the zero time if it isn't locked out.
+func (l *failureLimiter) lockedUntil(source string, now time.Time) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.entries[source]
+	if !ok || !now.Before(entry.lockedUntil) {
+		return time.Time{}
+	}
+	return entry.lockedUntil
+}
+
+// recordFailure records a failed login from the given source, and locks it out for the
+// lockout duration if it has now failed limit times within the window.
+func (l *failureLimiter) recordFailure(source string, now time.Time, limit int, window, lockout time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) >= maxLimiterEntries {
+		l.sweep(now, window)
+	}
+	entry, ok := l.entries[source]
+	if !ok {
+		entry = &limiterEntry{}
+		l.entries[source] = entry
+	}
+	entry.failures = append(withinWindow(entry.failures, now, window), now)
+	if len(entry.failures) >= limit {
+		entry.lockedUntil = now.Add(lockout)
+		entry.failures = nil
+	}
+}
+
+// tidy sweeps away the sources that no longer matter, and returns how many were removed.
+func (l *failureLimiter) tidy(now time.Time, window time.Duration) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	before := len(l.entries)
+	l.sweep(now, window)
+	return before - len(l.entries)
+}
+
+// sweep removes the sources that are neither locked out nor have failed within the window.
+// It must be called while holding the lock.
+func (l *failureLimiter) sweep(now time.Time, window time.Duration) {
+	for source, entry := range l.entries {
+		entry.failures = withinWindow(entry.failures, now, window)
+		if len(entry.failures) == 0 && !now.Before(entry.lockedUntil) {
+			delete(l.entries, source)
+		}
+	}
+}
+
+// withinWindow returns the failures that happened within the window before now.
+func withinWindow(failures []time.Time, now time.Time, window time.Duration) []time.Time {
+	cutoff := now.Add(-window)
+	for i, failure := range failures {
+		if failure.After(cutoff) {
+			return failures[i:]
+		}
+	}
+	return nil
+}
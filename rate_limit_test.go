@@ -0,0 +1,45 @@
+package cf
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFailureLimiter(t *testing.T) {
+	limiter := newFailureLimiter()
+	now := time.Now()
+	window := time.Minute
+	lockout := 5 * time.Minute
+
+	limiter.recordFailure("ip:10.0.0.1", now, 3, window, lockout)
+	limiter.recordFailure("ip:10.0.0.1", now.Add(10*time.Second), 3, window, lockout)
+	if !limiter.lockedUntil("ip:10.0.0.1", now.Add(10*time.Second)).IsZero() {
+		t.Fatal("shouldn't be locked out before reaching the limit")
+	}
+
+	// Failures outside the window shouldn't count towards the limit.
+	limiter.recordFailure("ip:10.0.0.1", now.Add(65*time.Second), 3, window, lockout)
+	if !limiter.lockedUntil("ip:10.0.0.1", now.Add(65*time.Second)).IsZero() {
+		t.Fatal("shouldn't be locked out by failures outside the window")
+	}
+
+	limiter.recordFailure("ip:10.0.0.1", now.Add(68*time.Second), 3, window, lockout)
+	lockedUntil := limiter.lockedUntil("ip:10.0.0.1", now.Add(68*time.Second))
+	if !lockedUntil.Equal(now.Add(68 * time.Second).Add(lockout)) {
+		t.Fatalf("expected to be locked out until %s but received %s", now.Add(68*time.Second).Add(lockout), lockedUntil)
+	}
+	if !limiter.lockedUntil("ip:10.0.0.2", now.Add(68*time.Second)).IsZero() {
+		t.Fatal("other sources shouldn't be locked out")
+	}
+	if !limiter.lockedUntil("ip:10.0.0.1", lockedUntil).IsZero() {
+		t.Fatal("the lockout should end after its duration")
+	}
+
+	limiter.mu.Lock()
+	limiter.sweep(lockedUntil, window)
+	remaining := len(limiter.entries)
+	limiter.mu.Unlock()
+	if remaining != 0 {
+		t.Fatalf("expected expired entries to be swept but %d remain", remaining)
+	}
+}
@@ -0,0 +1,84 @@
+package cf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// These rank how narrowly roles are bound, for selecting a role when a login doesn't name one.
+const (
+	specificityNone = iota
+	specificityOrg
+	specificitySpace
+	specificityApp
+)
+
+// roleSpecificity returns how narrowly the role is bound by the most specific of its app, space,
+// and org constraints.
+func roleSpecificity(role *models.RoleEntry) int {
+	switch {
+	case len(role.BoundAppIDs) > 0:
+		return specificityApp
+	case len(role.BoundSpaceIDs) > 0:
+		return specificitySpace
+	case len(role.BoundOrgIDs) > 0:
+		return specificityOrg
+	default:
+		return specificityNone
+	}
+}
+
+// selectRole returns the name of the most specific role whose constraints the certificate meets,
+// along with the role. Roles bound to none of an app, space, or org are never selected, since they'd
+// match any certificate. If several roles are equally specific, none is selected, since there's no
+// telling which the caller wants.
+func selectRole(ctx context.Context, storage logical.Storage, cfCert *models.CFCertificate, reqConnRemoteAddr string) (string, *models.RoleEntry, error) {
+	roleNames, err := storage.List(ctx, roleStoragePrefix)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var selectedNames []string
+	var selected *models.RoleEntry
+	selectedSpecificity := specificityNone
+	for _, roleName := range roleNames {
+		role, err := getRole(ctx, storage, roleName)
+		if err != nil {
+			return "", nil, err
+		}
+		if role == nil {
+			// It was deleted since it was listed.
+			continue
+		}
+		specificity := roleSpecificity(role)
+		if specificity == specificityNone || specificity < selectedSpecificity {
+			continue
+		}
+		if cfCert.IsServiceInstance() && !role.AllowServiceInstanceLogin {
+			continue
+		}
+		if err := checkRoleConstraints(role, cfCert, reqConnRemoteAddr); err != nil {
+			continue
+		}
+		if specificity > selectedSpecificity {
+			selectedNames = nil
+			selectedSpecificity = specificity
+		}
+		selectedNames = append(selectedNames, roleName)
+		selected = role
+	}
+
+	switch len(selectedNames) {
+	case 0:
+		return "", nil, newLoginFailure(failureCategoryRoleConstraint, errors.New("no role's constraints match the certificate"))
+	case 1:
+		return selectedNames[0], selected, nil
+	default:
+		return "", nil, newLoginFailure(failureCategoryRoleConstraint, fmt.Errorf("the certificate matches roles %s equally specifically; the role must be named", strings.Join(selectedNames, ", ")))
+	}
+}
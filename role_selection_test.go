@@ -0,0 +1,69 @@
+package cf
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestSelectRole(t *testing.T) {
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	putRole := func(roleName string, role *models.RoleEntry) {
+		// Roles are bound to the certificate's IP address unless told otherwise.
+		role.DisableIPMatching = true
+		entry, err := logical.StorageEntryJSON(roleStoragePrefix+roleName, role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectSelected := func(cfCert *models.CFCertificate, expected string) {
+		roleName, role, err := selectRole(ctx, storage, cfCert, "10.0.0.1")
+		if expected == "" {
+			if err == nil {
+				t.Fatalf("expected no role to be selected but %s was", roleName)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if roleName != expected || role == nil {
+			t.Fatalf("expected %s to be selected but %s was", expected, roleName)
+		}
+	}
+
+	appCert, err := models.NewCFCertificate("instance-id", "org-id", "space-id", "app-id", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherAppCert, err := models.NewCFCertificate("instance-id", "org-id", "space-id", "other-app-id", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSpaceCert, err := models.NewCFCertificate("instance-id", "org-id", "other-space-id", "other-app-id", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Roles bound to nothing would match everything, so they're never selected.
+	putRole("unbound-role", &models.RoleEntry{})
+	expectSelected(appCert, "")
+
+	putRole("org-role", &models.RoleEntry{BoundOrgIDs: []string{"org-id"}})
+	putRole("space-role", &models.RoleEntry{BoundSpaceIDs: []string{"space-id"}})
+	putRole("app-role", &models.RoleEntry{BoundAppIDs: []string{"app-id"}, BoundOrgIDs: []string{"org-id"}})
+	putRole("other-org-app-role", &models.RoleEntry{BoundAppIDs: []string{"app-id"}, BoundOrgIDs: []string{"other-org-id"}})
+	expectSelected(appCert, "app-role")
+	expectSelected(otherAppCert, "space-role")
+	expectSelected(otherSpaceCert, "org-role")
+
+	// Equally specific roles are ambiguous.
+	putRole("other-app-role", &models.RoleEntry{BoundAppIDs: []string{"app-id"}})
+	expectSelected(appCert, "")
+}
@@ -0,0 +1,130 @@
+package signatures
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ecdsaSignature is the ASN.1 structure of an ECDSA signature.
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// ecdsaHash returns the hash used for signing with keys on the given curve.
+// Only the P-256 and P-384 curves are supported.
+func ecdsaHash(curve elliptic.Curve) (crypto.Hash, error) {
+	switch curve {
+	case elliptic.P256():
+		return crypto.SHA256, nil
+	case elliptic.P384():
+		return crypto.SHA384, nil
+	default:
+		return 0, fmt.Errorf("unsupported elliptic curve %s", curve.Params().Name)
+	}
+}
+
+// hashFor returns the hash used for signatures of the given version made with the given key,
+// or 0 for Ed25519 keys, which hash what they sign themselves.
+func hashFor(version int, publicKey crypto.PublicKey) (crypto.Hash, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if version >= Version2 {
+			return crypto.SHA512, nil
+		}
+		return crypto.SHA256, nil
+	case *ecdsa.PublicKey:
+		hash, err := ecdsaHash(key.Curve)
+		if err != nil {
+			return 0, err
+		}
+		if version >= Version2 {
+			return crypto.SHA512, nil
+		}
+		return hash, nil
+	case ed25519.PublicKey:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+}
+
+func digest(hash crypto.Hash, message []byte) []byte {
+	h := hash.New()
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+func signWithKey(privateKey crypto.PrivateKey, version int, signatureData *SignatureData) ([]byte, error) {
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+	}
+	hash, err := hashFor(version, signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	message := signatureData.payload(version)
+
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		// For version 1 signatures this resolves to using a saltLength of 222.
+		return rsa.SignPSS(rand.Reader, key, hash, digest(hash, message), nil)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest(hash, message))
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(ecdsaSignature{R: r, S: s})
+	case ed25519.PrivateKey:
+		// Ed25519 hashes what it signs itself, so it's given the data to sign directly.
+		return ed25519.Sign(key, message), nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+	}
+}
+
+func verifyWithKey(publicKey crypto.PublicKey, version int, signatureData *SignatureData, signatureBytes []byte) error {
+	hash, err := hashFor(version, publicKey)
+	if err != nil {
+		return err
+	}
+	message := signatureData.payload(version)
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		// Only RSASSA-PSS signatures are accepted; PKCS #1 v1.5 signatures never have been.
+		// The salt length is detected from the signature, so signers may use any length.
+		return rsa.VerifyPSS(key, hash, digest(hash, message), signatureBytes, nil)
+	case *ecdsa.PublicKey:
+		sig := &ecdsaSignature{}
+		rest, err := asn1.Unmarshal(signatureBytes, sig)
+		if err != nil {
+			return err
+		}
+		if len(rest) > 0 {
+			return errors.New("trailing data after ecdsa signature")
+		}
+		if sig.R == nil || sig.S == nil || sig.R.Sign() <= 0 || sig.S.Sign() <= 0 {
+			return errors.New("invalid ecdsa signature")
+		}
+		if !ecdsa.Verify(key, digest(hash, message), sig.R, sig.S) {
+			return errors.New("ecdsa verification failure")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, message, signatureBytes) {
+			return errors.New("ed25519 verification failure")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+}
@@ -0,0 +1,108 @@
+package signatures
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+)
+
+// generateSelfSigned writes the given key to a temporary file in PEM format, and returns its
+// path along with a self-signed certificate for it.
+func generateSelfSigned(t *testing.T, key crypto.Signer, keyBlock *pem.Block) (string, string) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "instance"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile, err := ioutil.TempFile("", "instance-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keyFile.Close()
+	if err := pem.Encode(keyFile, keyBlock); err != nil {
+		t.Fatal(err)
+	}
+	return keyFile.Name(), string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}))
+}
+
+func TestSignVerifyECDSA(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384()} {
+		t.Run(curve.Params().Name, func(t *testing.T) {
+			key, err := ecdsa.GenerateKey(curve, rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyBytes, err := x509.MarshalECPrivateKey(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pathToKey, cert := generateSelfSigned(t, key, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+			defer os.Remove(pathToKey)
+
+			signatureData := &SignatureData{
+				SigningTime:            time.Now(),
+				Role:                   "my-role",
+				CFInstanceCertContents: cert,
+			}
+			signature, err := Sign(pathToKey, signatureData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Verify(signature, signatureData); err != nil {
+				t.Fatal(err)
+			}
+
+			signatureData.Role = "other-role"
+			if _, err := Verify(signature, signatureData); err == nil {
+				t.Fatal("expected a signature over different data to be refused")
+			}
+		})
+	}
+}
+
+func TestSignVerifyEd25519(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pathToKey, cert := generateSelfSigned(t, key, &pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+	defer os.Remove(pathToKey)
+
+	signatureData := &SignatureData{
+		SigningTime:            time.Now(),
+		Role:                   "my-role",
+		CFInstanceCertContents: cert,
+	}
+	signature, err := Sign(pathToKey, signatureData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(signature, signatureData); err != nil {
+		t.Fatal(err)
+	}
+
+	signatureData.Role = "other-role"
+	if _, err := Verify(signature, signatureData); err == nil {
+		t.Fatal("expected a signature over different data to be refused")
+	}
+}
@@ -0,0 +1,93 @@
+package signatures
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const (
+	// EnvVarInstanceCertificate and EnvVarInstanceKey are set by CF in each instance to the
+	// paths of its identity certificate and private key.
+	EnvVarInstanceCertificate = "CF_INSTANCE_CERT"
+	EnvVarInstanceKey         = "CF_INSTANCE_KEY"
+
+	// EnvVarInstanceKeyPassphrase isn't set by CF, but may be set to the passphrase
+	// of an encrypted private key used in place of the one at CF_INSTANCE_KEY.
+	EnvVarInstanceKeyPassphrase = "CF_INSTANCE_KEY_PASSPHRASE"
+)
+
+// LoginOptions are the options for signing a login. Any that aren't set are defaulted
+// from the instance's environment.
+type LoginOptions struct {
+	// PathToInstanceCert defaults to the value of CF_INSTANCE_CERT.
+	PathToInstanceCert string
+
+	// PathToInstanceKey defaults to the value of CF_INSTANCE_KEY.
+	PathToInstanceKey string
+
+	// Passphrase defaults to the value of CF_INSTANCE_KEY_PASSPHRASE, and is only
+	// needed if the key is encrypted.
+	Passphrase []byte
+
+	// SigningTime defaults to the current time.
+	SigningTime time.Time
+
+	// Version defaults to Version1.
+	Version int
+
+	// Audience, if set, binds a Version2 signature to the Vault it's sent to.
+	Audience string
+}
+
+// SignLogin signs a login for the given role, returning the data that was signed along
+// with the signature. The options may be nil, in which case all are defaulted.
+func SignLogin(role string, opts *LoginOptions) (*SignatureData, string, error) {
+	if opts == nil {
+		opts = &LoginOptions{}
+	}
+
+	pathToInstanceCert := opts.PathToInstanceCert
+	if pathToInstanceCert == "" {
+		pathToInstanceCert = os.Getenv(EnvVarInstanceCertificate)
+	}
+	if pathToInstanceCert == "" {
+		return nil, "", errors.New("the path to the instance certificate must be provided or set in " + EnvVarInstanceCertificate)
+	}
+	pathToInstanceKey := opts.PathToInstanceKey
+	if pathToInstanceKey == "" {
+		pathToInstanceKey = os.Getenv(EnvVarInstanceKey)
+	}
+	if pathToInstanceKey == "" {
+		return nil, "", errors.New("the path to the instance key must be provided or set in " + EnvVarInstanceKey)
+	}
+	passphrase := opts.Passphrase
+	if len(passphrase) == 0 {
+		passphrase = []byte(os.Getenv(EnvVarInstanceKeyPassphrase))
+	}
+	signingTime := opts.SigningTime
+	if signingTime.IsZero() {
+		signingTime = time.Now()
+	}
+	version := opts.Version
+	if version == 0 {
+		version = Version1
+	}
+
+	certBytes, err := ioutil.ReadFile(pathToInstanceCert)
+	if err != nil {
+		return nil, "", err
+	}
+	signatureData := &SignatureData{
+		SigningTime:            signingTime.UTC(),
+		Role:                   role,
+		CFInstanceCertContents: string(certBytes),
+		Audience:               opts.Audience,
+	}
+	signature, err := SignVersion(pathToInstanceKey, passphrase, version, signatureData)
+	if err != nil {
+		return nil, "", err
+	}
+	return signatureData, signature, nil
+}
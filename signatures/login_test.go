@@ -0,0 +1,69 @@
+package signatures
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/testing/certificates"
+)
+
+func TestSignLogin(t *testing.T) {
+	testCerts, err := certificates.Generate("doesn't", "really", "matter", "here", "10.255.181.105")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := testCerts.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Without any options, the certificate and key are read from the environment.
+	if _, _, err := SignLogin("my-role", nil); err == nil {
+		t.Fatal("expected an error without an instance certificate")
+	}
+	os.Setenv(EnvVarInstanceCertificate, testCerts.PathToInstanceCertificate)
+	os.Setenv(EnvVarInstanceKey, testCerts.PathToInstanceKey)
+	defer os.Unsetenv(EnvVarInstanceCertificate)
+	defer os.Unsetenv(EnvVarInstanceKey)
+
+	before := time.Now().Add(-time.Second)
+	signatureData, signature, err := SignLogin("my-role", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signatureData.CFInstanceCertContents != testCerts.InstanceCertificate {
+		t.Fatalf("expected %q but received %q", testCerts.InstanceCertificate, signatureData.CFInstanceCertContents)
+	}
+	if signatureData.SigningTime.Before(before) {
+		t.Fatalf("expected the current time but received %s", signatureData.SigningTime)
+	}
+	if version, _ := VersionOf(signature); version != Version1 {
+		t.Fatalf("expected version 1 but received %d", version)
+	}
+	if _, err := Verify(signature, signatureData); err != nil {
+		t.Fatal(err)
+	}
+
+	// Options that are given take precedence.
+	os.Setenv(EnvVarInstanceKey, "/nonexistent/instance.key")
+	signingTime := time.Date(2019, 5, 20, 22, 8, 40, 0, time.UTC)
+	signatureData, signature, err = SignLogin("my-role", &LoginOptions{
+		PathToInstanceKey: testCerts.PathToInstanceKey,
+		SigningTime:       signingTime,
+		Version:           Version2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !signatureData.SigningTime.Equal(signingTime) {
+		t.Fatalf("expected %s but received %s", signingTime, signatureData.SigningTime)
+	}
+	if version, _ := VersionOf(signature); version != Version2 {
+		t.Fatalf("expected version 2 but received %d", version)
+	}
+	if _, err := Verify(signature, signatureData); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,53 @@
+package signatures
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/util"
+)
+
+// signingTimeFormats are the layouts accepted for the "signing_time" field, in the order they're tried.
+// The first is the format used for constructing signatures; the rest are provided to make it easier
+// to give the signing time from Bash, PowerShell, and other clients without reformatting it.
+var signingTimeFormats = []string{
+	TimeFormat,
+	time.RFC3339Nano,
+	util.BashTimeFormat,
+	util.PowerShellTimeFormat,
+	util.PowerShellShortTimeFormat,
+}
+
+// ParseSigningTime accepts the signing time of a login in any of the layouts Vault accepts it
+// in, or as Unix epoch seconds. Any additional layouts, such as those configured for clients
+// that format times unusually, are tried after the built-in ones.
+func ParseSigningTime(signingTime string, additionalLayouts ...string) (time.Time, error) {
+	signingTime = strings.TrimSpace(signingTime)
+	for _, layouts := range [][]string{signingTimeFormats, additionalLayouts} {
+		for _, layout := range layouts {
+			if parsed, err := time.Parse(layout, signingTime); err == nil {
+				return parsed, nil
+			}
+		}
+	}
+	if epochSeconds, err := strconv.ParseInt(signingTime, 10, 64); err == nil {
+		return time.Unix(epochSeconds, 0).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("couldn't parse %s", signingTime)
+}
+
+// ValidateSigningTimeLayout returns an error if the given layout couldn't be used to parse signing
+// times, because it doesn't contain any of the elements of Go's reference time.
+func ValidateSigningTimeLayout(layout string) error {
+	if strings.TrimSpace(layout) == "" {
+		return errors.New("layout is empty")
+	}
+	// Any time other than the reference time itself is formatted differently from a layout with elements.
+	if time.Date(2019, 5, 20, 22, 8, 40, 0, time.UTC).Format(layout) == layout {
+		return fmt.Errorf("layout %q doesn't contain any elements of the reference time, %q", layout, time.RFC3339)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package signatures
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSigningTime(t *testing.T) {
+	expected := time.Date(2019, 5, 20, 22, 8, 40, 0, time.UTC)
+	for _, signingTime := range []string{
+		"2019-05-20T22:08:40Z",
+		"2019-05-20T22:08:40.000000000Z",
+		"2019-05-20T15:08:40-07:00",
+		"2019-05-20T22:08:40.0000000+00:00",
+		"Mon May 20 22:08:40 UTC 2019",
+		"Monday, May 20, 2019 10:08:40 PM",
+		"5/20/2019 10:08:40 PM",
+		"1558390120",
+		" 1558390120\n",
+	} {
+		parsed, err := ParseSigningTime(signingTime)
+		if err != nil {
+			t.Fatalf("couldn't parse %q: %s", signingTime, err)
+		}
+		if !parsed.Equal(expected) {
+			t.Fatalf("expected %q to parse as %s but received %s", signingTime, expected, parsed)
+		}
+	}
+	if _, err := ParseSigningTime("yesterday"); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// Layouts that aren't built in are only accepted when they're given.
+	if _, err := ParseSigningTime("20.05.2019 22:08:40"); err == nil {
+		t.Fatal("expected an error without the additional layout")
+	}
+	parsed, err := ParseSigningTime("20.05.2019 22:08:40", "2006/01/02", "02.01.2006 15:04:05")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Equal(expected) {
+		t.Fatalf("expected %s but received %s", expected, parsed)
+	}
+}
+
+func TestValidateSigningTimeLayout(t *testing.T) {
+	if err := ValidateSigningTimeLayout("02.01.2006 15:04:05"); err != nil {
+		t.Fatal(err)
+	}
+	for _, layout := range []string{"", " ", "dd.mm.yyyy"} {
+		if err := ValidateSigningTimeLayout(layout); err == nil {
+			t.Fatalf("expected an error for %q", layout)
+		}
+	}
+}
@@ -1,9 +1,6 @@
 package signatures
 
 import (
-	"crypto"
-	"crypto/rand"
-	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
@@ -15,10 +12,10 @@ import (
 	"time"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault-plugin-auth-cf/util"
 )
 
 const TimeFormat = "2006-01-02T15:04:05Z"
-const signatureVersion = "v1"
 
 type SignatureData struct {
 	SigningTime time.Time
@@ -30,6 +27,10 @@ type SignatureData struct {
 	// identity certificate itself, and the second one is the intermediate
 	// certificate that issued it.
 	CFInstanceCertContents string
+
+	// Audience, if set, binds the signature to the Vault server it's intended for, so it can't
+	// be replayed against another. Only version 2 signatures can include an audience.
+	Audience string
 }
 
 func (s *SignatureData) hash() []byte {
@@ -46,29 +47,42 @@ func (s *SignatureData) toSign() string {
 }
 
 func Sign(pathToPrivateKey string, signatureData *SignatureData) (string, error) {
+	return SignWithPassphrase(pathToPrivateKey, nil, signatureData)
+}
+
+// SignWithPassphrase is like Sign, but decrypts the private key with the given passphrase
+// if it's encrypted. Any key format supported by util.ParsePrivateKey may be used.
+func SignWithPassphrase(pathToPrivateKey string, passphrase []byte, signatureData *SignatureData) (string, error) {
+	return SignVersion(pathToPrivateKey, passphrase, Version1, signatureData)
+}
+
+// SignVersion is like SignWithPassphrase, but creates a signature of the given version.
+// Version 2 signatures are only accepted by Vault servers that support them.
+func SignVersion(pathToPrivateKey string, passphrase []byte, version int, signatureData *SignatureData) (string, error) {
+	if version != Version1 && version != Version2 {
+		return "", fmt.Errorf("unsupported signature version %d", version)
+	}
 	if signatureData == nil {
 		return "", errors.New("signatureData must be provided")
 	}
+	if signatureData.Audience != "" && version < Version2 {
+		return "", fmt.Errorf("signature version %d can't include an audience", version)
+	}
 
 	keyBytes, err := ioutil.ReadFile(pathToPrivateKey)
 	if err != nil {
 		return "", err
 	}
-	block, _ := pem.Decode(keyBytes)
-	if block == nil {
-		return "", fmt.Errorf("unable to decode RSA private key from %s", keyBytes)
-	}
-	rsaPrivateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	privateKey, err := util.ParsePrivateKey(keyBytes, passphrase)
 	if err != nil {
 		return "", err
 	}
 
-	// This resolves to using a saltLength of 222.
-	signatureBytes, err := rsa.SignPSS(rand.Reader, rsaPrivateKey, crypto.SHA256, signatureData.hash(), nil)
+	signatureBytes, err := signWithKey(privateKey, version, signatureData)
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("%s:%s", signatureVersion, base64.StdEncoding.EncodeToString(signatureBytes)), nil
+	return fmt.Sprintf("v%d:%s", version, base64.StdEncoding.EncodeToString(signatureBytes)), nil
 }
 
 // Verify ensures that a given signature was created by a private key
@@ -77,39 +91,23 @@ func Sign(pathToPrivateKey string, signatureData *SignatureData) (string, error)
 // and to be issued by a chain leading to the root CA certificate. There's a
 // util function for this named Validate.
 func Verify(signature string, signatureData *SignatureData) (*x509.Certificate, error) {
-	var signatureBytes []byte
-	var err error
-
 	if signatureData == nil {
 		return nil, errors.New("signatureData must be provided")
 	}
 
-	// Parse signature format
-	parts := strings.Split(signature, ":")
-
-	switch len(parts) {
-	// Original release using URL-safe encoding and no embedded version
-	case 1:
-		signatureBytes, err = base64.URLEncoding.DecodeString(parts[0])
-		if err != nil {
-			return nil, err
-		}
-	case 2:
-		if parts[0] != "v1" {
-			return nil, fmt.Errorf("invalid signature version %q", parts[0])
-		}
-		signatureBytes, err = base64.StdEncoding.DecodeString(parts[1])
-		if err != nil {
-			return nil, err
-		}
-	default:
-		return nil, errors.New("invalid signature format")
+	version, signatureBytes, err := decode(signature)
+	if err != nil {
+		return nil, err
+	}
+	if signatureData.Audience != "" && version < Version2 {
+		return nil, fmt.Errorf("signature version %d can't include an audience", version)
 	}
 
 	// Use the CA certificate to verify the signature we've received.
 	cfInstanceCertContentsBytes := []byte(signatureData.CFInstanceCertContents)
 	var block *pem.Block
 	var result error
+	var certBlocks [][]*x509.Certificate
 	for {
 		block, cfInstanceCertContentsBytes = pem.Decode(cfInstanceCertContentsBytes)
 		if block == nil {
@@ -120,13 +118,17 @@ func Verify(signature string, signatureData *SignatureData) (*x509.Certificate,
 			result = multierror.Append(result, err)
 			continue
 		}
+		certBlocks = append(certBlocks, instanceCerts)
+	}
+	// Without any PEM blocks, the certificates may have been sent as base64-encoded DER.
+	if len(certBlocks) == 0 && result == nil {
+		if instanceCerts, err := util.ParseBase64DERCertificates(signatureData.CFInstanceCertContents); err == nil {
+			certBlocks = append(certBlocks, instanceCerts)
+		}
+	}
+	for _, instanceCerts := range certBlocks {
 		for _, instanceCert := range instanceCerts {
-			publicKey, ok := instanceCert.PublicKey.(*rsa.PublicKey)
-			if !ok {
-				result = multierror.Append(result, fmt.Errorf("not an rsa public key, it's a %t", instanceCert.PublicKey))
-				continue
-			}
-			if err := rsa.VerifyPSS(publicKey, crypto.SHA256, signatureData.hash(), signatureBytes, nil); err != nil {
+			if err := verifyWithKey(instanceCert.PublicKey, version, signatureData, signatureBytes); err != nil {
 				result = multierror.Append(result, err)
 				continue
 			}
@@ -139,3 +141,41 @@ func Verify(signature string, signatureData *SignatureData) (*x509.Certificate,
 	}
 	return nil, result
 }
+
+// VersionOf returns the version of the given signature's format, without verifying it.
+func VersionOf(signature string) (int, error) {
+	version, _, err := decode(signature)
+	return version, err
+}
+
+// decode returns the version and raw bytes of the given signature.
+func decode(signature string) (int, []byte, error) {
+	parts := strings.Split(signature, ":")
+
+	switch len(parts) {
+	// Original release using URL-safe encoding and no embedded version
+	case 1:
+		signatureBytes, err := base64.URLEncoding.DecodeString(parts[0])
+		if err != nil {
+			return 0, nil, err
+		}
+		return Version1, signatureBytes, nil
+	case 2:
+		var version int
+		switch parts[0] {
+		case "v1":
+			version = Version1
+		case "v2":
+			version = Version2
+		default:
+			return 0, nil, fmt.Errorf("invalid signature version %q", parts[0])
+		}
+		signatureBytes, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return 0, nil, err
+		}
+		return version, signatureBytes, nil
+	default:
+		return 0, nil, errors.New("invalid signature format")
+	}
+}
@@ -1,7 +1,12 @@
 package signatures
 
 import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"testing"
@@ -38,16 +43,62 @@ func TestSignVerifyIssuedByFakes(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	intermediateCert, identityCert, err := util.ExtractCertificates(testCerts.InstanceCertificate)
+	intermediateCerts, identityCert, err := util.ExtractCertificates(testCerts.InstanceCertificate)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if err := util.Validate([]string{testCerts.CACertificate}, intermediateCert, identityCert, signingCert); err != nil {
+	if err := util.Validate([]string{testCerts.CACertificate}, intermediateCerts, identityCert, signingCert); err != nil {
 		t.Fatal(err)
 	}
 }
 
+func TestSignVerifyDER(t *testing.T) {
+	testCerts, err := certificates.Generate("doesn't", "really", "matter", "here", "10.255.181.105")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := testCerts.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Platforms that return the certificates as DER may send them base64-encoded, wrapped or not.
+	intermediateCerts, identityCert, err := util.ExtractCertificates(testCerts.InstanceCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der := base64.StdEncoding.EncodeToString(append(identityCert.Raw, intermediateCerts[0].Raw...))
+	for name, contents := range map[string]string{
+		"unwrapped": der,
+		"wrapped":   der[:64] + "\r\n" + der[64:] + "\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			signatureData := &SignatureData{
+				SigningTime:            time.Now(),
+				Role:                   "my-role",
+				CFInstanceCertContents: contents,
+			}
+			signature, err := Sign(testCerts.PathToInstanceKey, signatureData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signingCert, err := Verify(signature, signatureData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			derIntermediates, derIdentity, err := util.ExtractCertificates(contents)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := util.Validate([]string{testCerts.CACertificate}, derIntermediates, derIdentity, signingCert); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
 func TestSignVerifyIssuedByReal(t *testing.T) {
 	certBytes, err := ioutil.ReadFile("../testdata/real-certificates/instance.crt")
 	if err != nil {
@@ -85,16 +136,44 @@ func TestSignVerifyIssuedByReal(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		intermediateCert, identityCert, err := util.ExtractCertificates(string(certBytes))
+		intermediateCerts, identityCert, err := util.ExtractCertificates(string(certBytes))
 		if err != nil {
 			t.Fatal(err)
 		}
-		if err := util.Validate([]string{string(caCertBytes)}, intermediateCert, identityCert, signingCert); err == nil {
+		if err := util.Validate([]string{string(caCertBytes)}, intermediateCerts, identityCert, signingCert); err == nil {
 			t.Fatal(`expected error: x509: certificate has expired or is not yet valid`)
 		}
 	}
 }
 
+func TestVerifyRefusesPKCS1v15(t *testing.T) {
+	certBytes, err := ioutil.ReadFile("../testdata/real-certificates/instance.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := ioutil.ReadFile("../testdata/real-certificates/instance.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(keyBytes)
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatureData := &SignatureData{
+		SigningTime:            time.Now(),
+		Role:                   "my-role",
+		CFInstanceCertContents: string(certBytes),
+	}
+	signatureBytes, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, signatureData.hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify("v1:"+base64.StdEncoding.EncodeToString(signatureBytes), signatureData); err == nil {
+		t.Fatal("expected a PKCS #1 v1.5 signature to be refused")
+	}
+}
+
 // TestSignature is present to help implement the signing algorithm in other languages.
 func TestSignature(t *testing.T) {
 	sampleSigningTime := "2019-05-20T22:08:40Z"
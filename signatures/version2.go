@@ -0,0 +1,38 @@
+package signatures
+
+import "strconv"
+
+const (
+	// Version1 signatures sign the signing time, certificate, and role concatenated together,
+	// hashed with SHA-256 for RSA and P-256 keys and SHA-384 for P-384 keys.
+	Version1 = 1
+
+	// Version2 signatures sign a length-prefixed encoding of the same fields, which can't be
+	// confused for a different set of fields, followed by the audience if there is one. They're
+	// hashed with SHA-512 for RSA and ECDSA keys.
+	Version2 = 2
+)
+
+// payloadV2Prefix begins every version 2 payload, so that it can't be mistaken for data
+// signed for any other purpose.
+const payloadV2Prefix = "vault-plugin-auth-cf:v2\n"
+
+// payload returns the data that signatures of the given version are made over.
+func (s *SignatureData) payload(version int) []byte {
+	if version < Version2 {
+		return []byte(s.toSign())
+	}
+	fields := []string{s.SigningTime.UTC().Format(TimeFormat), s.CFInstanceCertContents, s.Role}
+	if s.Audience != "" {
+		fields = append(fields, s.Audience)
+	}
+	payload := []byte(payloadV2Prefix)
+	for _, field := range fields {
+		// Each field is encoded as its length in bytes, a colon, the field, and a comma.
+		payload = strconv.AppendInt(payload, int64(len(field)), 10)
+		payload = append(payload, ':')
+		payload = append(payload, field...)
+		payload = append(payload, ',')
+	}
+	return payload
+}
@@ -0,0 +1,119 @@
+package signatures
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/testing/certificates"
+)
+
+func TestSignVerifyVersion2(t *testing.T) {
+	testCerts, err := certificates.Generate("doesn't", "really", "matter", "here", "10.255.181.105")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := testCerts.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKeyBytes, err := x509.MarshalECPrivateKey(ecdsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pathToECDSAKey, ecdsaCert := generateSelfSigned(t, ecdsaKey, &pem.Block{Type: "EC PRIVATE KEY", Bytes: ecdsaKeyBytes})
+	defer os.Remove(pathToECDSAKey)
+
+	for name, tc := range map[string]struct {
+		pathToKey string
+		cert      string
+	}{
+		"rsa":   {testCerts.PathToInstanceKey, testCerts.InstanceCertificate},
+		"ecdsa": {pathToECDSAKey, ecdsaCert},
+	} {
+		t.Run(name, func(t *testing.T) {
+			signatureData := &SignatureData{
+				SigningTime:            time.Now(),
+				Role:                   "my-role",
+				CFInstanceCertContents: tc.cert,
+			}
+			signature, err := SignVersion(tc.pathToKey, nil, Version2, signatureData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(signature, "v2:") {
+				t.Fatalf("expected a v2 signature, got %s", signature)
+			}
+			if version, err := VersionOf(signature); err != nil || version != Version2 {
+				t.Fatalf("expected version 2, got %d, %v", version, err)
+			}
+			if _, err := Verify(signature, signatureData); err != nil {
+				t.Fatal(err)
+			}
+
+			// A signature bound to an audience is only accepted for that audience.
+			boundData := *signatureData
+			boundData.Audience = "auth_cf_1234"
+			boundSignature, err := SignVersion(tc.pathToKey, nil, Version2, &boundData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Verify(boundSignature, &boundData); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Verify(boundSignature, signatureData); err == nil {
+				t.Fatal("expected a signature bound to an audience to be refused without it")
+			}
+			otherData := boundData
+			otherData.Audience = "auth_cf_5678"
+			if _, err := Verify(boundSignature, &otherData); err == nil {
+				t.Fatal("expected a signature bound to an audience to be refused for another")
+			}
+			if _, err := SignVersion(tc.pathToKey, nil, Version1, &boundData); err == nil {
+				t.Fatal("expected a v1 signature to be refused an audience")
+			}
+
+			// The same signature mustn't be accepted as one of a different version.
+			if _, err := Verify("v1:"+strings.TrimPrefix(signature, "v2:"), signatureData); err == nil {
+				t.Fatal("expected a v2 signature presented as v1 to be refused")
+			}
+		})
+	}
+}
+
+func TestPayloadVersion2(t *testing.T) {
+	signingTime := time.Date(2019, 5, 20, 22, 8, 40, 0, time.UTC)
+
+	// Moving characters from one field to another mustn't produce the same payload, as it does in version 1.
+	a := &SignatureData{SigningTime: signingTime, CFInstanceCertContents: "cert", Role: "role"}
+	b := &SignatureData{SigningTime: signingTime, CFInstanceCertContents: "certrole", Role: ""}
+	if string(a.payload(Version1)) != string(b.payload(Version1)) {
+		t.Fatal("expected version 1 payloads to collide")
+	}
+	if string(a.payload(Version2)) == string(b.payload(Version2)) {
+		t.Fatal("expected version 2 payloads to differ")
+	}
+
+	expected := "vault-plugin-auth-cf:v2\n20:2019-05-20T22:08:40Z,4:cert,4:role,"
+	if actual := string(a.payload(Version2)); actual != expected {
+		t.Fatalf("expected %q, got %q", expected, actual)
+	}
+
+	a.Audience = "auth_cf_1234"
+	expected = "vault-plugin-auth-cf:v2\n20:2019-05-20T22:08:40Z,4:cert,4:role,12:auth_cf_1234,"
+	if actual := string(a.payload(Version2)); actual != expected {
+		t.Fatalf("expected %q, got %q", expected, actual)
+	}
+}
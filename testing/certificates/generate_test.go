@@ -40,13 +40,13 @@ func TestGenerate(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	intermediateCert, identityCert, err := util.ExtractCertificates(testCerts.InstanceCertificate)
+	intermediateCerts, identityCert, err := util.ExtractCertificates(testCerts.InstanceCertificate)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Make sure the signing certificate was issued by the given CA.
-	if err := util.Validate([]string{testCerts.CACertificate}, intermediateCert, identityCert, signingCert); err != nil {
+	if err := util.Validate([]string{testCerts.CACertificate}, intermediateCerts, identityCert, signingCert); err != nil {
 		t.Fatal(err)
 	}
 
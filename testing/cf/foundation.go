@@ -0,0 +1,421 @@
+package cf
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Foundation is the data served by a Server. Unlike MockServer, which serves fixed responses, a Server
+// can be given any orgs, spaces, apps, stacks, isolation segments, service instances, and tasks, so that
+// downstream projects can test logging into Vault as their own apps without a real foundation.
+type Foundation struct {
+	Orgs              []Org
+	Spaces            []Space
+	Apps              []App
+	Stacks            []Stack
+	IsolationSegments []IsolationSegment
+	ServiceInstances  []ServiceInstance
+	Tasks             []Task
+
+	// CredentialError, if set, is the description of the error UAA refuses every request for a token
+	// with, as though the credentials used had been changed or locked out.
+	CredentialError string
+
+	// Latency is how long every request waits before being answered, as though the CF API were
+	// busy or far away.
+	Latency time.Duration
+
+	// ErrorRate is the fraction of requests, from 0 to 1, that are picked at random to fail with
+	// ErrorStatus instead of being answered. Requests for tokens are never picked, since
+	// CredentialError is for failing those.
+	ErrorRate float64
+
+	// ErrorStatus is the status the requests picked by ErrorRate fail with. If zero, it's 503.
+	ErrorStatus int
+}
+
+type Org struct {
+	GUID string
+	Name string
+
+	// Status is the org's status, such as "suspended". If empty, it's "active".
+	Status string
+
+	// DefaultIsolationSegmentGUID is the GUID of one of the foundation's isolation segments,
+	// or empty if the org's apps run in the shared one by default.
+	DefaultIsolationSegmentGUID string
+}
+
+type Space struct {
+	GUID    string
+	Name    string
+	OrgGUID string
+
+	// IsolationSegmentGUID is the GUID of one of the foundation's isolation segments, or empty
+	// if the space's apps run in its org's default one.
+	IsolationSegmentGUID string
+}
+
+type App struct {
+	GUID      string
+	Name      string
+	SpaceGUID string
+
+	// Instances are the app's running instances, in order of their index. An app without
+	// instances may still run tasks.
+	Instances []Instance
+
+	// Buildpack is the buildpack the app was pushed with, if any, and DetectedBuildpack is the
+	// one CF detected for it while staging.
+	Buildpack         string
+	DetectedBuildpack string
+
+	// StackGUID is the GUID of one of the foundation's stacks.
+	StackGUID string
+
+	// Labels and Annotations are the app's metadata, which are served by the v3 API.
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+type Instance struct {
+	// IP is the instance's internal IP address, which is in its identity certificate.
+	IP string
+}
+
+type Stack struct {
+	GUID string
+	Name string
+}
+
+type IsolationSegment struct {
+	GUID string
+	Name string
+}
+
+type ServiceInstance struct {
+	GUID      string
+	Name      string
+	SpaceGUID string
+
+	// Labels and Annotations are the service instance's metadata, which are served by the v3 API.
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+type Task struct {
+	GUID    string
+	Name    string
+	AppGUID string
+
+	// State is the task's state, such as "RUNNING". If empty, it's "RUNNING".
+	State string
+}
+
+// DefaultFoundation returns a foundation holding the same org, space, app, service instance,
+// and task that MockServer serves, whose IDs are the Found constants.
+func DefaultFoundation() Foundation {
+	return Foundation{
+		Orgs:   []Org{{GUID: FoundOrgGUID, Name: FoundOrgName}},
+		Spaces: []Space{{GUID: FoundSpaceGUID, Name: FoundSpaceName, OrgGUID: FoundOrgGUID}},
+		Apps: []App{
+			{GUID: FoundAppGUID, Name: FoundAppName, SpaceGUID: FoundSpaceGUID, Instances: []Instance{{IP: FoundInstanceIP}}},
+			{GUID: FoundStoppedAppGUID, Name: FoundAppName, SpaceGUID: FoundSpaceGUID},
+		},
+		ServiceInstances: []ServiceInstance{{GUID: FoundServiceGUID, Name: FoundServiceInstanceName, SpaceGUID: FoundSpaceGUID}},
+		Tasks:            []Task{{GUID: FoundTaskGUID, Name: FoundTaskName, AppGUID: FoundStoppedAppGUID}},
+	}
+}
+
+// Server is a mock CF API serving a Foundation. It accepts any credentials.
+type Server struct {
+	*httptest.Server
+
+	mu         sync.RWMutex
+	foundation Foundation
+}
+
+// NewServer starts a mock CF API serving the given foundation. The caller should call Close
+// when finished with it.
+func NewServer(foundation Foundation) *Server {
+	s := &Server{foundation: foundation}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Update calls f with the served foundation so that it can be changed, for instance to delete
+// an app and test what happens to its tokens.
+func (s *Server) Update(f func(foundation *Foundation)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(&s.foundation)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	pathFields := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	isTokenRequest := len(pathFields) > 0 && pathFields[len(pathFields)-1] == "token"
+
+	// The lock isn't held while waiting, so that the foundation can still be updated.
+	s.mu.RLock()
+	latency, errorRate, errorStatus := s.foundation.Latency, s.foundation.ErrorRate, s.foundation.ErrorStatus
+	s.mu.RUnlock()
+	time.Sleep(latency)
+	if !isTokenRequest && errorRate > 0 && rand.Float64() < errorRate {
+		writeInjectedError(w, errorStatus)
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	switch {
+	case isTokenRequest && s.foundation.CredentialError != "":
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+			"error":             "unauthorized",
+			"error_description": s.foundation.CredentialError,
+		})
+
+	case isTokenRequest:
+		w.Header().Add("Content-Type", "application/json;charset=UTF-8")
+		w.Write([]byte(tokenResponse))
+
+	case r.URL.Path == "/v2/info":
+		w.Write([]byte(strings.Replace(infoResponse, "{{TEST_URL}}", s.URL, -1)))
+
+	case len(pathFields) == 3 && pathFields[0] == "v2" && pathFields[1] == "organizations":
+		for _, org := range s.foundation.Orgs {
+			if org.GUID == pathFields[2] {
+				status := org.Status
+				if status == "" {
+					status = "active"
+				}
+				writeV2Resource(w, org.GUID, map[string]interface{}{
+					"name":   org.Name,
+					"status": status,
+				})
+				return
+			}
+		}
+		writeNotFound(w, "CF-OrganizationNotFound", 30003, "The organization could not be found: "+pathFields[2])
+
+	case len(pathFields) == 3 && pathFields[0] == "v2" && pathFields[1] == "spaces":
+		for _, space := range s.foundation.Spaces {
+			if space.GUID == pathFields[2] {
+				writeV2Resource(w, space.GUID, map[string]interface{}{
+					"name":              space.Name,
+					"organization_guid": space.OrgGUID,
+				})
+				return
+			}
+		}
+		writeNotFound(w, "CF-SpaceNotFound", 40004, "The app space could not be found: "+pathFields[2])
+
+	case len(pathFields) == 3 && pathFields[0] == "v2" && pathFields[1] == "apps":
+		for _, app := range s.foundation.Apps {
+			if app.GUID == pathFields[2] {
+				state := "STOPPED"
+				if len(app.Instances) > 0 {
+					state = "STARTED"
+				}
+				writeV2Resource(w, app.GUID, map[string]interface{}{
+					"name":               app.Name,
+					"space_guid":         app.SpaceGUID,
+					"instances":          len(app.Instances),
+					"state":              state,
+					"buildpack":          app.Buildpack,
+					"detected_buildpack": app.DetectedBuildpack,
+					"stack_guid":         app.StackGUID,
+				})
+				return
+			}
+		}
+		writeNotFound(w, "CF-AppNotFound", 100004, "The app could not be found: "+pathFields[2])
+
+	case len(pathFields) == 3 && pathFields[0] == "v2" && pathFields[1] == "stacks":
+		for _, stack := range s.foundation.Stacks {
+			if stack.GUID == pathFields[2] {
+				writeV2Resource(w, stack.GUID, map[string]interface{}{
+					"name": stack.Name,
+				})
+				return
+			}
+		}
+		writeNotFound(w, "CF-StackNotFound", 250003, "The stack could not be found: "+pathFields[2])
+
+	case len(pathFields) == 3 && pathFields[0] == "v2" && pathFields[1] == "service_instances":
+		for _, serviceInstance := range s.foundation.ServiceInstances {
+			if serviceInstance.GUID == pathFields[2] {
+				writeV2Resource(w, serviceInstance.GUID, map[string]interface{}{
+					"name":       serviceInstance.Name,
+					"space_guid": serviceInstance.SpaceGUID,
+					"type":       "managed_service_instance",
+				})
+				return
+			}
+		}
+		writeNotFound(w, "CF-ServiceInstanceNotFound", 60004, "The service instance could not be found: "+pathFields[2])
+
+	case len(pathFields) == 5 && pathFields[0] == "v3" && pathFields[1] == "spaces" && pathFields[3] == "relationships" && pathFields[4] == "isolation_segment":
+		for _, space := range s.foundation.Spaces {
+			if space.GUID == pathFields[2] {
+				writeRelationship(w, space.IsolationSegmentGUID)
+				return
+			}
+		}
+		writeNotFound(w, "CF-ResourceNotFound", 10010, "Space not found")
+
+	case len(pathFields) == 5 && pathFields[0] == "v3" && pathFields[1] == "organizations" && pathFields[3] == "relationships" && pathFields[4] == "default_isolation_segment":
+		for _, org := range s.foundation.Orgs {
+			if org.GUID == pathFields[2] {
+				writeRelationship(w, org.DefaultIsolationSegmentGUID)
+				return
+			}
+		}
+		writeNotFound(w, "CF-ResourceNotFound", 10010, "Organization not found")
+
+	case len(pathFields) == 3 && pathFields[0] == "v3" && pathFields[1] == "isolation_segments":
+		for _, segment := range s.foundation.IsolationSegments {
+			if segment.GUID == pathFields[2] {
+				writeJSON(w, http.StatusOK, map[string]interface{}{
+					"guid": segment.GUID,
+					"name": segment.Name,
+				})
+				return
+			}
+		}
+		writeNotFound(w, "CF-ResourceNotFound", 10010, "Isolation segment not found")
+
+	case len(pathFields) == 6 && pathFields[0] == "v3" && pathFields[1] == "apps" && pathFields[3] == "processes" && pathFields[5] == "stats":
+		for _, app := range s.foundation.Apps {
+			if app.GUID == pathFields[2] {
+				resources := make([]map[string]interface{}, 0, len(app.Instances))
+				for i, instance := range app.Instances {
+					resources = append(resources, map[string]interface{}{
+						"type":                 pathFields[4],
+						"index":                i,
+						"state":                "RUNNING",
+						"instance_internal_ip": instance.IP,
+					})
+				}
+				writeJSON(w, http.StatusOK, map[string]interface{}{"resources": resources})
+				return
+			}
+		}
+		writeNotFound(w, "CF-ResourceNotFound", 10010, "App not found")
+
+	case len(pathFields) == 3 && pathFields[0] == "v3" && pathFields[1] == "apps":
+		for _, app := range s.foundation.Apps {
+			if app.GUID == pathFields[2] {
+				writeJSON(w, http.StatusOK, map[string]interface{}{
+					"guid":     app.GUID,
+					"name":     app.Name,
+					"metadata": v3Metadata(app.Labels, app.Annotations),
+				})
+				return
+			}
+		}
+		writeNotFound(w, "CF-ResourceNotFound", 10010, "App not found")
+
+	case len(pathFields) == 3 && pathFields[0] == "v3" && pathFields[1] == "service_instances":
+		for _, serviceInstance := range s.foundation.ServiceInstances {
+			if serviceInstance.GUID == pathFields[2] {
+				writeJSON(w, http.StatusOK, map[string]interface{}{
+					"guid":     serviceInstance.GUID,
+					"name":     serviceInstance.Name,
+					"metadata": v3Metadata(serviceInstance.Labels, serviceInstance.Annotations),
+				})
+				return
+			}
+		}
+		writeNotFound(w, "CF-ResourceNotFound", 10010, "Service instance not found")
+
+	case len(pathFields) == 3 && pathFields[0] == "v3" && pathFields[1] == "tasks":
+		for _, task := range s.foundation.Tasks {
+			if task.GUID == pathFields[2] {
+				state := task.State
+				if state == "" {
+					state = "RUNNING"
+				}
+				writeJSON(w, http.StatusOK, map[string]interface{}{
+					"guid":  task.GUID,
+					"name":  task.Name,
+					"state": state,
+					"links": map[string]interface{}{
+						"app": map[string]string{"href": fmt.Sprintf("%s/v3/apps/%s", s.URL, task.AppGUID)},
+					},
+				})
+				return
+			}
+		}
+		writeNotFound(w, "CF-ResourceNotFound", 10010, "Task not found")
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("unexpected path: %s", r.URL.Path)))
+	}
+}
+
+// writeV2Resource writes a resource in the format used by version 2 of the CF API.
+func writeV2Resource(w http.ResponseWriter, guid string, entity map[string]interface{}) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"metadata": map[string]interface{}{"guid": guid},
+		"entity":   entity,
+	})
+}
+
+// v3Metadata is the metadata of a resource in the format used by version 3 of the CF API, which
+// has empty objects rather than nulls when there are no labels or annotations.
+func v3Metadata(labels, annotations map[string]string) map[string]interface{} {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	return map[string]interface{}{
+		"labels":      labels,
+		"annotations": annotations,
+	}
+}
+
+// writeRelationship writes a to-one relationship in the format used by version 3 of the CF API,
+// whose data is null if the GUID is empty.
+func writeRelationship(w http.ResponseWriter, guid string) {
+	var data interface{}
+	if guid != "" {
+		data = map[string]string{"guid": guid}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+// writeNotFound writes an error in the format the CF API uses, which its client recognizes.
+func writeNotFound(w http.ResponseWriter, errorCode string, code int, description string) {
+	writeJSON(w, http.StatusNotFound, map[string]interface{}{
+		"description": description,
+		"error_code":  errorCode,
+		"code":        code,
+	})
+}
+
+// writeInjectedError writes an error the way the gorouter does when the CF API is unreachable,
+// as plain text rather than in the CF API's own format.
+func writeInjectedError(w http.ResponseWriter, status int) {
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%d %s\n", status, http.StatusText(status))
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,95 @@
+package cf
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LoadResult describes how the logins driven by Load went.
+type LoadResult struct {
+	Logins   int
+	Failures int
+
+	// Errors are the errors of the failed logins, in the order they were started.
+	Errors []error
+
+	// Elapsed is how long all the logins took, and Latencies how long each took, from fastest to slowest.
+	Elapsed   time.Duration
+	Latencies []time.Duration
+}
+
+// Load calls login the given number of times, from the given number of goroutines at once, and
+// reports how long the calls took and which failed. Each call is given its index, so that it can
+// log in as a different instance. Load is for testing how the backend behaves when many instances
+// log in at once, such as when a large app is restarted, against a Server with Latency or ErrorRate set.
+func Load(logins, concurrency int, login func(i int) error) *LoadResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	errs := make([]error, logins)
+	latencies := make([]time.Duration, logins)
+	indexes := make(chan int)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				loginStart := time.Now()
+				errs[i] = login(i)
+				latencies[i] = time.Since(loginStart)
+			}
+		}()
+	}
+	for i := 0; i < logins; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	result := &LoadResult{
+		Logins:    logins,
+		Elapsed:   time.Since(start),
+		Latencies: latencies,
+	}
+	for _, err := range errs {
+		if err != nil {
+			result.Failures++
+			result.Errors = append(result.Errors, err)
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return result
+}
+
+// Percentile returns the latency that the given percentage of logins took at most, or 0 if there
+// were none.
+func (r *LoadResult) Percentile(p int) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	i := (len(r.Latencies)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return r.Latencies[i]
+}
+
+// Throughput returns how many logins were completed per second.
+func (r *LoadResult) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Logins) / r.Elapsed.Seconds()
+}
+
+func (r *LoadResult) String() string {
+	return fmt.Sprintf("%d logins, %d failed, in %s (%.1f/s); p50 %s, p90 %s, p99 %s, max %s",
+		r.Logins, r.Failures, r.Elapsed.Round(time.Millisecond), r.Throughput(),
+		r.Percentile(50).Round(time.Microsecond), r.Percentile(90).Round(time.Microsecond),
+		r.Percentile(99).Round(time.Microsecond), r.Percentile(100).Round(time.Microsecond))
+}
@@ -18,13 +18,19 @@ const (
 	AuthClientID     = "ClientID"
 	AuthClientSecret = "ClientSecret"
 
-	FoundServiceGUID = "1bf2e7f6-2d1d-41ec-501c-c70"
-	FoundAppGUID     = "2d3e834a-3a25-4591-974c-fa5626d5d0a1"
-	FoundOrgGUID     = "34a878d0-c2f9-4521-ba73-a9f664e82c7bf"
-	FoundSpaceGUID   = "3d2eba6b-ef19-44d5-91dd-1975b0db5cc9"
-	FoundAppName     = "name-2401"
-	FoundSpaceName   = "cfdev-space"
-	FoundOrgName     = "system"
+	FoundServiceGUID         = "1bf2e7f6-2d1d-41ec-501c-c70"
+	FoundAppGUID             = "2d3e834a-3a25-4591-974c-fa5626d5d0a1"
+	FoundOrgGUID             = "34a878d0-c2f9-4521-ba73-a9f664e82c7bf"
+	FoundSpaceGUID           = "3d2eba6b-ef19-44d5-91dd-1975b0db5cc9"
+	FoundAppName             = "name-2401"
+	FoundSpaceName           = "cfdev-space"
+	FoundOrgName             = "system"
+	FoundServiceInstanceName = "name-1508"
+	FoundStoppedAppGUID      = "7e0c3e54-57a6-4c5a-8d2b-f3c1d0a8a1b6"
+	FoundTaskGUID            = "d5cdc3e8-0f2b-4c5e-9a7d-2c1f5d6e7b8a"
+	FoundTaskName            = "migrate"
+	FoundInstanceIP          = "10.255.181.105"
+	FoundInstanceIndex       = 0
 
 	UnfoundServiceGUID = "service-id-unfound"
 	UnfoundAppGUID     = "app-id-unfound"
@@ -69,6 +75,16 @@ func MockServer(loud bool, casToTrust []string) *httptest.Server {
 			w.WriteHeader(200)
 			w.Write([]byte(appResponse))
 
+		case FoundStoppedAppGUID:
+			// The stopped app has no instances, but is running a task.
+			w.WriteHeader(200)
+			stoppedAppResponse := strings.Replace(appResponse, FoundAppGUID, FoundStoppedAppGUID, -1)
+			w.Write([]byte(strings.Replace(stoppedAppResponse, `"instances": 1`, `"instances": 0`, 1)))
+
+		case FoundTaskGUID:
+			w.WriteHeader(200)
+			w.Write([]byte(taskResponse))
+
 		case UnfoundAppGUID:
 			w.WriteHeader(404)
 			w.Write([]byte(unfoundAppResponse))
@@ -89,6 +105,10 @@ func MockServer(loud bool, casToTrust []string) *httptest.Server {
 			w.WriteHeader(404)
 			w.Write([]byte(unfoundSpaceResponse))
 
+		case "stats":
+			w.WriteHeader(200)
+			w.Write([]byte(processStatsResponse))
+
 		default:
 			w.WriteHeader(400)
 			w.Write([]byte(fmt.Sprintf("unexpected object identifier: %s", lastPathField)))
@@ -307,4 +327,52 @@ const (
 	"error_code": "CF-SpaceNotFound",
 	"code": 40004
 }`
+
+	taskResponse = `{
+	"guid": "d5cdc3e8-0f2b-4c5e-9a7d-2c1f5d6e7b8a",
+	"sequence_id": 1,
+	"name": "migrate",
+	"command": "rake db:migrate",
+	"state": "RUNNING",
+	"memory_in_mb": 512,
+	"disk_in_mb": 1024,
+	"result": {
+		"failure_reason": null
+	},
+	"droplet_guid": "740ebd2b-162b-469a-bd72-3edb96fabd9a",
+	"created_at": "2016-05-04T17:00:41Z",
+	"updated_at": "2016-05-04T17:00:42Z",
+	"links": {
+		"self": {
+			"href": "https://api.example.org/v3/tasks/d5cdc3e8-0f2b-4c5e-9a7d-2c1f5d6e7b8a"
+		},
+		"app": {
+			"href": "https://api.example.org/v3/apps/7e0c3e54-57a6-4c5a-8d2b-f3c1d0a8a1b6"
+		},
+		"droplet": {
+			"href": "https://api.example.org/v3/droplets/740ebd2b-162b-469a-bd72-3edb96fabd9a"
+		}
+	}
+}`
+
+	processStatsResponse = `{
+	"resources": [
+		{
+			"type": "web",
+			"index": 0,
+			"state": "RUNNING",
+			"host": "10.0.16.9",
+			"instance_internal_ip": "10.255.181.105",
+			"uptime": 1200
+		},
+		{
+			"type": "web",
+			"index": 1,
+			"state": "RUNNING",
+			"host": "10.0.16.10",
+			"instance_internal_ip": "10.255.181.106",
+			"uptime": 1200
+		}
+	]
+}`
 )
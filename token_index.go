@@ -0,0 +1,237 @@
+package cf
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const tokenIndexStoragePrefix = "index/tokens/"
+
+func putTokenIndexEntry(ctx context.Context, storage logical.Storage, indexEntry *models.TokenIndexEntry) error {
+	entry, err := logical.StorageEntryJSON(tokenIndexStoragePrefix+indexEntry.AppID, indexEntry)
+	if err != nil {
+		return err
+	}
+	return storage.Put(ctx, entry)
+}
+
+func getTokenIndexEntry(ctx context.Context, storage logical.Storage, appID string) (*models.TokenIndexEntry, error) {
+	entry, err := storage.Get(ctx, tokenIndexStoragePrefix+appID)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	indexEntry := &models.TokenIndexEntry{}
+	if err := entry.DecodeJSON(indexEntry); err != nil {
+		return nil, err
+	}
+	return indexEntry, nil
+}
+
+// tokenIndexRenewalInterval is how long after a token's last recorded renewal its renewals are
+// recorded again. Tokens are only tidied once they haven't been renewed for longer than the
+// system's max TTL, so this needn't be precise, and recording less often saves a storage write
+// for most renewals.
+const tokenIndexRenewalInterval = 10 * time.Minute
+
+// tokenLoginKey is where the token issued to the given app with the given login ID is stored until
+// it's first renewed.
+func tokenLoginKey(appID, loginID string) string {
+	return tokenIndexStoragePrefix + appID + "/" + loginID
+}
+
+// getTokenLogins returns when each of the app's tokens that haven't been renewed was issued, by
+// their login IDs.
+func getTokenLogins(ctx context.Context, storage logical.Storage, appID string) (map[string]time.Time, error) {
+	loginIDs, err := storage.List(ctx, tokenLoginKey(appID, ""))
+	if err != nil {
+		return nil, err
+	}
+	logins := make(map[string]time.Time, len(loginIDs))
+	for _, loginID := range loginIDs {
+		entry, err := storage.Get(ctx, tokenLoginKey(appID, loginID))
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		loginEntry := &models.TokenLoginEntry{}
+		if err := entry.DecodeJSON(loginEntry); err != nil {
+			return nil, err
+		}
+		logins[loginID] = loginEntry.IssuedAt
+	}
+	return logins, nil
+}
+
+// indexTokenLogin records that a token was issued to the given app at the given time, under the
+// ID given to it at login. Only the token's own record is written, besides the app's the first
+// time it logs in, so logins cost the same however many tokens the app has.
+func (b *backend) indexTokenLogin(ctx context.Context, storage logical.Storage, appID, spaceID, loginID string, now time.Time) error {
+	lock := locksutil.LockForKey(b.tokenIndexLocks, appID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	indexEntry, err := getTokenIndexEntry(ctx, storage, appID)
+	if err != nil {
+		return err
+	}
+	if indexEntry == nil {
+		// The app is recorded so it's listed along with the apps whose tokens have been renewed.
+		if err := putTokenIndexEntry(ctx, storage, &models.TokenIndexEntry{
+			AppID:   appID,
+			SpaceID: spaceID,
+		}); err != nil {
+			return err
+		}
+	}
+	entry, err := logical.StorageEntryJSON(tokenLoginKey(appID, loginID), &models.TokenLoginEntry{IssuedAt: now})
+	if err != nil {
+		return err
+	}
+	return storage.Put(ctx, entry)
+}
+
+// indexTokenRenewal records that the token with the given accessor, issued to the given app, was
+// renewed at the given time. Its login ID, which is empty for tokens issued before logins were
+// indexed, is replaced by the accessor. Renewals within tokenIndexRenewalInterval of the last one
+// recorded for the token aren't written.
+func (b *backend) indexTokenRenewal(ctx context.Context, storage logical.Storage, appID, spaceID, loginID, accessor string, now time.Time) error {
+	lock := locksutil.LockForKey(b.tokenIndexLocks, appID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	indexEntry, err := getTokenIndexEntry(ctx, storage, appID)
+	if err != nil {
+		return err
+	}
+	if indexEntry == nil {
+		indexEntry = &models.TokenIndexEntry{
+			AppID:   appID,
+			SpaceID: spaceID,
+		}
+	}
+	if lastRenewal, ok := indexEntry.Accessors[accessor]; ok && now.Sub(lastRenewal) < tokenIndexRenewalInterval {
+		return nil
+	}
+	if indexEntry.Accessors == nil {
+		indexEntry.Accessors = make(map[string]time.Time)
+	}
+	indexEntry.Accessors[accessor] = now
+	if err := putTokenIndexEntry(ctx, storage, indexEntry); err != nil {
+		return err
+	}
+	// The token's first renewal is always recorded, since it's never been recorded by its accessor.
+	if loginID != "" {
+		return storage.Delete(ctx, tokenLoginKey(appID, loginID))
+	}
+	return nil
+}
+
+// deleteTokenIndex forgets the app's indexed tokens, lifting its cut-off if it was cut off.
+func (b *backend) deleteTokenIndex(ctx context.Context, storage logical.Storage, appID string) error {
+	lock := locksutil.LockForKey(b.tokenIndexLocks, appID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	loginIDs, err := storage.List(ctx, tokenLoginKey(appID, ""))
+	if err != nil {
+		return err
+	}
+	for _, loginID := range loginIDs {
+		if err := storage.Delete(ctx, tokenLoginKey(appID, loginID)); err != nil {
+			return err
+		}
+	}
+	return storage.Delete(ctx, tokenIndexStoragePrefix+appID)
+}
+
+// listTokenIndexApps returns the IDs of the apps with indexed tokens, or that have been cut off.
+func listTokenIndexApps(ctx context.Context, storage logical.Storage) ([]string, error) {
+	keys, err := storage.List(ctx, tokenIndexStoragePrefix)
+	if err != nil {
+		return nil, err
+	}
+	// An app's unrenewed tokens are listed under it as well as its own record.
+	appIDs := make([]string, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		appID := strings.TrimSuffix(key, "/")
+		if !seen[appID] {
+			seen[appID] = true
+			appIDs = append(appIDs, appID)
+		}
+	}
+	return appIDs, nil
+}
+
+// tidyTokenIndex removes the indexed tokens that haven't been renewed since before the cutoff,
+// or were issued before it and never renewed, and then the apps without any indexed tokens that
+// haven't been cut off. It returns how many tokens were removed.
+func (b *backend) tidyTokenIndex(ctx context.Context, storage logical.Storage, cutoff time.Time) (int, error) {
+	appIDs, err := listTokenIndexApps(ctx, storage)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, appID := range appIDs {
+		tokensRemoved, err := b.tidyAppTokenIndex(ctx, storage, appID, cutoff)
+		removed += tokensRemoved
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// tidyAppTokenIndex tidies the indexed tokens of one app, as tidyTokenIndex describes.
+func (b *backend) tidyAppTokenIndex(ctx context.Context, storage logical.Storage, appID string, cutoff time.Time) (int, error) {
+	lock := locksutil.LockForKey(b.tokenIndexLocks, appID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	removed := 0
+	logins, err := getTokenLogins(ctx, storage, appID)
+	if err != nil {
+		return 0, err
+	}
+	for loginID, issued := range logins {
+		if issued.Before(cutoff) {
+			if err := storage.Delete(ctx, tokenLoginKey(appID, loginID)); err != nil {
+				return removed, err
+			}
+			delete(logins, loginID)
+			removed++
+		}
+	}
+
+	indexEntry, err := getTokenIndexEntry(ctx, storage, appID)
+	if err != nil || indexEntry == nil {
+		return removed, err
+	}
+	accessorsRemoved := 0
+	for accessor, lastRenewal := range indexEntry.Accessors {
+		if lastRenewal.Before(cutoff) {
+			delete(indexEntry.Accessors, accessor)
+			accessorsRemoved++
+		}
+	}
+	switch {
+	case len(indexEntry.Accessors) == 0 && len(logins) == 0 && !indexEntry.Revoked():
+		err = storage.Delete(ctx, tokenIndexStoragePrefix+appID)
+	case accessorsRemoved > 0:
+		err = putTokenIndexEntry(ctx, storage, indexEntry)
+	}
+	if err != nil {
+		return removed, err
+	}
+	return removed + accessorsRemoved, nil
+}
@@ -0,0 +1,140 @@
+package cf
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/testing/cf"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestTokenIndex(t *testing.T) {
+	b := newTestBackend(t)
+	ctx, storage := b.ctx, b.storage
+	now := time.Now().UTC()
+
+	if err := b.indexTokenLogin(ctx, storage, "app-id", "space-id", "login-id", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.indexTokenLogin(ctx, storage, "app-id", "space-id", "unrenewed-login-id", now); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first renewal replaces the token's login ID with its accessor.
+	if err := b.indexTokenRenewal(ctx, storage, "app-id", "space-id", "login-id", "accessor", now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	indexEntry, err := getTokenIndexEntry(ctx, storage, "app-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logins, err := getTokenLogins(ctx, storage, "app-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := logins["login-id"]; ok || !indexEntry.Accessors["accessor"].Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected the token to be indexed by its accessor but received %#v and %v", indexEntry, logins)
+	}
+	if appIDs, err := listTokenIndexApps(ctx, storage); err != nil || len(appIDs) != 1 || appIDs[0] != "app-id" {
+		t.Fatalf("expected the app to be listed once but received %v, %v", appIDs, err)
+	}
+
+	// Later renewals are only recorded once the last one recorded is old enough.
+	if err := b.indexTokenRenewal(ctx, storage, "app-id", "space-id", "login-id", "accessor", now.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if indexEntry, err = getTokenIndexEntry(ctx, storage, "app-id"); err != nil {
+		t.Fatal(err)
+	}
+	if !indexEntry.Accessors["accessor"].Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected the recent renewal not to be recorded but received %s", indexEntry.Accessors["accessor"])
+	}
+	renewed := now.Add(time.Minute + tokenIndexRenewalInterval)
+	if err := b.indexTokenRenewal(ctx, storage, "app-id", "space-id", "login-id", "accessor", renewed); err != nil {
+		t.Fatal(err)
+	}
+	if indexEntry, err = getTokenIndexEntry(ctx, storage, "app-id"); err != nil {
+		t.Fatal(err)
+	}
+	if !indexEntry.Accessors["accessor"].Equal(renewed) {
+		t.Fatalf("expected the renewal to be recorded but received %s", indexEntry.Accessors["accessor"])
+	}
+
+	// Tokens that were never renewed are tidied once they were issued before the cutoff.
+	removed, err := b.tidyTokenIndex(ctx, storage, now.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Fatalf("expected 1 token to be removed but received %d", removed)
+	}
+	if indexEntry, err = getTokenIndexEntry(ctx, storage, "app-id"); err != nil {
+		t.Fatal(err)
+	}
+	if logins, err = getTokenLogins(ctx, storage, "app-id"); err != nil {
+		t.Fatal(err)
+	}
+	if len(logins) != 0 || len(indexEntry.Accessors) != 1 {
+		t.Fatalf("expected only the renewed token to be left but received %#v and %v", indexEntry, logins)
+	}
+}
+
+func TestTokenIndexRevokeDuringLogins(t *testing.T) {
+	env := newLoadTestEnv(t)
+	defer env.close()
+	storage := &pausingStorage{
+		Storage:  env.storage,
+		prefix:   tokenIndexStoragePrefix,
+		paused:   make(chan struct{}),
+		resume:   make(chan struct{}),
+		maxPause: 100 * time.Millisecond,
+	}
+	req := env.loginRequest(t)
+	req.Storage = storage
+
+	// The first login to index its token is paused between reading the index and writing it back,
+	// and the app is cut off meanwhile. Unless it's locked out, the revoke is lost when it resumes.
+	done := make(chan *cf.LoadResult)
+	go func() {
+		done <- cf.Load(20, 5, func(int) error { return env.login(req) })
+	}()
+	<-storage.paused
+	env.mustHandle(logical.UpdateOperation, "tokens/by-app/"+cf.FoundAppGUID+"/revoke", nil)
+	close(storage.resume)
+	<-done
+
+	indexEntry, err := getTokenIndexEntry(env.ctx, env.storage, cf.FoundAppGUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indexEntry == nil || !indexEntry.Revoked() {
+		t.Fatalf("expected the app to still be cut off but received %#v", indexEntry)
+	}
+}
+
+// pausingStorage pauses the first write of a key with the given prefix, after closing paused, until
+// resume is closed or maxPause has passed.
+type pausingStorage struct {
+	logical.Storage
+	prefix   string
+	paused   chan struct{}
+	resume   chan struct{}
+	maxPause time.Duration
+	once     sync.Once
+}
+
+func (s *pausingStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	if strings.HasPrefix(entry.Key, s.prefix) {
+		s.once.Do(func() {
+			close(s.paused)
+			select {
+			case <-s.resume:
+			case <-time.After(s.maxPause):
+			}
+		})
+	}
+	return s.Storage.Put(ctx, entry)
+}
@@ -0,0 +1,179 @@
+package cf
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// These are the values accepted for "token_quota_scope".
+const (
+	tokenQuotaScopeAppID      = "app_id"
+	tokenQuotaScopeInstanceID = "instance_id"
+)
+
+// These are the values accepted for "token_quota_action".
+const (
+	tokenQuotaActionReject            = "reject"
+	tokenQuotaActionDenyOldestRenewal = "deny_oldest_renewal"
+)
+
+const tokenQuotaStoragePrefix = "quota/"
+
+func tokenQuotaScope(role *models.RoleEntry) string {
+	if role.TokenQuotaScope == "" {
+		return tokenQuotaScopeAppID
+	}
+	return role.TokenQuotaScope
+}
+
+func tokenQuotaAction(role *models.RoleEntry) string {
+	if role.TokenQuotaAction == "" {
+		return tokenQuotaActionReject
+	}
+	return role.TokenQuotaAction
+}
+
+// tokenQuotaKey returns where the live tokens counted against the role's quota for the app or instance
+// are recorded.
+func tokenQuotaKey(roleName string, role *models.RoleEntry, appID, instanceID string) string {
+	id := appID
+	// Service instances have no app, so each is counted by itself.
+	if tokenQuotaScope(role) == tokenQuotaScopeInstanceID || appID == "" {
+		id = instanceID
+	}
+	return tokenQuotaStoragePrefix + roleName + "/" + tokenQuotaScope(role) + "/" + id
+}
+
+// tokenQuotaTTL returns how long a token issued through the role lives unless it's renewed.
+func (b *backend) tokenQuotaTTL(role *models.RoleEntry) time.Duration {
+	switch {
+	case role.TokenPeriod > 0:
+		return role.TokenPeriod
+	case role.TokenTTL > 0:
+		return role.TokenTTL
+	default:
+		return b.System().DefaultLeaseTTL()
+	}
+}
+
+func getTokenQuotaEntry(ctx context.Context, storage logical.Storage, key string) (*models.TokenQuotaEntry, error) {
+	entry, err := storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	quotaEntry := &models.TokenQuotaEntry{}
+	if entry != nil {
+		if err := entry.DecodeJSON(quotaEntry); err != nil {
+			return nil, err
+		}
+	}
+	if quotaEntry.Tokens == nil {
+		quotaEntry.Tokens = make(map[string]time.Time)
+	}
+	return quotaEntry, nil
+}
+
+func putTokenQuotaEntry(ctx context.Context, storage logical.Storage, key string, quotaEntry *models.TokenQuotaEntry) error {
+	if len(quotaEntry.Tokens) == 0 {
+		return storage.Delete(ctx, key)
+	}
+	entry, err := logical.StorageEntryJSON(key, quotaEntry)
+	if err != nil {
+		return err
+	}
+	return storage.Put(ctx, entry)
+}
+
+// claimTokenQuota counts a new token against the quota recorded at the given key, returning the ID to
+// record on the token. If the quota is full, the login is refused with a *loginFailure, or the oldest
+// live token is displaced, as the role says. Plugins can't revoke tokens, so a displaced token is only
+// refused its next renewal, and stays valid until then.
+func (b *backend) claimTokenQuota(ctx context.Context, storage logical.Storage, key string, role *models.RoleEntry, now time.Time) (string, error) {
+	lock := locksutil.LockForKey(b.tokenQuotaLocks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	quotaEntry, err := getTokenQuotaEntry(ctx, storage, key)
+	if err != nil {
+		return "", err
+	}
+	for id, expiresAt := range quotaEntry.Tokens {
+		if !now.Before(expiresAt) {
+			delete(quotaEntry.Tokens, id)
+		}
+	}
+	for len(quotaEntry.Tokens) >= role.TokenQuota {
+		if tokenQuotaAction(role) == tokenQuotaActionReject {
+			return "", newLoginFailure(failureCategoryQuotaExceeded, fmt.Errorf("the role's token_quota of %d live tokens has been reached", role.TokenQuota))
+		}
+		delete(quotaEntry.Tokens, quotaEntry.Oldest())
+		b.Logger().Warn("a token quota was reached, so the oldest counted token will be refused its next renewal", "key", key)
+	}
+
+	id, err := uuid.GenerateUUID()
+	if err != nil {
+		return "", err
+	}
+	quotaEntry.Tokens[id] = now.Add(b.tokenQuotaTTL(role))
+	if err := putTokenQuotaEntry(ctx, storage, key, quotaEntry); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// renewTokenQuota extends the life of a token counted against a quota, returning false if it's no
+// longer counted because newer tokens displaced it.
+func (b *backend) renewTokenQuota(ctx context.Context, storage logical.Storage, key, id string, role *models.RoleEntry, now time.Time) (bool, error) {
+	lock := locksutil.LockForKey(b.tokenQuotaLocks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	quotaEntry, err := getTokenQuotaEntry(ctx, storage, key)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := quotaEntry.Tokens[id]; !ok {
+		return false, nil
+	}
+	quotaEntry.Tokens[id] = now.Add(b.tokenQuotaTTL(role))
+	return true, putTokenQuotaEntry(ctx, storage, key, quotaEntry)
+}
+
+// tidyTokenQuotas removes the counted tokens that expired before the cutoff, and returns how many
+// were removed.
+func (b *backend) tidyTokenQuotas(ctx context.Context, storage logical.Storage, cutoff time.Time) (int, error) {
+	keys, err := logical.CollectKeysWithPrefix(ctx, storage, tokenQuotaStoragePrefix)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, key := range keys {
+		lock := locksutil.LockForKey(b.tokenQuotaLocks, key)
+		lock.Lock()
+		quotaEntry, err := getTokenQuotaEntry(ctx, storage, key)
+		if err == nil {
+			tokensRemoved := 0
+			for id, expiresAt := range quotaEntry.Tokens {
+				if expiresAt.Before(cutoff) {
+					delete(quotaEntry.Tokens, id)
+					tokensRemoved++
+				}
+			}
+			if tokensRemoved > 0 || len(quotaEntry.Tokens) == 0 {
+				err = putTokenQuotaEntry(ctx, storage, key, quotaEntry)
+			}
+			removed += tokensRemoved
+		}
+		lock.Unlock()
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
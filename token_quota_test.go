@@ -0,0 +1,71 @@
+package cf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/helper/tokenutil"
+)
+
+func TestTokenQuota(t *testing.T) {
+	b := newTestBackend(t)
+	ctx, storage := b.ctx, b.storage
+	now := time.Now()
+
+	role := &models.RoleEntry{
+		TokenParams: tokenutil.TokenParams{TokenTTL: time.Hour},
+		TokenQuota:  2,
+	}
+	key := tokenQuotaKey("test-role", role, "app-id", "instance-id")
+	if key != tokenQuotaKey("test-role", role, "app-id", "other-instance-id") {
+		t.Fatal("expected instances of an app to share a quota")
+	}
+	first, err := b.claimTokenQuota(ctx, storage, key, role, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.claimTokenQuota(ctx, storage, key, role, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Logins beyond the quota are refused.
+	_, err = b.claimTokenQuota(ctx, storage, key, role, now.Add(2*time.Minute))
+	if failure, ok := err.(*loginFailure); !ok || failure.category != failureCategoryQuotaExceeded {
+		t.Fatalf("expected the quota to be exceeded but received %v", err)
+	}
+
+	// Until a token expires.
+	if _, err := b.claimTokenQuota(ctx, storage, key, role, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if counted, err := b.renewTokenQuota(ctx, storage, key, first, role, now.Add(time.Hour)); err != nil || counted {
+		t.Fatalf("expected the expired token to no longer be counted but received %t, %v", counted, err)
+	}
+
+	// Or displace the oldest token, if the role says so.
+	role.TokenQuotaAction = tokenQuotaActionDenyOldestRenewal
+	quotaEntry, err := getTokenQuotaEntry(ctx, storage, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldest := quotaEntry.Oldest()
+	if _, err := b.claimTokenQuota(ctx, storage, key, role, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if counted, err := b.renewTokenQuota(ctx, storage, key, oldest, role, now.Add(time.Hour)); err != nil || counted {
+		t.Fatalf("expected the oldest token to be displaced but received %t, %v", counted, err)
+	}
+
+	// Tidying removes every token once they've all expired.
+	removed, err := b.tidyTokenQuotas(ctx, storage, now.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Fatalf("expected 2 tokens to be removed but received %d", removed)
+	}
+	if keys, err := storage.List(ctx, tokenQuotaStoragePrefix); err != nil || len(keys) != 0 {
+		t.Fatalf("expected no quotas to be left but received %v, %v", keys, err)
+	}
+}
@@ -0,0 +1,130 @@
+package cf
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// storageVersionKey is where the version of the layout that everything else is stored in is kept.
+const storageVersionKey = "storage_version"
+
+// storageVersionEntry is the version of the storage layout as it's reflected in Vault's storage system.
+// Storage written before the layout was versioned is at version 0.
+type storageVersionEntry struct {
+	Version int `json:"version"`
+}
+
+// storageUpgrade moves storage from the previous version of the layout to the given one.
+type storageUpgrade struct {
+	version     int
+	description string
+	upgrade     func(ctx context.Context, storage logical.Storage) error
+}
+
+// storageUpgrades are run in order when the backend is initialized, skipping any that storage is
+// already past. The version is only stored once an upgrade finishes, so upgrades must be safe to run
+// again if they're interrupted. Since older versions of Vault don't initialize plugins, entries must
+// still be readable in every earlier layout.
+var storageUpgrades = []storageUpgrade{
+	{
+		version:     1,
+		description: "move the deprecated pcf_* config fields to their cf_* replacements",
+		upgrade:     upgradeConfigFields,
+	},
+	{
+		version:     2,
+		description: "copy the deprecated token settings of roles to their token_* replacements",
+		upgrade:     upgradeRoleTokenFields,
+	},
+}
+
+// currentStorageVersion is the version of the layout that this backend writes.
+var currentStorageVersion = storageUpgrades[len(storageUpgrades)-1].version
+
+// initialize is called by Vault just after the backend is mounted, and upgrades storage to the
+// current layout.
+func (b *backend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
+	// Replicated storage can only be written to by the active node of the primary cluster,
+	// which will upgrade it for everyone.
+	replicationState := b.System().ReplicationState()
+	if replicationState.HasState(consts.ReplicationPerformanceStandby) ||
+		(replicationState.HasState(consts.ReplicationPerformanceSecondary) && !b.System().LocalMount()) {
+		return nil
+	}
+	return b.upgradeStorage(ctx, req.Storage)
+}
+
+func (b *backend) upgradeStorage(ctx context.Context, storage logical.Storage) error {
+	version, err := storageVersion(ctx, storage)
+	if err != nil {
+		return err
+	}
+	for _, upgrade := range storageUpgrades {
+		if upgrade.version <= version {
+			continue
+		}
+		b.Logger().Info("upgrading storage", "version", upgrade.version, "description", upgrade.description)
+		if err := upgrade.upgrade(ctx, storage); err != nil {
+			return fmt.Errorf("unable to upgrade storage to version %d: %s", upgrade.version, err)
+		}
+		entry, err := logical.StorageEntryJSON(storageVersionKey, &storageVersionEntry{Version: upgrade.version})
+		if err != nil {
+			return err
+		}
+		if err := storage.Put(ctx, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func storageVersion(ctx context.Context, storage logical.Storage) (int, error) {
+	entry, err := storage.Get(ctx, storageVersionKey)
+	if err != nil {
+		return 0, err
+	}
+	if entry == nil {
+		return 0, nil
+	}
+	versionEntry := &storageVersionEntry{}
+	if err := entry.DecodeJSON(versionEntry); err != nil {
+		return 0, err
+	}
+	return versionEntry.Version, nil
+}
+
+// upgradeConfigFields stores the config with its pcf_* fields migrated, which reading it does.
+func upgradeConfigFields(ctx context.Context, storage logical.Storage) error {
+	_, err := config(ctx, storage)
+	return err
+}
+
+// upgradeRoleTokenFields stores each role with the token settings that reading it fills in from the
+// deprecated fields. The deprecated fields are kept, since they're still returned when roles are read.
+func upgradeRoleTokenFields(ctx context.Context, storage logical.Storage) error {
+	roleNames, err := storage.List(ctx, roleStoragePrefix)
+	if err != nil {
+		return err
+	}
+	for _, roleName := range roleNames {
+		role, err := getRole(ctx, storage, roleName)
+		if err != nil {
+			return err
+		}
+		if role == nil {
+			// It was deleted since it was listed.
+			continue
+		}
+		entry, err := logical.StorageEntryJSON(roleStoragePrefix+roleName, role)
+		if err != nil {
+			return err
+		}
+		if err := storage.Put(ctx, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package cf
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestUpgradeStorage(t *testing.T) {
+	b := newTestBackend(t)
+	ctx, storage := b.ctx, b.storage
+
+	// Store a config and role as they were written before storage was versioned.
+	configEntry, err := logical.StorageEntryJSON(configStorageKey, &models.Configuration{
+		PCFAPIAddr:  "https://api.dev.cfdev.sh",
+		PCFUsername: "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roleEntry, err := logical.StorageEntryJSON(roleStoragePrefix+"test-role", &models.RoleEntry{
+		Policies: []string{"foo-policies"},
+		TTL:      time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []*logical.StorageEntry{configEntry, roleEntry} {
+		if err := storage.Put(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := storageVersion(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != currentStorageVersion {
+		t.Fatalf("expected storage to be at version %d but it's at %d", currentStorageVersion, version)
+	}
+
+	entry, err := storage.Get(ctx, configStorageKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storedConfig := &models.Configuration{}
+	if err := entry.DecodeJSON(storedConfig); err != nil {
+		t.Fatal(err)
+	}
+	if storedConfig.Version != 1 || storedConfig.CFAPIAddr != "https://api.dev.cfdev.sh" || storedConfig.CFUsername != "admin" {
+		t.Fatalf("expected the pcf_* fields to have been migrated but received %+v", storedConfig)
+	}
+
+	entry, err = storage.Get(ctx, roleStoragePrefix+"test-role")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storedRole := &models.RoleEntry{}
+	if err := entry.DecodeJSON(storedRole); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(storedRole.TokenPolicies, []string{"foo-policies"}) || storedRole.TokenTTL != time.Hour {
+		t.Fatalf("expected the token fields to have been migrated but received %+v", storedRole)
+	}
+	if !reflect.DeepEqual(storedRole.Policies, []string{"foo-policies"}) {
+		t.Fatalf("expected the deprecated fields to have been kept but received %+v", storedRole)
+	}
+
+	// Upgrades that have already run are skipped.
+	if err := storage.Put(ctx, roleEntry); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = storage.Get(ctx, roleStoragePrefix+"test-role")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entry, roleEntry) {
+		t.Fatal("expected the role not to have been upgraded again")
+	}
+}
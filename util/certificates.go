@@ -1,11 +1,17 @@
 package util
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 )
@@ -13,16 +19,20 @@ import (
 // ExtractCertificates takes the contents of the file at CF_INSTANCE_CERT, which typically are
 // comprised of two certificates. One is the identity certificate, and one is an intermediate
 // CA certificate which is crucial in linking the identity cert back to the configured root
-// certificate. It splits these two certificates apart, and identifies the certificate marked
-// as a CA as the intermediate cert, and the one not marked as a CA as the identity certificate.
-// It may error if the given file contents or certificates aren't as expected.
-func ExtractCertificates(cfInstanceCertContents string) (intermediateCert, identityCert *x509.Certificate, err error) {
-	certPairBytes := []byte(cfInstanceCertContents)
-	numCerts := 0
+// certificate. However, the file may be a longer bundle, so any number of certificates is
+// accepted. The identity certificate is selected as the leaf of the bundle: the certificate that
+// isn't marked as a CA, is usable for digital signatures, and didn't issue any of the others.
+// All remaining certificates are returned as intermediates for use in building a chain back to
+// the configured root certificates. The certificates may be PEM-encoded, or, if there are no PEM
+// blocks, base64-encoded DER. It may error if the given file contents or certificates aren't as
+// expected.
+func ExtractCertificates(cfInstanceCertContents string) (intermediateCerts []*x509.Certificate, identityCert *x509.Certificate, err error) {
+	certBundleBytes := []byte(cfInstanceCertContents)
+	var allCerts []*x509.Certificate
 	var block *pem.Block
 	var result error
 	for {
-		block, certPairBytes = pem.Decode(certPairBytes)
+		block, certBundleBytes = pem.Decode(certBundleBytes)
 		if block == nil {
 			break
 		}
@@ -31,43 +41,134 @@ func ExtractCertificates(cfInstanceCertContents string) (intermediateCert, ident
 			result = multierror.Append(result, err)
 			continue
 		}
-		for _, cert := range certs {
-			if cert.IsCA {
-				intermediateCert = cert
-			} else {
-				identityCert = cert
-			}
-			numCerts++
+		allCerts = append(allCerts, certs...)
+	}
+	if len(allCerts) == 0 && result == nil {
+		if certs, err := ParseBase64DERCertificates(cfInstanceCertContents); err == nil {
+			allCerts = certs
 		}
 	}
-	if numCerts != 2 {
-		result = multierror.Append(fmt.Errorf("expected 2 certs but received %s", certPairBytes))
+	if len(allCerts) == 0 {
+		return nil, nil, multierror.Append(result, fmt.Errorf("no certificates found in %s", cfInstanceCertContents))
+	}
+
+	var leaves []*x509.Certificate
+	for _, cert := range allCerts {
+		if isLeaf(cert, allCerts) {
+			leaves = append(leaves, cert)
+		}
+	}
+	switch len(leaves) {
+	case 0:
+		return nil, nil, multierror.Append(result, fmt.Errorf("no identity cert found in %s", cfInstanceCertContents))
+	case 1:
+		identityCert = leaves[0]
+	default:
+		return nil, nil, multierror.Append(result, fmt.Errorf("expected 1 identity cert but found %d in %s", len(leaves), cfInstanceCertContents))
+	}
+	for _, cert := range allCerts {
+		if cert != identityCert {
+			intermediateCerts = append(intermediateCerts, cert)
+		}
+	}
+	return intermediateCerts, identityCert, result
+}
+
+// ParseCertificates parses every certificate in the given PEM-format contents, which may be
+// a bundle of several, such as a configured identity CA certificate.
+func ParseCertificates(pemContents string) ([]*x509.Certificate, error) {
+	rest := []byte(pemContents)
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		parsed, err := x509.ParseCertificates(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, parsed...)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM-format certificates found")
+	}
+	return certs, nil
+}
+
+// ParseBase64DERCertificates parses certificates given as base64-encoded DER rather than PEM, as
+// some platforms' APIs return them. Several certificates may be concatenated before encoding.
+// Whitespace, such as line breaks, is ignored.
+func ParseBase64DERCertificates(contents string) ([]*x509.Certificate, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(contents), ""))
+	if err != nil {
+		return nil, err
+	}
+	certs, err := x509.ParseCertificates(der)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	return certs, nil
+}
+
+// isLeaf returns whether the given certificate looks like the end of a chain within the bundle
+// it was found in.
+func isLeaf(cert *x509.Certificate, bundle []*x509.Certificate) bool {
+	if cert.IsCA {
+		return false
 	}
-	if intermediateCert == nil {
-		result = multierror.Append(fmt.Errorf("no intermediate certificate found in %s", certPairBytes))
+	// A KeyUsage of 0 means it was unspecified, in which case the cert may be used for anything.
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return false
 	}
-	if identityCert == nil {
-		result = multierror.Append(fmt.Errorf("no identity cert found in %s", certPairBytes))
+	for _, other := range bundle {
+		if other == cert {
+			continue
+		}
+		if other.CheckSignatureFrom(cert) == nil {
+			return false
+		}
 	}
-	return intermediateCert, identityCert, result
+	return true
 }
 
-// Validate takes a group of trusted CA certificates, an intermediate certificate, an identity certificate,
+// Validate takes a group of trusted CA certificates, intermediate certificates, an identity certificate,
 // and a signing certificate, and makes sure they have the following properties:
 //   - The identity certificate is the same as the signing certificate
 //   - The identity certificate chains to at least one trusted CA
-func Validate(caCerts []string, intermediateCert, identityCert, signingCert *x509.Certificate) error {
-	if !reflect.DeepEqual(identityCert, signingCert) {
-		return errors.New("signature not generated by identity cert")
+func Validate(caCerts []string, intermediateCerts []*x509.Certificate, identityCert, signingCert *x509.Certificate) error {
+	roots, err := NewCertPool(caCerts)
+	if err != nil {
+		return err
 	}
+	return ValidateWithRoots(roots, intermediateCerts, identityCert, signingCert)
+}
+
+// NewCertPool returns a pool of the given PEM-format CA certificates, for use with ValidateWithRoots.
+func NewCertPool(caCerts []string) (*x509.CertPool, error) {
 	roots := x509.NewCertPool()
 	for _, caCert := range caCerts {
 		if ok := roots.AppendCertsFromPEM([]byte(caCert)); !ok {
-			return errors.New("couldn't append root certificate")
+			return nil, errors.New("couldn't append root certificate")
 		}
 	}
+	return roots, nil
+}
+
+// ValidateWithRoots is like Validate, but takes trusted CA certificates that have already been
+// parsed into a pool, so that callers validating many certificates needn't parse them each time.
+func ValidateWithRoots(roots *x509.CertPool, intermediateCerts []*x509.Certificate, identityCert, signingCert *x509.Certificate) error {
+	if !reflect.DeepEqual(identityCert, signingCert) {
+		return errors.New("signature not generated by identity cert")
+	}
 	intermediates := x509.NewCertPool()
-	intermediates.AddCert(intermediateCert)
+	for _, intermediateCert := range intermediateCerts {
+		intermediates.AddCert(intermediateCert)
+	}
 	verifyOpts := x509.VerifyOptions{
 		Roots:         roots,
 		Intermediates: intermediates,
@@ -77,3 +178,35 @@ func Validate(caCerts []string, intermediateCert, identityCert, signingCert *x50
 	}
 	return nil
 }
+
+// CheckKeyStrength makes sure the certificate's public key is at least as strong as required.
+// RSA keys must have at least minRSABits bits, and ECDSA keys must be on a curve of at least
+// minECDSABits bits. A minimum of 0 means any size is accepted. Ed25519 keys are always accepted.
+func CheckKeyStrength(cert *x509.Certificate, minRSABits, minECDSABits int) error {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if bits := key.N.BitLen(); bits < minRSABits {
+			return fmt.Errorf("certificate's %d bit RSA key is weaker than the minimum of %d bits", bits, minRSABits)
+		}
+	case *ecdsa.PublicKey:
+		if bits := key.Curve.Params().BitSize; bits < minECDSABits {
+			return fmt.Errorf("certificate's ECDSA key on curve %s is weaker than the minimum of %d bits", key.Curve.Params().Name, minECDSABits)
+		}
+	case ed25519.PublicKey:
+	default:
+		return fmt.Errorf("unsupported public key type %T", cert.PublicKey)
+	}
+	return nil
+}
+
+// CheckValidityPeriod makes sure the certificate isn't valid for longer than maxPeriod in total,
+// from its NotBefore to its NotAfter. A maximum of 0 means any period is accepted.
+func CheckValidityPeriod(cert *x509.Certificate, maxPeriod time.Duration) error {
+	if maxPeriod <= 0 {
+		return nil
+	}
+	if period := cert.NotAfter.Sub(cert.NotBefore); period > maxPeriod {
+		return fmt.Errorf("certificate is valid for %s, which is longer than the maximum of %s", period, maxPeriod)
+	}
+	return nil
+}
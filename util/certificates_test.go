@@ -1,8 +1,16 @@
 package util
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"io/ioutil"
 	"testing"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/testing/certificates"
 )
 
 func TestExtractCertificates(t *testing.T) {
@@ -10,16 +18,119 @@ func TestExtractCertificates(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	intermediate, identity, err := ExtractCertificates(string(sampleCertBytes))
+	intermediates, identity, err := ExtractCertificates(string(sampleCertBytes))
 	if err != nil {
 		t.Fatal(err)
 	}
+	if len(intermediates) != 1 {
+		t.Fatalf("expected 1 intermediate but received %d", len(intermediates))
+	}
 	expected := "CN=instanceIdentityCA,O=Cloud Foundry,C=USA"
-	if intermediate.Subject.String() != expected {
-		t.Fatalf("expected %q but received %q", expected, intermediate.Subject.String())
+	if intermediates[0].Subject.String() != expected {
+		t.Fatalf("expected %q but received %q", expected, intermediates[0].Subject.String())
 	}
 	expected = "CN=f9c7cd7d-1612-4f57-63a8-f995,OU=organization:34a878d0-c2f9-4521-ba73-a9f664e82c7b+OU=space:3d2eba6b-ef19-44d5-91dd-1975b0db5cc9+OU=app:2d3e834a-3a25-4591-974c-fa5626d5d0a1"
 	if identity.Subject.String() != expected {
 		t.Fatalf("expected %q but received %q", expected, identity.Subject.String())
 	}
 }
+
+func TestExtractCertificatesFromBundle(t *testing.T) {
+	testCerts, err := certificates.Generate("instance-id", "org-id", "space-id", "app-id", "10.255.181.105")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := testCerts.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Put the identity certificate in the middle of a longer bundle to make sure
+	// it's selected by its position in the chain rather than its position in the file.
+	bundles := map[string]string{
+		"root appended":  testCerts.InstanceCertificate + testCerts.CACertificate,
+		"root prepended": testCerts.CACertificate + testCerts.InstanceCertificate,
+	}
+	for name, bundle := range bundles {
+		t.Run(name, func(t *testing.T) {
+			intermediates, identity, err := ExtractCertificates(bundle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(intermediates) != 2 {
+				t.Fatalf("expected 2 intermediates but received %d", len(intermediates))
+			}
+			if identity.Subject.CommonName != "instance-id" {
+				t.Fatalf("expected %q but received %q", "instance-id", identity.Subject.CommonName)
+			}
+			if err := Validate([]string{testCerts.CACertificate}, intermediates, identity, identity); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCheckValidityPeriod(t *testing.T) {
+	notBefore := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := &x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.Add(24 * time.Hour)}
+	for _, tc := range []struct {
+		maxPeriod time.Duration
+		expectErr bool
+	}{
+		{0, false},
+		{24 * time.Hour, false},
+		{48 * time.Hour, false},
+		{time.Hour, true},
+	} {
+		err := CheckValidityPeriod(cert, tc.maxPeriod)
+		if tc.expectErr && err == nil {
+			t.Fatalf("expected an error with a maximum of %s", tc.maxPeriod)
+		}
+		if !tc.expectErr && err != nil {
+			t.Fatalf("unexpected error with a maximum of %s: %s", tc.maxPeriod, err)
+		}
+	}
+}
+
+func TestCheckKeyStrength(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p224Key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaCert := &x509.Certificate{PublicKey: &rsaKey.PublicKey}
+	p224Cert := &x509.Certificate{PublicKey: &p224Key.PublicKey}
+	p256Cert := &x509.Certificate{PublicKey: &p256Key.PublicKey}
+
+	for _, tc := range []struct {
+		cert         *x509.Certificate
+		minRSABits   int
+		minECDSABits int
+		expectErr    bool
+	}{
+		{rsaCert, 0, 0, false},
+		{rsaCert, 1024, 0, false},
+		{rsaCert, 2048, 0, true},
+		{rsaCert, 0, 384, false},
+		{p224Cert, 0, 0, false},
+		{p224Cert, 0, 256, true},
+		{p256Cert, 0, 256, false},
+		{p256Cert, 2048, 384, true},
+	} {
+		err := CheckKeyStrength(tc.cert, tc.minRSABits, tc.minECDSABits)
+		if tc.expectErr && err == nil {
+			t.Fatalf("expected an error for %T with minimums %d and %d", tc.cert.PublicKey, tc.minRSABits, tc.minECDSABits)
+		}
+		if !tc.expectErr && err != nil {
+			t.Fatalf("unexpected error for %T with minimums %d and %d: %s", tc.cert.PublicKey, tc.minRSABits, tc.minECDSABits, err)
+		}
+	}
+}
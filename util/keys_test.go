@@ -0,0 +1,47 @@
+package util
+
+import (
+	"crypto/rsa"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParsePrivateKey(t *testing.T) {
+	readKey := func(name string) []byte {
+		keyBytes, err := ioutil.ReadFile("../testdata/keys/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keyBytes
+	}
+	expected, err := ParsePrivateKey(readKey("rsa.key"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passphrase := []byte("passphrase")
+	for _, name := range []string{"pkcs8.key", "pkcs8-encrypted.key", "rsa-encrypted.key"} {
+		t.Run(name, func(t *testing.T) {
+			key, err := ParsePrivateKey(readKey(name), passphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rsaKey, ok := key.(*rsa.PrivateKey)
+			if !ok || rsaKey.D.Cmp(expected.(*rsa.PrivateKey).D) != 0 {
+				t.Fatal("expected the parsed key to match the unencrypted PKCS #1 key")
+			}
+		})
+	}
+
+	for _, name := range []string{"pkcs8-encrypted.key", "rsa-encrypted.key"} {
+		t.Run(name+" without passphrase", func(t *testing.T) {
+			if _, err := ParsePrivateKey(readKey(name), nil); err == nil {
+				t.Fatal("expected an error parsing an encrypted key without a passphrase")
+			}
+		})
+	}
+
+	if _, err := ParsePrivateKey(readKey("pkcs8-encrypted.key"), []byte("wrong")); err == nil {
+		t.Fatal("expected an error parsing an encrypted key with the wrong passphrase")
+	}
+}
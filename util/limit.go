@@ -0,0 +1,78 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// DefaultCFAPIQueueTimeout is how long requests wait for one of the allowed concurrent CF API
+// requests to finish when no timeout is configured.
+const DefaultCFAPIQueueTimeout = 10 * time.Second
+
+// limitTransport limits how many requests are made to the CF API at once, so that a burst of
+// logins can't overwhelm the Cloud Controller. Requests beyond the limit wait their turn, and
+// fail if they've waited longer than the timeout.
+type limitTransport struct {
+	next    http.RoundTripper
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+func newLimitTransport(next http.RoundTripper, maxConcurrent int, timeout time.Duration) *limitTransport {
+	if timeout <= 0 {
+		timeout = DefaultCFAPIQueueTimeout
+	}
+	return &limitTransport{
+		next:    next,
+		slots:   make(chan struct{}, maxConcurrent),
+		timeout: timeout,
+	}
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+	select {
+	case t.slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	case <-timer.C:
+		metrics.IncrCounter([]string{"cf", "api", "queue_timeout"}, 1)
+		return nil, fmt.Errorf("timed out after %s waiting for one of the %d allowed concurrent CF API requests to finish", t.timeout, cap(t.slots))
+	}
+	metrics.MeasureSince([]string{"cf", "api", "queue_time"}, start)
+	metrics.SetGauge([]string{"cf", "api", "in_flight"}, float32(len(t.slots)))
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.release()
+		return nil, err
+	}
+	// The request is still in flight until its body has been read.
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: t.release}
+	return resp, nil
+}
+
+func (t *limitTransport) release() {
+	<-t.slots
+	metrics.SetGauge([]string{"cf", "api", "in_flight"}, float32(len(t.slots)))
+}
+
+// releasingBody releases its request's slot once it's closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
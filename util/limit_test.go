@@ -0,0 +1,43 @@
+package util
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestLimitTransport(t *testing.T) {
+	next := roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("{}"))}, nil
+	})
+	transport := newLimitTransport(next, 1, 10*time.Millisecond)
+	req, err := http.NewRequest(http.MethodGet, "https://api.dev.cfdev.sh/v2/info", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first request is in flight until its body is closed.
+	if _, err := transport.RoundTrip(req); err == nil {
+		t.Fatal("expected a request beyond the limit to time out")
+	}
+	if err := resp.Body.Close(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("expected a request to be made once the limit allowed it but received %s", err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,42 @@
+package util
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// metricsTransport records the latency and outcome of each request made to the CF API.
+type metricsTransport struct {
+	next http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	labels := []metrics.Label{{Name: "operation", Value: operation(req)}}
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	metrics.MeasureSinceWithLabels([]string{"cf", "api", "request"}, start, labels)
+
+	switch {
+	case err != nil:
+		metrics.IncrCounterWithLabels([]string{"cf", "api", "error"}, 1, append(labels, metrics.Label{Name: "status", Value: "none"}))
+	case resp.StatusCode >= 400:
+		metrics.IncrCounterWithLabels([]string{"cf", "api", "error"}, 1, append(labels, metrics.Label{Name: "status", Value: strconv.Itoa(resp.StatusCode)}))
+	}
+	return resp, err
+}
+
+// operation describes the kind of request made, such as "GET v2/apps/:guid", without the
+// GUIDs that would give each app its own label.
+func operation(req *http.Request) string {
+	fields := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	for i, field := range fields {
+		if strings.Contains(field, "-") {
+			fields[i] = ":guid"
+		}
+	}
+	return req.Method + " " + strings.Join(fields, "/")
+}
@@ -0,0 +1,23 @@
+package util
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestOperation(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/v2/info": "GET v2/info",
+		"/v2/apps/2d3e834a-3a25-4591-974c-fa5626d5d0a1":                         "GET v2/apps/:guid",
+		"/v3/apps/2d3e834a-3a25-4591-974c-fa5626d5d0a1/processes/web/stats":     "GET v3/apps/:guid/processes/web/stats",
+		"/v2/organizations/34a878d0-c2f9-4521-ba73-a9f664e82c7bf/private_thing": "GET v2/organizations/:guid/private_thing",
+	} {
+		req, err := http.NewRequest(http.MethodGet, "https://api.example.com"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := operation(req); actual != expected {
+			t.Errorf("expected %q for %s but received %q", expected, path, actual)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package util
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// These headers carry the ID of the Vault request to the CF API. Cloud Controller logs the
+// X-Vcap-Request-Id it receives, but the gorouter replaces it with one derived from the B3 trace ID
+// when tracing's enabled, so the ID's sent as both.
+const (
+	HeaderVcapRequestID = "X-Vcap-Request-Id"
+	HeaderB3TraceID     = "X-B3-TraceId"
+	HeaderB3SpanID      = "X-B3-SpanId"
+)
+
+// WithRequestID returns a copy of the client whose requests to the CF API carry the given request ID,
+// so the calls made while handling a Vault request can be found in Cloud Controller's logs. The copy
+// shares the client's connections and credentials. If the request ID is empty, the client is returned.
+func WithRequestID(client *cfclient.Client, requestID string) *cfclient.Client {
+	if client == nil || requestID == "" || client.Config.HttpClient == nil {
+		return client
+	}
+	httpClient := *client.Config.HttpClient
+	next := httpClient.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	httpClient.Transport = &requestIDTransport{next: next, requestID: requestID}
+
+	withRequestID := *client
+	withRequestID.Config.HttpClient = &httpClient
+	return &withRequestID
+}
+
+type requestIDTransport struct {
+	next      http.RoundTripper
+	requestID string
+}
+
+func (t *requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A round tripper mustn't change the request it's given.
+	req = req.Clone(req.Context())
+	req.Header.Set(HeaderVcapRequestID, t.requestID)
+	// Vault's request IDs are UUIDs, whose hex digits make a valid 128-bit trace ID.
+	if traceID := strings.Replace(t.requestID, "-", "", -1); isB3TraceID(traceID) {
+		req.Header.Set(HeaderB3TraceID, traceID)
+		req.Header.Set(HeaderB3SpanID, traceID[16:])
+	}
+	return t.next.RoundTrip(req)
+}
+
+func isB3TraceID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	for _, c := range id {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
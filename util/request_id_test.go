@@ -0,0 +1,50 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+func TestWithRequestID(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+	}))
+	defer server.Close()
+
+	client := &cfclient.Client{Config: cfclient.Config{ApiAddress: server.URL, HttpClient: server.Client()}}
+	withRequestID := WithRequestID(client, "0f6ab6f8-5f9b-4c1b-9b3a-3c2d8a3c9e71")
+	if _, err := withRequestID.DoRequest(withRequestID.NewRequest("GET", "/v2/info")); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		HeaderVcapRequestID: "0f6ab6f8-5f9b-4c1b-9b3a-3c2d8a3c9e71",
+		HeaderB3TraceID:     "0f6ab6f85f9b4c1b9b3a3c2d8a3c9e71",
+		HeaderB3SpanID:      "9b3a3c2d8a3c9e71",
+	}
+	for header, value := range expected {
+		if received.Get(header) != value {
+			t.Errorf("expected %s to be %q but received %q", header, value, received.Get(header))
+		}
+	}
+
+	// The shared client is left as it was.
+	if _, err := client.DoRequest(client.NewRequest("GET", "/v2/info")); err != nil {
+		t.Fatal(err)
+	}
+	if received.Get(HeaderVcapRequestID) != "" {
+		t.Fatalf("expected no request ID but received %q", received.Get(HeaderVcapRequestID))
+	}
+
+	// IDs that aren't UUIDs can't be trace IDs.
+	withRequestID = WithRequestID(client, "not-a-uuid")
+	if _, err := withRequestID.DoRequest(withRequestID.NewRequest("GET", "/v2/info")); err != nil {
+		t.Fatal(err)
+	}
+	if received.Get(HeaderVcapRequestID) != "not-a-uuid" || received.Get(HeaderB3TraceID) != "" {
+		t.Fatalf("expected only the request ID but received %v", received)
+	}
+}
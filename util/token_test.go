@@ -0,0 +1,29 @@
+package util
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestTokenExpiry(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"jti":"094ead4e8bac479582f026fc0250506c-r","exp":1560967089,"grant_type":"password"}`))
+	expiry, ok := TokenExpiry("eyJhbGciOiJSUzI1NiJ9." + payload + ".c2lnbmF0dXJl")
+	if !ok {
+		t.Fatal("expected the expiry to be read")
+	}
+	if !expiry.Equal(time.Unix(1560967089, 0)) {
+		t.Fatalf("expected 2019-06-19T17:58:09Z but received %s", expiry)
+	}
+
+	for _, token := range []string{
+		"",
+		"opaque-token",
+		"eyJhbGciOiJSUzI1NiJ9.not-base64!.c2lnbmF0dXJl",
+		"eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(`{"jti":"1"}`)) + ".c2lnbmF0dXJl",
+	} {
+		if _, ok := TokenExpiry(token); ok {
+			t.Fatalf("expected no expiry to be read from %q", token)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+)
+
+// The settings of the transport to the CF API that are used when they aren't configured.
+const (
+	DefaultCFAPIMaxIdleConnections    = 10
+	DefaultCFAPIIdleConnectionTimeout = 90 * time.Second
+	DefaultCFAPITLSHandshakeTimeout   = 10 * time.Second
+)
+
+// userAgentTransport sets the User-Agent of requests that don't have one.
+type userAgentTransport struct {
+	next      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		// A round tripper mustn't change the request it's given.
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// newTransport returns the transport to the CF API and UAA, tuned by the config. Go's defaults only keep
+// two idle connections to each host, so a busy mount would otherwise open a new connection, and negotiate
+// TLS again, for most of its requests. Idle connections are closed after the timeout, so those held by the
+// transport of a client that's been replaced don't stay open.
+func newTransport(config *models.Configuration, tlsConfig *tls.Config) *http.Transport {
+	maxIdleConnections := config.CFAPIMaxIdleConnections
+	if maxIdleConnections == 0 {
+		maxIdleConnections = DefaultCFAPIMaxIdleConnections
+	}
+	idleConnectionTimeout := config.CFAPIIdleConnectionTimeout
+	if idleConnectionTimeout == 0 {
+		idleConnectionTimeout = DefaultCFAPIIdleConnectionTimeout
+	}
+	tlsHandshakeTimeout := config.CFAPITLSHandshakeTimeout
+	if tlsHandshakeTimeout == 0 {
+		tlsHandshakeTimeout = DefaultCFAPITLSHandshakeTimeout
+	}
+	return &http.Transport{
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConnsPerHost: maxIdleConnections,
+		IdleConnTimeout:     idleConnectionTimeout,
+		TLSHandshakeTimeout: tlsHandshakeTimeout,
+	}
+}
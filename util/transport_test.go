@@ -0,0 +1,71 @@
+package util
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault-plugin-auth-cf/testing/cf"
+	"github.com/hashicorp/vault-plugin-auth-cf/version"
+)
+
+func TestNewTransport(t *testing.T) {
+	tlsConfig := &tls.Config{}
+	transport := newTransport(&models.Configuration{}, tlsConfig)
+	if transport.TLSClientConfig != tlsConfig {
+		t.Fatal("expected the TLS config to be used")
+	}
+	if transport.MaxIdleConnsPerHost != DefaultCFAPIMaxIdleConnections || transport.IdleConnTimeout != DefaultCFAPIIdleConnectionTimeout || transport.TLSHandshakeTimeout != DefaultCFAPITLSHandshakeTimeout {
+		t.Fatalf("expected the defaults but received %+v", transport)
+	}
+
+	transport = newTransport(&models.Configuration{
+		CFAPIMaxIdleConnections:    50,
+		CFAPIIdleConnectionTimeout: time.Minute,
+		CFAPITLSHandshakeTimeout:   5 * time.Second,
+	}, tlsConfig)
+	if transport.MaxIdleConnsPerHost != 50 || transport.IdleConnTimeout != time.Minute || transport.TLSHandshakeTimeout != 5*time.Second {
+		t.Fatalf("expected the configured settings but received %+v", transport)
+	}
+}
+
+func TestNewCFClientWithTransportWrapper(t *testing.T) {
+	cfServer := cf.NewServer(cf.DefaultFoundation())
+	defer cfServer.Close()
+
+	var lock sync.Mutex
+	userAgents := make(map[string]string)
+	wrap := func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			lock.Lock()
+			userAgents[req.URL.Path] = req.Header.Get("User-Agent")
+			lock.Unlock()
+			return next.RoundTrip(req)
+		})
+	}
+	client, err := NewCFClientWithTransportWrapper(&models.Configuration{
+		CFAPIAddr:  cfServer.URL,
+		CFUsername: cf.AuthUsername,
+		CFPassword: cf.AuthPassword,
+	}, wrap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetOrgByGuid(cf.FoundOrgGUID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests for tokens from UAA are wrapped and identified too.
+	for _, path := range []string{"/v2/info", "/oauth/token", "/v2/organizations/" + cf.FoundOrgGUID} {
+		userAgent, ok := userAgents[path]
+		if !ok {
+			t.Fatalf("expected the request to %s to be wrapped but received %v", path, userAgents)
+		}
+		if userAgent != version.UserAgent() {
+			t.Fatalf("expected the request to %s to be made by %q but received %q", path, version.UserAgent(), userAgent)
+		}
+	}
+}
@@ -9,13 +9,32 @@ import (
 	"github.com/cloudfoundry-community/go-cfclient"
 	"github.com/hashicorp/go-cleanhttp"
 	"github.com/hashicorp/vault-plugin-auth-cf/models"
+	"github.com/hashicorp/vault-plugin-auth-cf/version"
 )
 
 const BashTimeFormat = "Mon Jan 2 15:04:05 MST 2006"
 
+// PowerShellTimeFormat is the default output of PowerShell's Get-Date in the en-US culture,
+// and PowerShellShortTimeFormat is what's output by its ToString() method. Neither carries
+// a time zone, so values in these formats are interpreted as UTC, ex. by using
+// "(Get-Date).ToUniversalTime()".
+const (
+	PowerShellTimeFormat      = "Monday, January 2, 2006 3:04:05 PM"
+	PowerShellShortTimeFormat = "1/2/2006 3:04:05 PM"
+)
+
+// TransportWrapper wraps the transport of a CF API client, such as to instrument its requests.
+type TransportWrapper func(next http.RoundTripper) http.RoundTripper
+
 // NewCFClient does some work that's needed every time we use the CF client,
 // namely using cleanhttp and configuring it to match the user conf.
 func NewCFClient(config *models.Configuration) (*cfclient.Client, error) {
+	return NewCFClientWithTransportWrapper(config, nil)
+}
+
+// NewCFClientWithTransportWrapper is like NewCFClient, but passes every request the client makes to
+// the CF API and UAA through the transport returned by wrap, if it isn't nil.
+func NewCFClientWithTransportWrapper(config *models.Configuration, wrap TransportWrapper) (*cfclient.Client, error) {
 	clientConf := &cfclient.Config{
 		ApiAddress:   config.CFAPIAddr,
 		Username:     config.CFUsername,
@@ -23,6 +42,7 @@ func NewCFClient(config *models.Configuration) (*cfclient.Client, error) {
 		ClientID:     config.CFClientID,
 		ClientSecret: config.CFClientSecret,
 		HttpClient:   cleanhttp.DefaultClient(),
+		UserAgent:    version.UserAgent(),
 	}
 	rootCAs, err := x509.SystemCertPool()
 	if err != nil {
@@ -53,6 +73,15 @@ func NewCFClient(config *models.Configuration) (*cfclient.Client, error) {
 		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
 
-	clientConf.HttpClient.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	var transport http.RoundTripper = newTransport(config, tlsConfig)
+	if config.CFAPIMaxConcurrentRequests > 0 {
+		transport = newLimitTransport(transport, config.CFAPIMaxConcurrentRequests, config.CFAPIQueueTimeout)
+	}
+	transport = &metricsTransport{next: transport}
+	if wrap != nil {
+		transport = wrap(transport)
+	}
+	// The client only sets its User-Agent on requests to the CF API, not on those for tokens from UAA.
+	clientConf.HttpClient.Transport = &userAgentTransport{next: transport, userAgent: clientConf.UserAgent}
 	return cfclient.NewClient(clientConf)
 }
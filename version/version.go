@@ -0,0 +1,15 @@
+// Package version identifies the build of the plugin.
+package version
+
+// GitCommit is the commit the plugin was built from. It's set by scripts/build.sh, and is empty in
+// other builds.
+var GitCommit string
+
+// UserAgent returns the User-Agent sent with the plugin's requests to the CF API and UAA, so that
+// they can be told apart from other clients' in their logs.
+func UserAgent() string {
+	if GitCommit == "" {
+		return "vault-plugin-auth-cf"
+	}
+	return "vault-plugin-auth-cf (" + GitCommit + ")"
+}